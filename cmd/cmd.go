@@ -8,11 +8,19 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/UnendingLoop/CommentTree/internal/api"
+	"github.com/UnendingLoop/CommentTree/internal/auth"
+	"github.com/UnendingLoop/CommentTree/internal/model"
+	"github.com/UnendingLoop/CommentTree/internal/mwapikey"
+	"github.com/UnendingLoop/CommentTree/internal/mwauth"
+	"github.com/UnendingLoop/CommentTree/internal/mwclient"
 	"github.com/UnendingLoop/CommentTree/internal/mwlogger"
+	"github.com/UnendingLoop/CommentTree/internal/ratelimit"
 	"github.com/UnendingLoop/CommentTree/internal/repository"
 	"github.com/UnendingLoop/CommentTree/internal/service"
 
@@ -24,10 +32,26 @@ import (
 
 func StartApp() {
 	// Reading configs
-	appConfig := config.New()
-	appConfig.EnableEnv("")
-	if err := appConfig.LoadEnvFiles("./.env"); err != nil {
-		log.Fatalf("Failed to load envs: %s\nExiting app...", err)
+	appConfig := loadConfig()
+	appConfig.SetDefault("PURGE_INTERVAL", time.Hour)
+	appConfig.SetDefault("PURGE_RETENTION", 30*24*time.Hour)
+	appConfig.SetDefault("REACTIONS", strings.Join(service.DefaultReactions, ","))
+	appConfig.SetDefault("AUTH_TOKEN_TTL", 24*time.Hour)
+	appConfig.SetDefault("PREMODERATION", false)
+	appConfig.SetDefault("REPORT_THRESHOLD", service.DefaultReportThreshold)
+	appConfig.SetDefault("FILTER_MAX_LENGTH", service.DefaultMaxLength)
+	appConfig.SetDefault("SPAM_THRESHOLD", service.DefaultSpamThreshold)
+	appConfig.SetDefault("SPAM_MIN_TRAINING", service.DefaultSpamMinTraining)
+	appConfig.SetDefault("RATE_LIMIT_STORE", "memory")
+	appConfig.SetDefault("RATE_LIMIT_AUTHOR", "10/1m")
+	appConfig.SetDefault("RATE_LIMIT_IP", "30/1m")
+	appConfig.SetDefault("RATE_LIMIT_THREAD", "300/1m")
+	appConfig.SetDefault("RATE_LIMIT_TRUST_PROXY", false)
+	switch secret := appConfig.GetString("AUTH_SECRET"); {
+	case secret == "":
+		log.Fatalf("AUTH_SECRET is not set, generate one with \"openssl rand -hex 32\" and put it in .env\nExiting app...")
+	case slices.Contains(sampleSecrets, secret):
+		log.Fatalf("AUTH_SECRET is a sample value, generate one with \"openssl rand -hex 32\" and put it in .env\nExiting app...")
 	}
 
 	// Connecting to database
@@ -36,34 +60,148 @@ func StartApp() {
 	// Creating Repository
 	repo := repository.NewPostgresRepo(dbConn)
 
+	// Creating content filters
+	filters, err := service.NewContentFilters(service.FilterConfig{
+		Blocklist:       strings.Split(appConfig.GetString("FILTER_BLOCKLIST"), ","),
+		BlocklistAction: appConfig.GetString("FILTER_BLOCKLIST_ACTION"),
+		MaxLinks:        appConfig.GetInt("FILTER_MAX_LINKS"),
+		LinksAction:     appConfig.GetString("FILTER_LINKS_ACTION"),
+		MaxRepeat:       appConfig.GetInt("FILTER_MAX_REPEAT"),
+		RepeatAction:    appConfig.GetString("FILTER_REPEAT_ACTION"),
+		MaxLength:       appConfig.GetInt("FILTER_MAX_LENGTH"),
+		LengthAction:    appConfig.GetString("FILTER_LENGTH_ACTION"),
+	})
+	if err != nil {
+		log.Fatalf("Failed to configure content filters: %s\nExiting app...", err)
+	}
+
+	// Creating rate limits for comment creation: memory - per instance, postgres - shared by all instances
+	authorRule, errAuthor := ratelimit.ParseRule(appConfig.GetString("RATE_LIMIT_AUTHOR"))
+	ipRule, errIP := ratelimit.ParseRule(appConfig.GetString("RATE_LIMIT_IP"))
+	threadRule, errThread := ratelimit.ParseRule(appConfig.GetString("RATE_LIMIT_THREAD"))
+	if err := errors.Join(errAuthor, errIP, errThread); err != nil {
+		log.Fatalf("Failed to parse rate limits: %s\nExiting app...", err)
+	}
+	limits := service.RateLimitConfig{Author: authorRule, IP: ipRule, Thread: threadRule}
+	ttl := max(authorRule.Per, ipRule.Per, threadRule.Per)
+	switch store := appConfig.GetString("RATE_LIMIT_STORE"); store {
+	case "memory":
+		limits.Store = ratelimit.NewMemoryStore(ttl)
+	case "postgres":
+		limits.Store = repository.NewRateLimitRepo(dbConn, ttl)
+	case "off":
+	default:
+		log.Fatalf("Unknown RATE_LIMIT_STORE %q, expected memory, postgres or off\nExiting app...", store)
+	}
+
 	// Creating Service
-	svc := service.NewCommentService(repo)
+	svc := service.NewCommentService(repo, service.Config{
+		Reactions:       strings.Split(appConfig.GetString("REACTIONS"), ","),
+		Premoderation:   appConfig.GetBool("PREMODERATION"),
+		ReportThreshold: appConfig.GetInt("REPORT_THRESHOLD"),
+		Filters:         filters,
+		Spam: service.SpamConfig{
+			Threshold:   appConfig.GetFloat64("SPAM_THRESHOLD"),
+			MinTraining: appConfig.GetInt("SPAM_MIN_TRAINING"),
+		},
+		RateLimit: limits,
+	})
+
+	// Creating users service with token issuer
+	tokens := auth.NewTokens(appConfig.GetString("AUTH_SECRET"), appConfig.GetDuration("AUTH_TOKEN_TTL"))
+	userSvc := service.NewUserService(repository.NewUserRepo(dbConn), tokens)
+
+	// Creating API keys service for server-to-server integrations
+	keySvc := service.NewAPIKeyService(repository.NewAPIKeyRepo(dbConn))
 
 	// Running DB migration
 	repository.MigrateWithRetries(dbConn.Master, "./migrations", 5, 10*time.Second)
 
+	// Creating purger of soft-deleted comments
+	purger := service.NewPurger(repo, appConfig.GetDuration("PURGE_INTERVAL"), appConfig.GetDuration("PURGE_RETENTION"))
+
 	// Creating Handlers
 	handlers := api.NewCommentHandlers(svc)
+	purgeHandlers := api.NewPurgeHandler(purger)
+	userHandlers := api.NewUserHandlers(userSvc)
+	keyHandlers := api.NewAPIKeyHandlers(keySvc)
 
 	// Configuring engine
 	mode := appConfig.GetString("GIN_MODE")
 	engine := ginext.New(mode)
+	engine.UseRawPath = true // ключ потока может быть URL статьи, закодированным в пути (%2F)
+
+	// права ключей интеграций (п.8.2) проверяются на маршруте до обработчика, права пользователей - в сервисе
+	writeScope := mwapikey.RequireScope(model.ScopeCommentsWrite)
+	deleteScope := mwapikey.RequireScope(model.ScopeCommentsDelete)
+	moderationScope := mwapikey.RequireScope(model.ScopeModeration)
 
 	engine.GET("/ping", handlers.SimplePinger)
-	engine.POST("/comments", handlers.Create)                    // создание комментария(с/без родителя)
-	engine.GET("/comments", handlers.GetAllRootComments)         // получение всех корневых комментариев с пагинацией и сортировкой через квери: ?page=1&limit=20&sort=created_at&order=ascending
-	engine.GET("/comments/:id", handlers.GetCommentWithChildren) // получение коммента по id и всех его детей
-	engine.DELETE("/comments/:id", handlers.DeleteComment)       // удаление комментария и всех вложенных под ним
-	engine.GET("/comments/search", handlers.RunSearch)           // поиск
+	engine.POST("/comments", writeScope, handlers.Create)                          // создание комментария(с/без родителя)
+	engine.GET("/comments", handlers.GetAllRootComments)                           // получение корневых комментариев с пагинацией, сортировкой и превью ответов через квери: ?page=1&limit=20&sort=created_at|author|content|top|controversial|hot&order=ascending&replies=3&depth=2
+	engine.GET("/comments/:id", handlers.GetCommentWithChildren)                   // получение коммента по id и всех его детей, порядок братьев, глубина и предки через квери: ?sort=oldest|newest|author|top|controversial|hot&depth=3&context=2
+	engine.GET("/comments/:id/children", handlers.GetChildren)                     // получение прямых детей коммента с пагинацией (page/limit или cursor) и сортировкой как у корней
+	engine.GET("/comments/:id/ancestors", handlers.GetAncestors)                   // цепочка предков коммента от корня (breadcrumbs)
+	engine.PATCH("/comments/:id", handlers.EditComment)                            // правка текста комментария, предыдущая версия уходит в историю
+	engine.GET("/comments/:id/revisions", handlers.GetRevisions)                   // история версий текста с пословным диффом между соседними версиями
+	engine.DELETE("/comments/:id", deleteScope, handlers.DeleteComment)            // удаление комментария и всех вложенных под ним
+	engine.POST("/comments/:id/restore", moderationScope, handlers.RestoreComment) // восстановление мягко удалённого комментария, восстановивший берётся из токена или ключа
+	engine.POST("/comments/:id/move", moderationScope, handlers.MoveComment)       // перенос коммента со всеми потомками под другого родителя или в корни: {"parent_id": 5|null}
+	engine.GET("/threads/:key/comments", handlers.GetThreadRootComments)           // корневые комментарии потока (статьи, товара) с теми же параметрами, что и /comments
+	engine.POST("/threads/:key/comments", writeScope, handlers.CreateInThread)     // создание комментария в потоке, ответ обязан быть в потоке родителя
+	engine.POST("/comments/:id/lock", moderationScope, handlers.LockComment)       // запрет ответов на коммент и во всей ветке под ним
+	engine.DELETE("/comments/:id/lock", moderationScope, handlers.UnlockComment)   // снятие запрета ответов с коммента
+	engine.POST("/comments/:id/pin", moderationScope, handlers.PinComment)         // закрепление корневого коммента над выдачей первой страницы
+	engine.DELETE("/comments/:id/pin", moderationScope, handlers.UnpinComment)     // открепление коммента
+	engine.PUT("/comments/:id/vote", handlers.VoteComment)                         // голос за/против коммента, один на клиента: {"value": 1|-1|0}, 0 - отозвать
+	engine.POST("/threads/:key/lock", moderationScope, handlers.LockThread)        // запрет новых комментариев и ответов во всём потоке
+	engine.DELETE("/threads/:key/lock", moderationScope, handlers.UnlockThread)    // снятие запрета с потока
+	engine.GET("/comments/search", handlers.RunSearch)                             // поиск
+	engine.GET("/purge/status", moderationScope, purgeHandlers.Status)             // статус фоновой очистки мягко удалённых комментариев, модераторам
+
+	// реакции: клиент определяется заголовком X-Client-Id, emoji - из набора REACTIONS
+	engine.PUT("/comments/:id/reactions/:emoji", handlers.AddReaction)       // поставить реакцию на коммент
+	engine.DELETE("/comments/:id/reactions/:emoji", handlers.RemoveReaction) // снять свою реакцию
+
+	// пользователи: токен из /auth/login передаётся в Authorization: Bearer <token>, автор комментария берётся из него
+	engine.POST("/auth/register", userHandlers.Register)      // регистрация: {"username": "...", "password": "..."}
+	engine.POST("/auth/login", userHandlers.Login)            // вход, в ответе токен и срок его действия
+	engine.GET("/auth/me", userHandlers.Me)                   // пользователь текущего токена
+	engine.PUT("/users/:username/role", userHandlers.SetRole) // смена роли (user/moderator/admin), только для admin
+
+	// ключи интеграций: выдаёт и отзывает admin, интеграция передаёт ключ в X-API-Key
+	engine.POST("/api-keys", keyHandlers.Create)       // выпуск ключа: {"name": "...", "scopes": ["comments:write", "comments:delete", "moderation"]}, ключ показывается один раз
+	engine.GET("/api-keys", keyHandlers.List)          // список ключей с правами и временем последнего использования
+	engine.DELETE("/api-keys/:id", keyHandlers.Revoke) // отзыв ключа
+
+	// премодерация: в отмеченных потоках (или везде при PREMODERATION=true) новые комментарии ждут решения модератора
+	engine.GET("/moderation/queue", moderationScope, handlers.GetModerationQueue)                // очередь ожидающих комментариев, старые первыми: ?thread=...&page=1&limit=20
+	engine.POST("/comments/:id/approve", moderationScope, handlers.ApproveComment)               // одобрение, в теле можно указать причину: {"reason": "..."}
+	engine.POST("/comments/:id/reject", moderationScope, handlers.RejectComment)                 // отклонение с обязательной причиной: {"reason": "..."}
+	engine.POST("/threads/:key/premoderation", moderationScope, handlers.EnablePremoderation)    // включить премодерацию потока
+	engine.DELETE("/threads/:key/premoderation", moderationScope, handlers.DisablePremoderation) // выключить премодерацию потока
+
+	// жалобы: один клиент (X-Client-Id или пользователь) - одна открытая жалоба на комментарий
+	engine.POST("/comments/:id/reports", handlers.ReportComment)                           // жалоба: {"reason": "spam|abuse|harassment|off_topic|other", "details": "..."}
+	engine.GET("/reports", moderationScope, handlers.GetReports)                           // открытые жалобы, сгруппированные по комментариям, для модератора: ?page=1&limit=20
+	engine.POST("/comments/:id/reports/resolve", moderationScope, handlers.ResolveReports) // решение по всем жалобам на коммент: {"action": "dismiss|hide|delete"}
+
 	engine.Static("/web", "./internal/web")
 
 	// Configuring logger and mw
 	zlog.InitConsole()
-	err := zlog.SetLevel("info")
+	err = zlog.SetLevel("info")
 	if err != nil {
 		log.Fatalf("Failed to init logger: %v", err)
 	}
-	loggedRouter := mwlogger.NewMWLogger(engine) // Wrapping engine into widdleware
+	// Authenticating users by bearer tokens
+	authRouter := mwauth.NewMWAuth(engine, tokens, userSvc)
+	// Authenticating integrations by API keys
+	keyRouter := mwapikey.NewMWAPIKey(authRouter, keySvc)
+	// Putting client identity from X-Client-Id into request context
+	clientRouter := mwclient.NewMWClient(keyRouter, appConfig.GetBool("RATE_LIMIT_TRUST_PROXY"))
+	// Wrapping everything into logging middleware, so auth rejections are logged with request id too
+	loggedRouter := mwlogger.NewMWLogger(clientRouter)
 
 	// Setting up server
 	srv := &http.Server{
@@ -75,6 +213,9 @@ func StartApp() {
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
+	// Purger launch
+	purger.Start()
+
 	// Server launch
 	go func() {
 		log.Printf("Server running on http://localhost%s\n", srv.Addr)
@@ -93,11 +234,11 @@ func StartApp() {
 	// Waiting for interruption to stop context to start Graceful shutdown
 	<-ctx.Done()
 
-	shutdown(srv, dbConn)
+	shutdown(srv, purger, dbConn)
 	log.Println("Exiting application...")
 }
 
-func shutdown(srv *http.Server, dbConn *dbpg.DB) {
+func shutdown(srv *http.Server, purger *service.Purger, dbConn *dbpg.DB) {
 	log.Println("Interrupt received!!! Starting shutdown sequence...")
 
 	// 5 seconds to stop HTTP-server:
@@ -109,6 +250,10 @@ func shutdown(srv *http.Server, dbConn *dbpg.DB) {
 		log.Println("HTTP server stopped")
 	}
 
+	// Stopping purger before closing DB it works with
+	purger.Stop()
+	log.Println("Purger stopped")
+
 	// Closing DB connection
 	if err := dbConn.Master.Close(); err != nil {
 		log.Println("Failed to close DB-conn correctly:", err)
@@ -116,3 +261,19 @@ func shutdown(srv *http.Server, dbConn *dbpg.DB) {
 	}
 	log.Println("DBconn closed")
 }
+
+// sampleSecrets - значения AUTH_SECRET из примеров, с ними приложение не запускается
+var sampleSecrets = []string{"change-me", "dev-secret-change-me", "secret"}
+
+// loadConfig читает переменные окружения и ./.env, если он есть; в контейнере .env в образ не копируется,
+// переменные передаёт docker compose через env_file
+func loadConfig() *config.Config {
+	appConfig := config.New()
+	appConfig.EnableEnv("")
+	if _, err := os.Stat("./.env"); err == nil {
+		if err := appConfig.LoadEnvFiles("./.env"); err != nil {
+			log.Fatalf("Failed to load envs: %s\nExiting app...", err)
+		}
+	}
+	return appConfig
+}
@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/UnendingLoop/CommentTree/internal/model"
+	"github.com/UnendingLoop/CommentTree/internal/repository"
+)
+
+// SetRole - команда "set-role <username> <role>": назначает роль напрямую в базе, минуя API.
+// Так заводится первый админ - через API роли раздаёт только уже существующий админ
+func SetRole(args []string) {
+	if len(args) != 2 {
+		log.Fatalf("Usage: commentTree set-role <username> <%s|%s|%s>", model.RoleUser, model.RoleModerator, model.RoleAdmin)
+	}
+	username, role := args[0], args[1]
+	switch role {
+	case model.RoleUser, model.RoleModerator, model.RoleAdmin:
+	default:
+		log.Fatalf("Unknown role %q", role)
+	}
+
+	appConfig := loadConfig()
+	dbConn := repository.ConnectWithRetries(appConfig, 5, 10*time.Second)
+	repository.MigrateWithRetries(dbConn.Master, "./migrations", 5, 10*time.Second)
+
+	user, err := repository.NewUserRepo(dbConn).SetUserRole(context.Background(), username, role)
+	if err != nil {
+		log.Fatalf("Failed to set role %q for user %q: %v", role, username, err)
+	}
+	log.Printf("User %d (%s) now has role %q", user.ID, user.Username, user.Role)
+}
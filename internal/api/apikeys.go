@@ -0,0 +1,61 @@
+package api
+
+import (
+	"strconv"
+
+	"github.com/UnendingLoop/CommentTree/internal/model"
+	"github.com/UnendingLoop/CommentTree/internal/service"
+
+	"github.com/wb-go/wbf/ginext"
+)
+
+type APIKeysHandler struct {
+	Service service.APIKeyService
+}
+
+func NewAPIKeyHandlers(svc service.APIKeyService) *APIKeysHandler {
+	return &APIKeysHandler{Service: svc}
+}
+
+func (h APIKeysHandler) Create(ctx *ginext.Context) {
+	var data model.APIKeyCreateData
+	if err := ctx.BindJSON(&data); err != nil {
+		ctx.JSON(400, map[string]string{"error": err.Error()})
+		return
+	}
+
+	res, err := h.Service.CreateAPIKey(ctx.Request.Context(), &data)
+	if err != nil {
+		ctx.JSON(errorCodeDefiner(err), map[string]string{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(201, res)
+}
+
+func (h APIKeysHandler) List(ctx *ginext.Context) {
+	res, err := h.Service.ListAPIKeys(ctx.Request.Context())
+	if err != nil {
+		ctx.JSON(errorCodeDefiner(err), map[string]string{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(200, res)
+}
+
+func (h APIKeysHandler) Revoke(ctx *ginext.Context) {
+	idRaw := ctx.Param("id")
+	id, err := strconv.Atoi(idRaw)
+	if err != nil {
+		ctx.JSON(400, map[string]string{"error": "failed to read API key ID"})
+		return
+	}
+
+	res, err := h.Service.RevokeAPIKey(ctx.Request.Context(), id)
+	if err != nil {
+		ctx.JSON(errorCodeDefiner(err), map[string]string{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(200, res)
+}
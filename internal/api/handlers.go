@@ -3,6 +3,7 @@ package api
 
 import (
 	"errors"
+	"math"
 	"strconv"
 
 	"github.com/UnendingLoop/CommentTree/internal/model"
@@ -25,16 +26,32 @@ func (h CommentsHandler) SimplePinger(ctx *ginext.Context) {
 }
 
 func (h CommentsHandler) Create(ctx *ginext.Context) {
+	h.createComment(ctx, "")
+}
+
+// CreateInThread - создание комментария в потоке из пути, поле thread_key в теле игнорируется
+func (h CommentsHandler) CreateInThread(ctx *ginext.Context) {
+	h.createComment(ctx, ctx.Param("key"))
+}
+
+func (h CommentsHandler) createComment(ctx *ginext.Context, thread string) {
 	var newComment model.CommentCreateData
 
 	if err := ctx.BindJSON(&newComment); err != nil {
 		ctx.JSON(400, map[string]string{"error": err.Error()})
 		return
 	}
+	if thread != "" {
+		newComment.ThreadKey = thread
+	}
 
 	res, err := h.Service.CreateComment(ctx.Request.Context(), &newComment)
 	if err != nil {
-		ctx.JSON(errorCodeDefiner(err), map[string]string{"error": err.Error()})
+		var rateErr *service.RateLimitError
+		if errors.As(err, &rateErr) { // целые секунды с округлением вверх, чтобы повтор не упёрся в тот же лимит
+			ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(rateErr.RetryAfter.Seconds()))))
+		}
+		ctx.JSON(errorCodeDefiner(err), errorBody(err))
 		return
 	}
 
@@ -42,12 +59,21 @@ func (h CommentsHandler) Create(ctx *ginext.Context) {
 }
 
 func (h CommentsHandler) GetAllRootComments(ctx *ginext.Context) {
+	h.getRootComments(ctx, model.DefaultThread)
+}
+
+func (h CommentsHandler) GetThreadRootComments(ctx *ginext.Context) {
+	h.getRootComments(ctx, ctx.Param("key"))
+}
+
+func (h CommentsHandler) getRootComments(ctx *ginext.Context, thread string) {
 	var req model.RootRequest
 
 	if err := ctx.ShouldBindQuery(&req); err != nil {
 		ctx.JSON(400, map[string]string{"error": "failed to parse query"})
 		return
 	}
+	req.Thread = thread
 
 	res, err := h.Service.GetAllRootComments(ctx.Request.Context(), &req)
 	if err != nil {
@@ -55,7 +81,30 @@ func (h CommentsHandler) GetAllRootComments(ctx *ginext.Context) {
 		return
 	}
 
-	ctx.JSON(200, res)
+	writePage(ctx, &req, res)
+}
+
+func (h CommentsHandler) GetChildren(ctx *ginext.Context) {
+	idRaw := ctx.Param("id")
+	id, err := strconv.Atoi(idRaw)
+	if err != nil {
+		ctx.JSON(400, map[string]string{"error": "failed to read comment ID"})
+		return
+	}
+
+	var req model.RootRequest
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		ctx.JSON(400, map[string]string{"error": "failed to parse query"})
+		return
+	}
+
+	res, err := h.Service.GetChildComments(ctx.Request.Context(), id, &req)
+	if err != nil {
+		ctx.JSON(errorCodeDefiner(err), map[string]string{"error": err.Error()})
+		return
+	}
+
+	writePage(ctx, &req, res)
 }
 
 func (h CommentsHandler) GetCommentWithChildren(ctx *ginext.Context) {
@@ -66,7 +115,70 @@ func (h CommentsHandler) GetCommentWithChildren(ctx *ginext.Context) {
 		return
 	}
 
-	res, err := h.Service.GetCommentWithChildren(ctx.Request.Context(), id)
+	var req model.TreeRequest
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		ctx.JSON(400, map[string]string{"error": "failed to parse query"})
+		return
+	}
+
+	res, err := h.Service.GetCommentWithChildren(ctx.Request.Context(), id, &req)
+	if err != nil {
+		ctx.JSON(errorCodeDefiner(err), map[string]string{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(200, res)
+}
+
+func (h CommentsHandler) GetAncestors(ctx *ginext.Context) {
+	idRaw := ctx.Param("id")
+	id, err := strconv.Atoi(idRaw)
+	if err != nil {
+		ctx.JSON(400, map[string]string{"error": "failed to read comment ID"})
+		return
+	}
+
+	res, err := h.Service.GetAncestors(ctx.Request.Context(), id)
+	if err != nil {
+		ctx.JSON(errorCodeDefiner(err), map[string]string{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(200, res)
+}
+
+func (h CommentsHandler) EditComment(ctx *ginext.Context) {
+	idRaw := ctx.Param("id")
+	id, err := strconv.Atoi(idRaw)
+	if err != nil {
+		ctx.JSON(400, map[string]string{"error": "failed to read comment ID"})
+		return
+	}
+
+	var data model.CommentEditData
+	if err := ctx.BindJSON(&data); err != nil {
+		ctx.JSON(400, map[string]string{"error": err.Error()})
+		return
+	}
+
+	res, err := h.Service.EditComment(ctx.Request.Context(), id, &data)
+	if err != nil {
+		ctx.JSON(errorCodeDefiner(err), errorBody(err))
+		return
+	}
+
+	ctx.JSON(200, res)
+}
+
+func (h CommentsHandler) GetRevisions(ctx *ginext.Context) {
+	idRaw := ctx.Param("id")
+	id, err := strconv.Atoi(idRaw)
+	if err != nil {
+		ctx.JSON(400, map[string]string{"error": "failed to read comment ID"})
+		return
+	}
+
+	res, err := h.Service.GetCommentRevisions(ctx.Request.Context(), id)
 	if err != nil {
 		ctx.JSON(errorCodeDefiner(err), map[string]string{"error": err.Error()})
 		return
@@ -102,6 +214,162 @@ func (h CommentsHandler) DeleteComment(ctx *ginext.Context) {
 	ctx.Status(204)
 }
 
+func (h CommentsHandler) RestoreComment(ctx *ginext.Context) {
+	idRaw := ctx.Param("id")
+	id, err := strconv.Atoi(idRaw)
+	if err != nil {
+		ctx.JSON(400, map[string]string{"error": "failed to read comment ID"})
+		return
+	}
+
+	res, err := h.Service.RestoreComment(ctx.Request.Context(), id)
+	if err != nil {
+		ctx.JSON(errorCodeDefiner(err), map[string]string{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(200, res)
+}
+
+func (h CommentsHandler) MoveComment(ctx *ginext.Context) {
+	idRaw := ctx.Param("id")
+	id, err := strconv.Atoi(idRaw)
+	if err != nil {
+		ctx.JSON(400, map[string]string{"error": "failed to read comment ID"})
+		return
+	}
+
+	var data model.CommentMoveData
+	if err := ctx.BindJSON(&data); err != nil {
+		ctx.JSON(400, map[string]string{"error": err.Error()})
+		return
+	}
+
+	res, err := h.Service.MoveComment(ctx.Request.Context(), id, &data)
+	if err != nil {
+		ctx.JSON(errorCodeDefiner(err), map[string]string{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(200, res)
+}
+
+func (h CommentsHandler) VoteComment(ctx *ginext.Context) {
+	idRaw := ctx.Param("id")
+	id, err := strconv.Atoi(idRaw)
+	if err != nil {
+		ctx.JSON(400, map[string]string{"error": "failed to read comment ID"})
+		return
+	}
+
+	var data model.CommentVoteData
+	if err := ctx.BindJSON(&data); err != nil {
+		ctx.JSON(400, map[string]string{"error": err.Error()})
+		return
+	}
+
+	res, err := h.Service.VoteComment(ctx.Request.Context(), id, &data)
+	if err != nil {
+		ctx.JSON(errorCodeDefiner(err), map[string]string{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(200, res)
+}
+
+func (h CommentsHandler) AddReaction(ctx *ginext.Context) {
+	h.setReaction(ctx, true)
+}
+
+func (h CommentsHandler) RemoveReaction(ctx *ginext.Context) {
+	h.setReaction(ctx, false)
+}
+
+func (h CommentsHandler) setReaction(ctx *ginext.Context, on bool) {
+	idRaw := ctx.Param("id")
+	id, err := strconv.Atoi(idRaw)
+	if err != nil {
+		ctx.JSON(400, map[string]string{"error": "failed to read comment ID"})
+		return
+	}
+
+	res, err := h.Service.SetReaction(ctx.Request.Context(), id, ctx.Param("emoji"), on)
+	if err != nil {
+		ctx.JSON(errorCodeDefiner(err), map[string]string{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(200, res)
+}
+
+func (h CommentsHandler) LockComment(ctx *ginext.Context) {
+	h.setCommentLock(ctx, true)
+}
+
+func (h CommentsHandler) UnlockComment(ctx *ginext.Context) {
+	h.setCommentLock(ctx, false)
+}
+
+func (h CommentsHandler) setCommentLock(ctx *ginext.Context, locked bool) {
+	idRaw := ctx.Param("id")
+	id, err := strconv.Atoi(idRaw)
+	if err != nil {
+		ctx.JSON(400, map[string]string{"error": "failed to read comment ID"})
+		return
+	}
+
+	res, err := h.Service.SetCommentLock(ctx.Request.Context(), id, locked)
+	if err != nil {
+		ctx.JSON(errorCodeDefiner(err), map[string]string{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(200, res)
+}
+
+func (h CommentsHandler) PinComment(ctx *ginext.Context) {
+	h.setCommentPin(ctx, true)
+}
+
+func (h CommentsHandler) UnpinComment(ctx *ginext.Context) {
+	h.setCommentPin(ctx, false)
+}
+
+func (h CommentsHandler) setCommentPin(ctx *ginext.Context, pinned bool) {
+	idRaw := ctx.Param("id")
+	id, err := strconv.Atoi(idRaw)
+	if err != nil {
+		ctx.JSON(400, map[string]string{"error": "failed to read comment ID"})
+		return
+	}
+
+	res, err := h.Service.SetCommentPin(ctx.Request.Context(), id, pinned)
+	if err != nil {
+		ctx.JSON(errorCodeDefiner(err), map[string]string{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(200, res)
+}
+
+func (h CommentsHandler) LockThread(ctx *ginext.Context) {
+	h.setThreadLock(ctx, true)
+}
+
+func (h CommentsHandler) UnlockThread(ctx *ginext.Context) {
+	h.setThreadLock(ctx, false)
+}
+
+func (h CommentsHandler) setThreadLock(ctx *ginext.Context, locked bool) {
+	res, err := h.Service.SetThreadLock(ctx.Request.Context(), ctx.Param("key"), locked)
+	if err != nil {
+		ctx.JSON(errorCodeDefiner(err), map[string]string{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(200, res)
+}
+
 func (h CommentsHandler) RunSearch(ctx *ginext.Context) {
 	query := ctx.Query("q")
 	if query == "" {
@@ -118,6 +386,17 @@ func (h CommentsHandler) RunSearch(ctx *ginext.Context) {
 	ctx.JSON(200, res)
 }
 
+// errorBody - тело ответа с ошибкой; отказ фильтра дополняется названием фильтра и причиной
+func errorBody(err error) map[string]string {
+	body := map[string]string{"error": err.Error()}
+	var filterErr *service.FilterError
+	if errors.As(err, &filterErr) {
+		body["filter"] = filterErr.Filter
+		body["detail"] = filterErr.Detail
+	}
+	return body
+}
+
 func errorCodeDefiner(err error) int {
 	switch {
 	case errors.Is(err, service.ErrCommon500):
@@ -130,6 +409,62 @@ func errorCodeDefiner(err error) int {
 		return 409
 	case errors.Is(err, service.ErrIncorrectID):
 		return 400
+	case errors.Is(err, service.ErrInvalidCursor):
+		return 400
+	case errors.Is(err, service.ErrEmptyContent):
+		return 400
+	case errors.Is(err, service.ErrCommentDeleted):
+		return 409
+	case errors.Is(err, service.ErrNotDeleted):
+		return 409
+	case errors.Is(err, service.ErrMoveIntoItself):
+		return 422
+	case errors.Is(err, service.ErrInvalidThread):
+		return 400
+	case errors.Is(err, service.ErrThreadMismatch):
+		return 422
+	case errors.Is(err, service.ErrReplyLocked):
+		return 423
+	case errors.Is(err, service.ErrThreadLocked):
+		return 423
+	case errors.Is(err, service.ErrPinNotRoot):
+		return 422
+	case errors.Is(err, service.ErrInvalidVote):
+		return 400
+	case errors.Is(err, service.ErrBadReaction):
+		return 400
+	case errors.Is(err, service.ErrNoClientID):
+		return 400
+	case errors.Is(err, service.ErrInvalidUser):
+		return 400
+	case errors.Is(err, service.ErrBadCredentials):
+		return 401
+	case errors.Is(err, service.ErrUnauthorized):
+		return 401
+	case errors.Is(err, service.ErrForbidden):
+		return 403
+	case errors.Is(err, service.ErrInvalidRole):
+		return 400
+	case errors.Is(err, service.ErrInvalidAPIKey):
+		return 400
+	case errors.Is(err, service.ErrBadReason):
+		return 400
+	case errors.Is(err, service.ErrBadReport):
+		return 400
+	case errors.Is(err, service.ErrBadResolution):
+		return 400
+	case errors.Is(err, service.ErrFiltered):
+		return 422
+	case errors.Is(err, service.ErrRateLimited):
+		return 429
+	case errors.Is(err, repository.ErrUserExists):
+		return 409
+	case errors.Is(err, repository.ErrUserNotFound):
+		return 404
+	case errors.Is(err, repository.ErrAPIKeyNotFound):
+		return 404
+	case errors.Is(err, repository.ErrNoOpenReports):
+		return 404
 	case errors.Is(err, repository.ErrCommentNotFound):
 		return 404
 	}
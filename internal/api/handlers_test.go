@@ -7,9 +7,13 @@ import (
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/UnendingLoop/CommentTree/internal/model"
+	"github.com/UnendingLoop/CommentTree/internal/mwapikey"
+	"github.com/UnendingLoop/CommentTree/internal/repository"
 	"github.com/UnendingLoop/CommentTree/internal/service"
 
 	"github.com/gin-gonic/gin"
@@ -22,9 +26,26 @@ import (
 
 type mockService struct {
 	createFn     func(ctx context.Context, c *model.CommentCreateData) (*service.APPComment, error)
-	getAllRootFn func(ctx context.Context, req *model.RootRequest) ([]service.APPComment, error)
-	getByIDFn    func(ctx context.Context, id int) ([]service.APPComment, error)
+	getAllRootFn func(ctx context.Context, req *model.RootRequest) (*service.CommentPage, error)
+	childrenFn   func(ctx context.Context, id int, req *model.RootRequest) (*service.CommentPage, error)
+	getByIDFn    func(ctx context.Context, id int, req *model.TreeRequest) ([]service.APPComment, error)
+	ancestorsFn  func(ctx context.Context, id int) ([]service.APPComment, error)
+	editFn       func(ctx context.Context, id int, data *model.CommentEditData) (*service.APPComment, error)
+	revisionsFn  func(ctx context.Context, id int) ([]service.APPRevision, error)
 	deleteFn     func(ctx context.Context, id int, soft bool) error
+	restoreFn    func(ctx context.Context, id int) (*service.APPComment, error)
+	moveFn       func(ctx context.Context, id int, data *model.CommentMoveData) (*service.APPComment, error)
+	pinFn        func(ctx context.Context, id int, pinned bool) (*service.APPComment, error)
+	voteFn       func(ctx context.Context, id int, data *model.CommentVoteData) (*service.APPComment, error)
+	reactionFn   func(ctx context.Context, id int, emoji string, on bool) (*service.APPComment, error)
+	lockFn       func(ctx context.Context, id int, locked bool) (*service.APPComment, error)
+	threadLockFn func(ctx context.Context, key string, locked bool) (*service.APPThread, error)
+	premodFn     func(ctx context.Context, key string, on bool) (*service.APPThread, error)
+	queueFn      func(ctx context.Context, req *model.ModerationRequest) ([]service.APPComment, error)
+	moderateFn   func(ctx context.Context, id int, status string, data *model.CommentModerationData) (*service.APPComment, error)
+	reportFn     func(ctx context.Context, id int, data *model.CommentReportData) (*service.APPReport, error)
+	reportsFn    func(ctx context.Context, req *model.ReportListRequest) ([]service.APPReportGroup, error)
+	resolveFn    func(ctx context.Context, id int, data *model.ReportResolveData) (*service.APPReportResolution, error)
 	searchFn     func(ctx context.Context, q string) ([]service.APPComment, error)
 }
 
@@ -32,22 +53,122 @@ func (m *mockService) CreateComment(ctx context.Context, c *model.CommentCreateD
 	return m.createFn(ctx, c)
 }
 
-func (m *mockService) GetAllRootComments(ctx context.Context, req *model.RootRequest) ([]service.APPComment, error) {
+func (m *mockService) GetAllRootComments(ctx context.Context, req *model.RootRequest) (*service.CommentPage, error) {
 	return m.getAllRootFn(ctx, req)
 }
 
-func (m *mockService) GetCommentWithChildren(ctx context.Context, id int) ([]service.APPComment, error) {
-	return m.getByIDFn(ctx, id)
+func (m *mockService) GetChildComments(ctx context.Context, id int, req *model.RootRequest) (*service.CommentPage, error) {
+	return m.childrenFn(ctx, id, req)
+}
+
+func (m *mockService) GetCommentWithChildren(ctx context.Context, id int, req *model.TreeRequest) ([]service.APPComment, error) {
+	return m.getByIDFn(ctx, id, req)
+}
+
+func (m *mockService) GetAncestors(ctx context.Context, id int) ([]service.APPComment, error) {
+	return m.ancestorsFn(ctx, id)
+}
+
+func (m *mockService) EditComment(ctx context.Context, id int, data *model.CommentEditData) (*service.APPComment, error) {
+	return m.editFn(ctx, id, data)
+}
+
+func (m *mockService) GetCommentRevisions(ctx context.Context, id int) ([]service.APPRevision, error) {
+	return m.revisionsFn(ctx, id)
 }
 
 func (m *mockService) DeleteCommentByID(ctx context.Context, id int, soft bool) error {
 	return m.deleteFn(ctx, id, soft)
 }
 
+func (m *mockService) RestoreComment(ctx context.Context, id int) (*service.APPComment, error) {
+	return m.restoreFn(ctx, id)
+}
+
+func (m *mockService) MoveComment(ctx context.Context, id int, data *model.CommentMoveData) (*service.APPComment, error) {
+	return m.moveFn(ctx, id, data)
+}
+
+func (m *mockService) SetCommentPin(ctx context.Context, id int, pinned bool) (*service.APPComment, error) {
+	return m.pinFn(ctx, id, pinned)
+}
+
+func (m *mockService) VoteComment(ctx context.Context, id int, data *model.CommentVoteData) (*service.APPComment, error) {
+	return m.voteFn(ctx, id, data)
+}
+
+func (m *mockService) SetReaction(ctx context.Context, id int, emoji string, on bool) (*service.APPComment, error) {
+	return m.reactionFn(ctx, id, emoji, on)
+}
+
+func (m *mockService) SetCommentLock(ctx context.Context, id int, locked bool) (*service.APPComment, error) {
+	return m.lockFn(ctx, id, locked)
+}
+
+func (m *mockService) SetThreadLock(ctx context.Context, key string, locked bool) (*service.APPThread, error) {
+	return m.threadLockFn(ctx, key, locked)
+}
+
+func (m *mockService) SetThreadPremoderation(ctx context.Context, key string, on bool) (*service.APPThread, error) {
+	return m.premodFn(ctx, key, on)
+}
+
+func (m *mockService) GetModerationQueue(ctx context.Context, req *model.ModerationRequest) ([]service.APPComment, error) {
+	return m.queueFn(ctx, req)
+}
+
+func (m *mockService) ModerateComment(ctx context.Context, id int, status string, data *model.CommentModerationData) (*service.APPComment, error) {
+	return m.moderateFn(ctx, id, status, data)
+}
+
+func (m *mockService) ReportComment(ctx context.Context, id int, data *model.CommentReportData) (*service.APPReport, error) {
+	return m.reportFn(ctx, id, data)
+}
+
+func (m *mockService) GetReports(ctx context.Context, req *model.ReportListRequest) ([]service.APPReportGroup, error) {
+	return m.reportsFn(ctx, req)
+}
+
+func (m *mockService) ResolveReports(ctx context.Context, id int, data *model.ReportResolveData) (*service.APPReportResolution, error) {
+	return m.resolveFn(ctx, id, data)
+}
+
 func (m *mockService) RunCommentSearchQuery(ctx context.Context, q string) ([]service.APPComment, error) {
 	return m.searchFn(ctx, q)
 }
 
+type stubPurger struct {
+	status service.PurgeStatus
+	err    error
+}
+
+func (s stubPurger) Status(ctx context.Context) (service.PurgeStatus, error) {
+	return s.status, s.err
+}
+
+type mockUserService struct {
+	registerFn func(ctx context.Context, data *model.UserCredentials) (*service.APPUser, error)
+	loginFn    func(ctx context.Context, data *model.UserCredentials) (*service.APPToken, error)
+	setRoleFn  func(ctx context.Context, username string, data *model.UserRoleData) (*service.APPUser, error)
+	roleFn     func(ctx context.Context, userID int) (string, bool, error)
+}
+
+func (m *mockUserService) Register(ctx context.Context, data *model.UserCredentials) (*service.APPUser, error) {
+	return m.registerFn(ctx, data)
+}
+
+func (m *mockUserService) Login(ctx context.Context, data *model.UserCredentials) (*service.APPToken, error) {
+	return m.loginFn(ctx, data)
+}
+
+func (m *mockUserService) SetUserRole(ctx context.Context, username string, data *model.UserRoleData) (*service.APPUser, error) {
+	return m.setRoleFn(ctx, username, data)
+}
+
+func (m *mockUserService) CurrentRole(ctx context.Context, userID int) (string, bool, error) {
+	return m.roleFn(ctx, userID)
+}
+
 /*
 	HELPERS
 */
@@ -58,9 +179,31 @@ func setupRouter(handler *CommentsHandler) *gin.Engine {
 
 	r.GET("/ping", ginext.HandlerFunc(handler.SimplePinger))
 	r.POST("/comments", ginext.HandlerFunc(handler.Create))
+	r.GET("/threads/:key/comments", ginext.HandlerFunc(handler.GetThreadRootComments))
+	r.POST("/threads/:key/comments", ginext.HandlerFunc(handler.CreateInThread))
 	r.GET("/comments", ginext.HandlerFunc(handler.GetAllRootComments))
 	r.GET("/comments/:id", ginext.HandlerFunc(handler.GetCommentWithChildren))
+	r.GET("/comments/:id/children", ginext.HandlerFunc(handler.GetChildren))
+	r.GET("/comments/:id/ancestors", ginext.HandlerFunc(handler.GetAncestors))
+	r.PATCH("/comments/:id", ginext.HandlerFunc(handler.EditComment))
+	r.GET("/comments/:id/revisions", ginext.HandlerFunc(handler.GetRevisions))
 	r.DELETE("/comments/:id", ginext.HandlerFunc(handler.DeleteComment))
+	r.POST("/comments/:id/restore", ginext.HandlerFunc(handler.RestoreComment))
+	r.POST("/comments/:id/move", ginext.HandlerFunc(handler.MoveComment))
+	r.POST("/comments/:id/lock", ginext.HandlerFunc(handler.LockComment))
+	r.POST("/comments/:id/pin", ginext.HandlerFunc(handler.PinComment))
+	r.DELETE("/comments/:id/pin", ginext.HandlerFunc(handler.UnpinComment))
+	r.PUT("/comments/:id/vote", ginext.HandlerFunc(handler.VoteComment))
+	r.PUT("/comments/:id/reactions/:emoji", ginext.HandlerFunc(handler.AddReaction))
+	r.DELETE("/comments/:id/reactions/:emoji", ginext.HandlerFunc(handler.RemoveReaction))
+	r.DELETE("/comments/:id/lock", ginext.HandlerFunc(handler.UnlockComment))
+	r.POST("/threads/:key/lock", ginext.HandlerFunc(handler.LockThread))
+	r.DELETE("/threads/:key/lock", ginext.HandlerFunc(handler.UnlockThread))
+	r.GET("/moderation/queue", ginext.HandlerFunc(handler.GetModerationQueue))
+	r.POST("/comments/:id/approve", ginext.HandlerFunc(handler.ApproveComment))
+	r.POST("/comments/:id/reject", ginext.HandlerFunc(handler.RejectComment))
+	r.POST("/comments/:id/reports", ginext.HandlerFunc(handler.ReportComment))
+	r.POST("/comments/:id/reports/resolve", ginext.HandlerFunc(handler.ResolveReports))
 	r.GET("/search", ginext.HandlerFunc(handler.RunSearch))
 
 	return r
@@ -110,6 +253,67 @@ func TestCreate_OK(t *testing.T) {
 	}
 }
 
+func TestCreate_KeyScope(t *testing.T) {
+	called := 0
+	svc := &mockService{
+		createFn: func(ctx context.Context, c *model.CommentCreateData) (*service.APPComment, error) {
+			called++
+			return &service.APPComment{ID: 1}, nil
+		},
+	}
+
+	h := NewCommentHandlers(svc)
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/comments", mwapikey.RequireScope(model.ScopeCommentsWrite), ginext.HandlerFunc(h.Create))
+
+	send := func(ctx context.Context) int {
+		body, _ := json.Marshal(map[string]string{"content": "hello"})
+		req := httptest.NewRequest(http.MethodPost, "/comments", bytes.NewReader(body)).WithContext(ctx)
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// ключ без права comments:write отсекается до обработчика
+	if code := send(mwapikey.WithAPIKey(context.Background(), &model.DBAPIKey{ID: 3, Scopes: []string{model.ScopeModeration}})); code != http.StatusForbidden || called != 0 {
+		t.Fatalf("expected 403 before handler, got %d (handler called %d times)", code, called)
+	}
+	if code := send(mwapikey.WithAPIKey(context.Background(), &model.DBAPIKey{ID: 3, Scopes: []string{model.ScopeCommentsWrite}})); code != http.StatusCreated {
+		t.Fatalf("expected 201 for key with scope, got %d", code)
+	}
+	// запрос без ключа проходит к сервису
+	if code := send(context.Background()); code != http.StatusCreated || called != 2 {
+		t.Fatalf("expected 201 without key, got %d", code)
+	}
+}
+
+func TestCreateInThread_OK(t *testing.T) {
+	svc := &mockService{
+		createFn: func(ctx context.Context, c *model.CommentCreateData) (*service.APPComment, error) {
+			if c.ThreadKey != "product-42" {
+				t.Fatalf("expected thread key from path, got %q", c.ThreadKey)
+			}
+			return &service.APPComment{ID: 1, ThreadKey: c.ThreadKey}, nil
+		},
+	}
+
+	h := NewCommentHandlers(svc)
+	r := setupRouter(h)
+
+	body := `{"content":"hi","thread_key":"ignored"}`
+	req := httptest.NewRequest(http.MethodPost, "/threads/product-42/comments", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+
+	r.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", rec.Code)
+	}
+}
+
 func TestCreate_BindError(t *testing.T) {
 	h := NewCommentHandlers(&mockService{})
 	r := setupRouter(h)
@@ -124,14 +328,64 @@ func TestCreate_BindError(t *testing.T) {
 	}
 }
 
+func TestCreate_Filtered(t *testing.T) {
+	svc := &mockService{
+		createFn: func(ctx context.Context, c *model.CommentCreateData) (*service.APPComment, error) {
+			return nil, &service.FilterError{Filter: "length", Detail: "comment is too long"}
+		},
+	}
+
+	h := NewCommentHandlers(svc)
+	r := setupRouter(h)
+
+	req := httptest.NewRequest(http.MethodPost, "/comments", strings.NewReader(`{"content":"long"}`))
+	rec := httptest.NewRecorder()
+
+	r.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422, got %d", rec.Code)
+	}
+	var body map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("failed to decode body: %v", err)
+	}
+	if body["filter"] != "length" || body["detail"] == "" || body["error"] != service.ErrFiltered.Error() {
+		t.Fatalf("unexpected error body: %v", body)
+	}
+}
+
+func TestCreate_RateLimited(t *testing.T) {
+	svc := &mockService{
+		createFn: func(ctx context.Context, c *model.CommentCreateData) (*service.APPComment, error) {
+			return nil, &service.RateLimitError{RetryAfter: 1500 * time.Millisecond}
+		},
+	}
+
+	h := NewCommentHandlers(svc)
+	r := setupRouter(h)
+
+	req := httptest.NewRequest(http.MethodPost, "/comments", strings.NewReader(`{"content":"hi"}`))
+	rec := httptest.NewRecorder()
+
+	r.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "2" {
+		t.Fatalf("expected Retry-After rounded up to 2, got %q", got)
+	}
+}
+
 /*
 	GET ROOT COMMENTS
 */
 
 func TestGetAllRootComments_OK(t *testing.T) {
 	svc := &mockService{
-		getAllRootFn: func(ctx context.Context, req *model.RootRequest) ([]service.APPComment, error) {
-			return []service.APPComment{{ID: 1, Text: "bla"}, {ID: 2, Text: "bla"}, {ID: 3, Text: "bla"}}, nil
+		getAllRootFn: func(ctx context.Context, req *model.RootRequest) (*service.CommentPage, error) {
+			return &service.CommentPage{Items: []service.APPComment{{ID: 1, Text: "bla"}, {ID: 2, Text: "bla"}, {ID: 3, Text: "bla"}}}, nil
 		},
 	}
 
@@ -146,6 +400,152 @@ func TestGetAllRootComments_OK(t *testing.T) {
 	if rec.Code != http.StatusOK {
 		t.Fatalf("expected 200")
 	}
+
+	var items []service.APPComment
+	if err := json.Unmarshal(rec.Body.Bytes(), &items); err != nil || len(items) != 3 {
+		t.Fatalf("expected bare array of 3 comments, got %s", rec.Body.String())
+	}
+}
+
+func TestGetAllRootComments_CursorWithPage(t *testing.T) {
+	svc := &mockService{
+		getAllRootFn: func(ctx context.Context, req *model.RootRequest) (*service.CommentPage, error) {
+			if req.Cursor != "abc" {
+				t.Fatalf("expected cursor to reach service, got %q", req.Cursor)
+			}
+			return &service.CommentPage{Items: []service.APPComment{{ID: 11}}, NextCursor: "def"}, nil
+		},
+	}
+
+	h := NewCommentHandlers(svc)
+	r := setupRouter(h)
+
+	// page вместе с курсором игнорируется: ссылки строятся только по курсору и только вперёд
+	req := httptest.NewRequest(http.MethodGet, "/comments?page=5&limit=10&cursor=abc&envelope=true", nil)
+	rec := httptest.NewRecorder()
+
+	r.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+
+	var body struct {
+		Next *string `json:"next"`
+		Prev *string `json:"prev"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("failed to decode envelope: %v", err)
+	}
+	if body.Next == nil || !strings.Contains(*body.Next, "cursor=def") || body.Prev != nil {
+		t.Fatalf("expected cursor-only navigation, got next %v, prev %v", body.Next, body.Prev)
+	}
+	if link := rec.Header().Get("Link"); strings.Contains(link, `rel="prev"`) || strings.Contains(link, `rel="last"`) {
+		t.Fatalf("expected no page links in cursor mode, got %q", link)
+	}
+}
+
+func TestGetThreadRootComments_OK(t *testing.T) {
+	svc := &mockService{
+		getAllRootFn: func(ctx context.Context, req *model.RootRequest) (*service.CommentPage, error) {
+			if req.Thread != "product-42" {
+				t.Fatalf("expected thread key from path, got %q", req.Thread)
+			}
+			return &service.CommentPage{Items: []service.APPComment{{ID: 1}}}, nil
+		},
+	}
+
+	h := NewCommentHandlers(svc)
+	r := setupRouter(h)
+
+	req := httptest.NewRequest(http.MethodGet, "/threads/product-42/comments?limit=10", nil)
+	rec := httptest.NewRecorder()
+
+	r.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+}
+
+func TestGetAllRootComments_Envelope(t *testing.T) {
+	svc := &mockService{
+		getAllRootFn: func(ctx context.Context, req *model.RootRequest) (*service.CommentPage, error) {
+			// имитируем валидацию в сервисе
+			req.Page, req.Limit = 2, 10
+			return &service.CommentPage{Items: []service.APPComment{{ID: 11}}, NextCursor: "abc", Total: 35}, nil
+		},
+	}
+
+	h := NewCommentHandlers(svc)
+	r := setupRouter(h)
+
+	req := httptest.NewRequest(http.MethodGet, "/comments?page=2&limit=10&envelope=true", nil)
+	rec := httptest.NewRecorder()
+
+	r.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+
+	var body struct {
+		Items []service.APPComment `json:"items"`
+		Page  int                  `json:"page"`
+		Limit int                  `json:"limit"`
+		Total int                  `json:"total"`
+		Next  *string              `json:"next"`
+		Prev  *string              `json:"prev"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("failed to decode envelope: %v", err)
+	}
+	if len(body.Items) != 1 || body.Page != 2 || body.Limit != 10 || body.Total != 35 {
+		t.Fatalf("unexpected envelope: %s", rec.Body.String())
+	}
+	if body.Next == nil || *body.Next != "/comments?envelope=true&limit=10&page=3" {
+		t.Fatalf("unexpected next link: %v", body.Next)
+	}
+	if body.Prev == nil || *body.Prev != "/comments?envelope=true&limit=10&page=1" {
+		t.Fatalf("unexpected prev link: %v", body.Prev)
+	}
+
+	link := rec.Header().Get("Link")
+	for _, rel := range []string{`rel="next"`, `rel="prev"`, `rel="first"`, `page=4>; rel="last"`} {
+		if !strings.Contains(link, rel) {
+			t.Fatalf("expected Link header to contain %s, got %q", rel, link)
+		}
+	}
+}
+
+/*
+	GET CHILDREN
+*/
+
+func TestGetChildren_NextCursorHeader(t *testing.T) {
+	svc := &mockService{
+		childrenFn: func(ctx context.Context, id int, req *model.RootRequest) (*service.CommentPage, error) {
+			if req.Cursor != "abc" {
+				t.Fatalf("expected cursor to be passed from query, got %q", req.Cursor)
+			}
+			return &service.CommentPage{Items: []service.APPComment{{ID: 2}}, NextCursor: "def"}, nil
+		},
+	}
+
+	h := NewCommentHandlers(svc)
+	r := setupRouter(h)
+
+	req := httptest.NewRequest(http.MethodGet, "/comments/1/children?cursor=abc", nil)
+	rec := httptest.NewRecorder()
+
+	r.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("X-Next-Cursor"); got != "def" {
+		t.Fatalf("expected X-Next-Cursor header %q, got %q", "def", got)
+	}
 }
 
 /*
@@ -154,7 +554,10 @@ func TestGetAllRootComments_OK(t *testing.T) {
 
 func TestGetCommentWithChildren_OK(t *testing.T) {
 	svc := &mockService{
-		getByIDFn: func(ctx context.Context, id int) ([]service.APPComment, error) {
+		getByIDFn: func(ctx context.Context, id int, req *model.TreeRequest) ([]service.APPComment, error) {
+			if req.Sort != "oldest" {
+				t.Fatalf("expected sort to be passed from query, got %q", req.Sort)
+			}
 			return []service.APPComment{{ID: id}}, nil
 		},
 	}
@@ -162,7 +565,7 @@ func TestGetCommentWithChildren_OK(t *testing.T) {
 	h := NewCommentHandlers(svc)
 	r := setupRouter(h)
 
-	req := httptest.NewRequest(http.MethodGet, "/comments/1", nil)
+	req := httptest.NewRequest(http.MethodGet, "/comments/1?sort=oldest", nil)
 	rec := httptest.NewRecorder()
 
 	r.ServeHTTP(rec, req)
@@ -186,82 +589,652 @@ func TestGetCommentWithChildren_InvalidID(t *testing.T) {
 	}
 }
 
-/*
-	DELETE
-*/
-
-func TestDeleteComment_Soft_OK(t *testing.T) {
+func TestGetCommentWithChildren_ContextQuery(t *testing.T) {
 	svc := &mockService{
-		deleteFn: func(ctx context.Context, id int, soft bool) error {
-			if !soft {
-				t.Fatalf("expected soft delete")
+		getByIDFn: func(ctx context.Context, id int, req *model.TreeRequest) ([]service.APPComment, error) {
+			if req.Context != 3 || req.Depth != 2 {
+				t.Fatalf("expected context=3 and depth=2, got %+v", req)
 			}
-			return nil
+			return []service.APPComment{{ID: 1}}, nil
 		},
 	}
 
 	h := NewCommentHandlers(svc)
 	r := setupRouter(h)
 
-	req := httptest.NewRequest(http.MethodDelete, "/comments/1?mode=soft", nil)
+	req := httptest.NewRequest(http.MethodGet, "/comments/5?context=3&depth=2&sort=oldest", nil)
 	rec := httptest.NewRecorder()
 
 	r.ServeHTTP(rec, req)
 
-	if rec.Code != http.StatusNoContent {
-		t.Fatalf("expected 204")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
 	}
 }
 
-func TestDeleteComment_InvalidMode(t *testing.T) {
-	h := NewCommentHandlers(&mockService{})
+/*
+	GET ANCESTORS
+*/
+
+func TestGetAncestors_OK(t *testing.T) {
+	svc := &mockService{
+		ancestorsFn: func(ctx context.Context, id int) ([]service.APPComment, error) {
+			return []service.APPComment{{ID: 1}, {ID: 2}}, nil
+		},
+	}
+
+	h := NewCommentHandlers(svc)
 	r := setupRouter(h)
 
-	req := httptest.NewRequest(http.MethodDelete, "/comments/1?mode=xxx", nil)
+	req := httptest.NewRequest(http.MethodGet, "/comments/3/ancestors", nil)
 	rec := httptest.NewRecorder()
 
 	r.ServeHTTP(rec, req)
 
-	if rec.Code != http.StatusBadRequest {
-		t.Fatalf("expected 400")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
 	}
 }
 
 /*
-	SEARCH
+	EDIT & REVISIONS
 */
 
-func TestRunSearch_OK(t *testing.T) {
+func TestEditComment_OK(t *testing.T) {
 	svc := &mockService{
-		searchFn: func(ctx context.Context, q string) ([]service.APPComment, error) {
-			return []service.APPComment{{ID: 1}}, nil
+		editFn: func(ctx context.Context, id int, data *model.CommentEditData) (*service.APPComment, error) {
+			if id != 4 || data.Text != "fixed" {
+				t.Fatalf("unexpected edit input: %d %+v", id, data)
+			}
+			return &service.APPComment{ID: id, Text: data.Text, EditCount: 1}, nil
 		},
 	}
 
 	h := NewCommentHandlers(svc)
 	r := setupRouter(h)
 
-	req := httptest.NewRequest(http.MethodGet, "/search?q=test", nil)
+	req := httptest.NewRequest(http.MethodPatch, "/comments/4", strings.NewReader(`{"content":"fixed"}`))
+	req.Header.Set("Content-Type", "application/json")
 	rec := httptest.NewRecorder()
 
 	r.ServeHTTP(rec, req)
 
 	if rec.Code != http.StatusOK {
-		t.Fatalf("expected 200")
+		t.Fatalf("expected 200, got %d", rec.Code)
 	}
 }
 
-func TestRunSearch_EmptyQuery(t *testing.T) {
-	h := NewCommentHandlers(&mockService{})
+func TestEditComment_Deleted(t *testing.T) {
+	svc := &mockService{
+		editFn: func(ctx context.Context, id int, data *model.CommentEditData) (*service.APPComment, error) {
+			return nil, service.ErrCommentDeleted
+		},
+	}
+
+	h := NewCommentHandlers(svc)
 	r := setupRouter(h)
 
-	req := httptest.NewRequest(http.MethodGet, "/search?q=", nil)
+	req := httptest.NewRequest(http.MethodPatch, "/comments/4", strings.NewReader(`{"content":"fixed"}`))
+	req.Header.Set("Content-Type", "application/json")
 	rec := httptest.NewRecorder()
 
 	r.ServeHTTP(rec, req)
 
-	if rec.Code != http.StatusBadRequest {
-		t.Fatalf("expected 400")
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("expected 409, got %d", rec.Code)
+	}
+}
+
+func TestGetRevisions_OK(t *testing.T) {
+	svc := &mockService{
+		revisionsFn: func(ctx context.Context, id int) ([]service.APPRevision, error) {
+			return []service.APPRevision{{Version: 1, Text: "a"}, {Version: 2, Text: "b"}}, nil
+		},
+	}
+
+	h := NewCommentHandlers(svc)
+	r := setupRouter(h)
+
+	req := httptest.NewRequest(http.MethodGet, "/comments/4/revisions", nil)
+	rec := httptest.NewRecorder()
+
+	r.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+
+	var res []service.APPRevision
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil || len(res) != 2 {
+		t.Fatalf("expected two revisions, got %s", rec.Body.String())
+	}
+}
+
+/*
+	DELETE
+*/
+
+func TestDeleteComment_Soft_OK(t *testing.T) {
+	svc := &mockService{
+		deleteFn: func(ctx context.Context, id int, soft bool) error {
+			if !soft {
+				t.Fatalf("expected soft delete")
+			}
+			return nil
+		},
+	}
+
+	h := NewCommentHandlers(svc)
+	r := setupRouter(h)
+
+	req := httptest.NewRequest(http.MethodDelete, "/comments/1?mode=soft", nil)
+	rec := httptest.NewRecorder()
+
+	r.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204")
+	}
+}
+
+func TestDeleteComment_Hard_Forbidden(t *testing.T) {
+	svc := &mockService{
+		deleteFn: func(ctx context.Context, id int, soft bool) error {
+			return service.ErrForbidden
+		},
+	}
+
+	h := NewCommentHandlers(svc)
+	r := setupRouter(h)
+
+	req := httptest.NewRequest(http.MethodDelete, "/comments/1?mode=hard", nil)
+	rec := httptest.NewRecorder()
+
+	r.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d", rec.Code)
+	}
+}
+
+func TestDeleteComment_InvalidMode(t *testing.T) {
+	h := NewCommentHandlers(&mockService{})
+	r := setupRouter(h)
+
+	req := httptest.NewRequest(http.MethodDelete, "/comments/1?mode=xxx", nil)
+	rec := httptest.NewRecorder()
+
+	r.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400")
+	}
+}
+
+/*
+	RESTORE
+*/
+
+func TestRestoreComment_OK(t *testing.T) {
+	svc := &mockService{
+		restoreFn: func(ctx context.Context, id int) (*service.APPComment, error) {
+			return &service.APPComment{ID: id, RestoredBy: "mod"}, nil
+		},
+	}
+
+	h := NewCommentHandlers(svc)
+	r := setupRouter(h)
+
+	req := httptest.NewRequest(http.MethodPost, "/comments/7/restore", nil)
+	rec := httptest.NewRecorder()
+
+	r.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+}
+
+func TestRestoreComment_NotDeleted(t *testing.T) {
+	svc := &mockService{
+		restoreFn: func(ctx context.Context, id int) (*service.APPComment, error) {
+			return nil, service.ErrNotDeleted
+		},
+	}
+
+	h := NewCommentHandlers(svc)
+	r := setupRouter(h)
+
+	req := httptest.NewRequest(http.MethodPost, "/comments/7/restore", nil)
+	rec := httptest.NewRecorder()
+
+	r.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("expected 409, got %d", rec.Code)
+	}
+}
+
+/*
+	MOVE
+*/
+
+func TestMoveComment_OK(t *testing.T) {
+	svc := &mockService{
+		moveFn: func(ctx context.Context, id int, data *model.CommentMoveData) (*service.APPComment, error) {
+			if data.ParentID != nil {
+				t.Fatalf("expected null parent to promote to root")
+			}
+			return &service.APPComment{ID: id}, nil
+		},
+	}
+
+	h := NewCommentHandlers(svc)
+	r := setupRouter(h)
+
+	req := httptest.NewRequest(http.MethodPost, "/comments/8/move", strings.NewReader(`{"parent_id":null}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+
+	r.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+}
+
+func TestMoveComment_IntoSubtree(t *testing.T) {
+	svc := &mockService{
+		moveFn: func(ctx context.Context, id int, data *model.CommentMoveData) (*service.APPComment, error) {
+			return nil, service.ErrMoveIntoItself
+		},
+	}
+
+	h := NewCommentHandlers(svc)
+	r := setupRouter(h)
+
+	req := httptest.NewRequest(http.MethodPost, "/comments/8/move", strings.NewReader(`{"parent_id":9}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+
+	r.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422, got %d", rec.Code)
+	}
+}
+
+/*
+	PINS
+*/
+
+func TestPinComment_NotRoot(t *testing.T) {
+	svc := &mockService{
+		pinFn: func(ctx context.Context, id int, pinned bool) (*service.APPComment, error) {
+			if !pinned {
+				t.Fatalf("expected pin on POST")
+			}
+			return nil, service.ErrPinNotRoot
+		},
+	}
+
+	h := NewCommentHandlers(svc)
+	r := setupRouter(h)
+
+	req := httptest.NewRequest(http.MethodPost, "/comments/3/pin", nil)
+	rec := httptest.NewRecorder()
+
+	r.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422, got %d", rec.Code)
+	}
+}
+
+/*
+	VOTES
+*/
+
+func TestVoteComment_OK(t *testing.T) {
+	svc := &mockService{
+		voteFn: func(ctx context.Context, id int, data *model.CommentVoteData) (*service.APPComment, error) {
+			if data.Value != 1 {
+				t.Fatalf("unexpected vote: %+v", data)
+			}
+			return &service.APPComment{ID: id, Score: 1, Upvotes: 1}, nil
+		},
+	}
+
+	h := NewCommentHandlers(svc)
+	r := setupRouter(h)
+
+	req := httptest.NewRequest(http.MethodPut, "/comments/3/vote", strings.NewReader(`{"value":1}`))
+	rec := httptest.NewRecorder()
+
+	r.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+}
+
+/*
+	REACTIONS
+*/
+
+func TestRemoveReaction_OK(t *testing.T) {
+	svc := &mockService{
+		reactionFn: func(ctx context.Context, id int, emoji string, on bool) (*service.APPComment, error) {
+			if emoji != "👍" || on {
+				t.Fatalf("expected removal of 👍, got %q on=%v", emoji, on)
+			}
+			return &service.APPComment{ID: id}, nil
+		},
+	}
+
+	h := NewCommentHandlers(svc)
+	r := setupRouter(h)
+
+	req := httptest.NewRequest(http.MethodDelete, "/comments/3/reactions/%F0%9F%91%8D", nil)
+	rec := httptest.NewRecorder()
+
+	r.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+}
+
+/*
+	LOCKS
+*/
+
+func TestUnlockComment_OK(t *testing.T) {
+	svc := &mockService{
+		lockFn: func(ctx context.Context, id int, locked bool) (*service.APPComment, error) {
+			if locked {
+				t.Fatalf("expected unlock on DELETE")
+			}
+			return &service.APPComment{ID: id, CanReply: true}, nil
+		},
+	}
+
+	h := NewCommentHandlers(svc)
+	r := setupRouter(h)
+
+	req := httptest.NewRequest(http.MethodDelete, "/comments/3/lock", nil)
+	rec := httptest.NewRecorder()
+
+	r.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+}
+
+func TestLockThread_OK(t *testing.T) {
+	svc := &mockService{
+		threadLockFn: func(ctx context.Context, key string, locked bool) (*service.APPThread, error) {
+			if key != "article-1" || !locked {
+				t.Fatalf("unexpected lock input: %q %v", key, locked)
+			}
+			return &service.APPThread{ThreadKey: key, Locked: true}, nil
+		},
+	}
+
+	h := NewCommentHandlers(svc)
+	r := setupRouter(h)
+
+	req := httptest.NewRequest(http.MethodPost, "/threads/article-1/lock", nil)
+	rec := httptest.NewRecorder()
+
+	r.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+}
+
+/*
+	MODERATION
+*/
+
+func TestApproveComment_NoBody(t *testing.T) {
+	svc := &mockService{
+		moderateFn: func(ctx context.Context, id int, status string, data *model.CommentModerationData) (*service.APPComment, error) {
+			if id != 3 || status != model.StatusApproved || data.Reason != "" {
+				t.Fatalf("unexpected moderation input: %d %q %+v", id, status, data)
+			}
+			return &service.APPComment{ID: id}, nil
+		},
+	}
+
+	h := NewCommentHandlers(svc)
+	r := setupRouter(h)
+
+	req := httptest.NewRequest(http.MethodPost, "/comments/3/approve", nil)
+	rec := httptest.NewRecorder()
+
+	r.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+}
+
+func TestRejectComment_NoReason(t *testing.T) {
+	svc := &mockService{
+		moderateFn: func(ctx context.Context, id int, status string, data *model.CommentModerationData) (*service.APPComment, error) {
+			if status != model.StatusRejected {
+				t.Fatalf("expected reject, got %q", status)
+			}
+			return nil, service.ErrBadReason
+		},
+	}
+
+	h := NewCommentHandlers(svc)
+	r := setupRouter(h)
+
+	req := httptest.NewRequest(http.MethodPost, "/comments/3/reject", strings.NewReader(`{}`))
+	rec := httptest.NewRecorder()
+
+	r.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", rec.Code)
+	}
+}
+
+func TestGetModerationQueue_Forbidden(t *testing.T) {
+	svc := &mockService{
+		queueFn: func(ctx context.Context, req *model.ModerationRequest) ([]service.APPComment, error) {
+			if req.Thread != "article-1" || req.Limit != 5 {
+				t.Fatalf("unexpected queue request: %+v", req)
+			}
+			return nil, service.ErrForbidden
+		},
+	}
+
+	h := NewCommentHandlers(svc)
+	r := setupRouter(h)
+
+	req := httptest.NewRequest(http.MethodGet, "/moderation/queue?thread=article-1&limit=5", nil)
+	rec := httptest.NewRecorder()
+
+	r.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d", rec.Code)
+	}
+}
+
+/*
+	REPORTS
+*/
+
+func TestReportComment_Created(t *testing.T) {
+	svc := &mockService{
+		reportFn: func(ctx context.Context, id int, data *model.CommentReportData) (*service.APPReport, error) {
+			if id != 3 || data.Reason != model.ReportSpam {
+				t.Fatalf("unexpected report input: %d %+v", id, data)
+			}
+			return &service.APPReport{CommentID: id, Reason: data.Reason, Reports: 1}, nil
+		},
+	}
+
+	h := NewCommentHandlers(svc)
+	r := setupRouter(h)
+
+	req := httptest.NewRequest(http.MethodPost, "/comments/3/reports", strings.NewReader(`{"reason":"spam"}`))
+	rec := httptest.NewRecorder()
+
+	r.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", rec.Code)
+	}
+}
+
+func TestResolveReports_NoOpenReports(t *testing.T) {
+	svc := &mockService{
+		resolveFn: func(ctx context.Context, id int, data *model.ReportResolveData) (*service.APPReportResolution, error) {
+			return nil, repository.ErrNoOpenReports
+		},
+	}
+
+	h := NewCommentHandlers(svc)
+	r := setupRouter(h)
+
+	req := httptest.NewRequest(http.MethodPost, "/comments/3/reports/resolve", strings.NewReader(`{"action":"dismiss"}`))
+	rec := httptest.NewRecorder()
+
+	r.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", rec.Code)
+	}
+}
+
+/*
+	SEARCH
+*/
+
+func TestRunSearch_OK(t *testing.T) {
+	svc := &mockService{
+		searchFn: func(ctx context.Context, q string) ([]service.APPComment, error) {
+			return []service.APPComment{{ID: 1}}, nil
+		},
+	}
+
+	h := NewCommentHandlers(svc)
+	r := setupRouter(h)
+
+	req := httptest.NewRequest(http.MethodGet, "/search?q=test", nil)
+	rec := httptest.NewRecorder()
+
+	r.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200")
+	}
+}
+
+func TestRunSearch_EmptyQuery(t *testing.T) {
+	h := NewCommentHandlers(&mockService{})
+	r := setupRouter(h)
+
+	req := httptest.NewRequest(http.MethodGet, "/search?q=", nil)
+	rec := httptest.NewRecorder()
+
+	r.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400")
+	}
+}
+
+/*
+	USERS
+*/
+
+func TestLogin_BadCredentials(t *testing.T) {
+	h := NewUserHandlers(&mockUserService{
+		loginFn: func(ctx context.Context, data *model.UserCredentials) (*service.APPToken, error) {
+			return nil, service.ErrBadCredentials
+		},
+	})
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/auth/login", ginext.HandlerFunc(h.Login))
+
+	req := httptest.NewRequest(http.MethodPost, "/auth/login", strings.NewReader(`{"username":"alice","password":"nope"}`))
+	rec := httptest.NewRecorder()
+
+	r.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", rec.Code)
+	}
+}
+
+func TestMe_Unauthenticated(t *testing.T) {
+	h := NewUserHandlers(&mockUserService{})
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/auth/me", ginext.HandlerFunc(h.Me))
+
+	req := httptest.NewRequest(http.MethodGet, "/auth/me", nil)
+	rec := httptest.NewRecorder()
+
+	r.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", rec.Code)
+	}
+}
+
+/*
+	PURGE STATUS
+*/
+
+func TestPurgeStatus_OK(t *testing.T) {
+	h := NewPurgeHandler(stubPurger{status: service.PurgeStatus{Enabled: true, TotalPurged: 5}})
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/purge/status", ginext.HandlerFunc(h.Status))
+
+	req := httptest.NewRequest(http.MethodGet, "/purge/status", nil)
+	rec := httptest.NewRecorder()
+
+	r.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+
+	var res service.PurgeStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil || res.TotalPurged != 5 {
+		t.Fatalf("unexpected status body: %s", rec.Body.String())
+	}
+}
+
+func TestPurgeStatus_Forbidden(t *testing.T) {
+	h := NewPurgeHandler(stubPurger{err: service.ErrForbidden})
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/purge/status", ginext.HandlerFunc(h.Status))
+
+	req := httptest.NewRequest(http.MethodGet, "/purge/status", nil)
+	rec := httptest.NewRecorder()
+
+	r.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d", rec.Code)
 	}
 }
 
@@ -276,7 +1249,35 @@ func TestErrorCodeDefiner(t *testing.T) {
 	}{
 		{service.ErrParentNotFound, 404},
 		{service.ErrIncorrectID, 400},
+		{service.ErrInvalidCursor, 400},
 		{service.ErrParentDeleted, 409},
+		{service.ErrEmptyContent, 400},
+		{service.ErrCommentDeleted, 409},
+		{service.ErrNotDeleted, 409},
+		{service.ErrMoveIntoItself, 422},
+		{service.ErrInvalidThread, 400},
+		{service.ErrThreadMismatch, 422},
+		{service.ErrReplyLocked, 423},
+		{service.ErrThreadLocked, 423},
+		{service.ErrPinNotRoot, 422},
+		{service.ErrInvalidVote, 400},
+		{service.ErrBadReaction, 400},
+		{service.ErrNoClientID, 400},
+		{service.ErrInvalidUser, 400},
+		{service.ErrBadCredentials, 401},
+		{service.ErrUnauthorized, 401},
+		{service.ErrForbidden, 403},
+		{service.ErrInvalidRole, 400},
+		{service.ErrInvalidAPIKey, 400},
+		{service.ErrBadReason, 400},
+		{service.ErrBadReport, 400},
+		{service.ErrBadResolution, 400},
+		{service.ErrFiltered, 422},
+		{service.ErrRateLimited, 429},
+		{repository.ErrUserExists, 409},
+		{repository.ErrUserNotFound, 404},
+		{repository.ErrAPIKeyNotFound, 404},
+		{repository.ErrNoOpenReports, 404},
 		{service.ErrCommon500, 500},
 		{errors.New("unknown"), 500},
 	}
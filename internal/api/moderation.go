@@ -0,0 +1,77 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"strconv"
+
+	"github.com/UnendingLoop/CommentTree/internal/model"
+
+	"github.com/wb-go/wbf/ginext"
+)
+
+func (h CommentsHandler) GetModerationQueue(ctx *ginext.Context) {
+	var req model.ModerationRequest
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		ctx.JSON(400, map[string]string{"error": "failed to parse query"})
+		return
+	}
+
+	res, err := h.Service.GetModerationQueue(ctx.Request.Context(), &req)
+	if err != nil {
+		ctx.JSON(errorCodeDefiner(err), map[string]string{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(200, res)
+}
+
+func (h CommentsHandler) ApproveComment(ctx *ginext.Context) {
+	h.moderateComment(ctx, model.StatusApproved)
+}
+
+func (h CommentsHandler) RejectComment(ctx *ginext.Context) {
+	h.moderateComment(ctx, model.StatusRejected)
+}
+
+func (h CommentsHandler) moderateComment(ctx *ginext.Context, status string) {
+	idRaw := ctx.Param("id")
+	id, err := strconv.Atoi(idRaw)
+	if err != nil {
+		ctx.JSON(400, map[string]string{"error": "failed to read comment ID"})
+		return
+	}
+
+	// при одобрении тело необязательное
+	var data model.CommentModerationData
+	if err := ctx.ShouldBindJSON(&data); err != nil && !errors.Is(err, io.EOF) {
+		ctx.JSON(400, map[string]string{"error": err.Error()})
+		return
+	}
+
+	res, err := h.Service.ModerateComment(ctx.Request.Context(), id, status, &data)
+	if err != nil {
+		ctx.JSON(errorCodeDefiner(err), map[string]string{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(200, res)
+}
+
+func (h CommentsHandler) EnablePremoderation(ctx *ginext.Context) {
+	h.setThreadPremoderation(ctx, true)
+}
+
+func (h CommentsHandler) DisablePremoderation(ctx *ginext.Context) {
+	h.setThreadPremoderation(ctx, false)
+}
+
+func (h CommentsHandler) setThreadPremoderation(ctx *ginext.Context, on bool) {
+	res, err := h.Service.SetThreadPremoderation(ctx.Request.Context(), ctx.Param("key"), on)
+	if err != nil {
+		ctx.JSON(errorCodeDefiner(err), map[string]string{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(200, res)
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/UnendingLoop/CommentTree/internal/model"
+	"github.com/UnendingLoop/CommentTree/internal/service"
+
+	"github.com/wb-go/wbf/ginext"
+)
+
+// pageEnvelope - конверт постраничного ответа, отдаётся только по ?envelope=true
+type pageEnvelope struct {
+	Items      []service.APPComment `json:"items"`
+	Page       int                  `json:"page"`
+	Limit      int                  `json:"limit"`
+	Total      int                  `json:"total"`
+	Next       *string              `json:"next"`
+	Prev       *string              `json:"prev"`
+	NextCursor string               `json:"next_cursor,omitempty"`
+}
+
+// writePage отдаёт страницу комментариев: ссылки навигации всегда уходят в заголовках Link/X-Next-Cursor,
+// а тело остаётся голым массивом, если клиент не запросил конверт
+func writePage(ctx *ginext.Context, req *model.RootRequest, page *service.CommentPage) {
+	next, prev := pageLinks(ctx.Request.URL, req, page)
+
+	links := make([]string, 0, 4)
+	if next != nil {
+		links = append(links, fmt.Sprintf("<%s>; rel=\"next\"", *next))
+	}
+	if prev != nil {
+		links = append(links, fmt.Sprintf("<%s>; rel=\"prev\"", *prev))
+	}
+	if req.Envelope && req.Cursor == "" {
+		// общее количество известно только в режиме конверта
+		links = append(links, fmt.Sprintf("<%s>; rel=\"first\"", withPage(ctx.Request.URL, 1)))
+		last := max((page.Total+req.Limit-1)/req.Limit, 1)
+		links = append(links, fmt.Sprintf("<%s>; rel=\"last\"", withPage(ctx.Request.URL, last)))
+	}
+	if len(links) > 0 {
+		ctx.Header("Link", strings.Join(links, ", "))
+	}
+	if page.NextCursor != "" {
+		ctx.Header("X-Next-Cursor", page.NextCursor)
+	}
+
+	if !req.Envelope {
+		ctx.JSON(200, page.Items)
+		return
+	}
+
+	ctx.JSON(200, pageEnvelope{
+		Items:      page.Items,
+		Page:       req.Page,
+		Limit:      req.Limit,
+		Total:      page.Total,
+		Next:       next,
+		Prev:       prev,
+		NextCursor: page.NextCursor,
+	})
+}
+
+// pageLinks строит ссылки на соседние страницы в том же режиме пагинации, что и текущий запрос:
+// для курсора - только вперёд, для page/limit - в обе стороны
+func pageLinks(current *url.URL, req *model.RootRequest, page *service.CommentPage) (next, prev *string) {
+	if req.Cursor != "" {
+		if page.NextCursor != "" {
+			link := withQuery(current, "cursor", page.NextCursor)
+			next = &link
+		}
+		return next, nil
+	}
+
+	if page.NextCursor != "" {
+		link := withPage(current, req.Page+1)
+		next = &link
+	}
+	if req.Page > 1 {
+		link := withPage(current, req.Page-1)
+		prev = &link
+	}
+	return next, prev
+}
+
+func withPage(current *url.URL, page int) string {
+	return withQuery(current, "page", strconv.Itoa(page))
+}
+
+func withQuery(current *url.URL, key, value string) string {
+	link := *current
+	query := link.Query()
+	query.Set(key, value)
+	link.RawQuery = query.Encode()
+	return link.RequestURI()
+}
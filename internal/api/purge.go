@@ -0,0 +1,25 @@
+package api
+
+import (
+	"github.com/UnendingLoop/CommentTree/internal/service"
+
+	"github.com/wb-go/wbf/ginext"
+)
+
+type PurgeHandler struct {
+	Purger service.PurgeReporter
+}
+
+func NewPurgeHandler(p service.PurgeReporter) *PurgeHandler {
+	return &PurgeHandler{Purger: p}
+}
+
+func (h PurgeHandler) Status(ctx *ginext.Context) {
+	res, err := h.Purger.Status(ctx.Request.Context())
+	if err != nil {
+		ctx.JSON(errorCodeDefiner(err), map[string]string{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(200, res)
+}
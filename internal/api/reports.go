@@ -0,0 +1,71 @@
+package api
+
+import (
+	"strconv"
+
+	"github.com/UnendingLoop/CommentTree/internal/model"
+
+	"github.com/wb-go/wbf/ginext"
+)
+
+func (h CommentsHandler) ReportComment(ctx *ginext.Context) {
+	idRaw := ctx.Param("id")
+	id, err := strconv.Atoi(idRaw)
+	if err != nil {
+		ctx.JSON(400, map[string]string{"error": "failed to read comment ID"})
+		return
+	}
+
+	var data model.CommentReportData
+	if err := ctx.BindJSON(&data); err != nil {
+		ctx.JSON(400, map[string]string{"error": err.Error()})
+		return
+	}
+
+	res, err := h.Service.ReportComment(ctx.Request.Context(), id, &data)
+	if err != nil {
+		ctx.JSON(errorCodeDefiner(err), map[string]string{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(201, res)
+}
+
+func (h CommentsHandler) GetReports(ctx *ginext.Context) {
+	var req model.ReportListRequest
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		ctx.JSON(400, map[string]string{"error": "failed to parse query"})
+		return
+	}
+
+	res, err := h.Service.GetReports(ctx.Request.Context(), &req)
+	if err != nil {
+		ctx.JSON(errorCodeDefiner(err), map[string]string{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(200, res)
+}
+
+func (h CommentsHandler) ResolveReports(ctx *ginext.Context) {
+	idRaw := ctx.Param("id")
+	id, err := strconv.Atoi(idRaw)
+	if err != nil {
+		ctx.JSON(400, map[string]string{"error": "failed to read comment ID"})
+		return
+	}
+
+	var data model.ReportResolveData
+	if err := ctx.BindJSON(&data); err != nil {
+		ctx.JSON(400, map[string]string{"error": err.Error()})
+		return
+	}
+
+	res, err := h.Service.ResolveReports(ctx.Request.Context(), id, &data)
+	if err != nil {
+		ctx.JSON(errorCodeDefiner(err), map[string]string{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(200, res)
+}
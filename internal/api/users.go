@@ -0,0 +1,76 @@
+package api
+
+import (
+	"github.com/UnendingLoop/CommentTree/internal/model"
+	"github.com/UnendingLoop/CommentTree/internal/mwauth"
+	"github.com/UnendingLoop/CommentTree/internal/service"
+
+	"github.com/wb-go/wbf/ginext"
+)
+
+type UsersHandler struct {
+	Service service.UserService
+}
+
+func NewUserHandlers(svc service.UserService) *UsersHandler {
+	return &UsersHandler{Service: svc}
+}
+
+func (h UsersHandler) Register(ctx *ginext.Context) {
+	var data model.UserCredentials
+	if err := ctx.BindJSON(&data); err != nil {
+		ctx.JSON(400, map[string]string{"error": err.Error()})
+		return
+	}
+
+	res, err := h.Service.Register(ctx.Request.Context(), &data)
+	if err != nil {
+		ctx.JSON(errorCodeDefiner(err), map[string]string{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(201, res)
+}
+
+func (h UsersHandler) Login(ctx *ginext.Context) {
+	var data model.UserCredentials
+	if err := ctx.BindJSON(&data); err != nil {
+		ctx.JSON(400, map[string]string{"error": err.Error()})
+		return
+	}
+
+	res, err := h.Service.Login(ctx.Request.Context(), &data)
+	if err != nil {
+		ctx.JSON(errorCodeDefiner(err), map[string]string{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(200, res)
+}
+
+func (h UsersHandler) SetRole(ctx *ginext.Context) {
+	var data model.UserRoleData
+	if err := ctx.BindJSON(&data); err != nil {
+		ctx.JSON(400, map[string]string{"error": err.Error()})
+		return
+	}
+
+	res, err := h.Service.SetUserRole(ctx.Request.Context(), ctx.Param("username"), &data)
+	if err != nil {
+		ctx.JSON(errorCodeDefiner(err), map[string]string{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(200, res)
+}
+
+// Me - пользователь, которому выдан токен запроса
+func (h UsersHandler) Me(ctx *ginext.Context) {
+	user, ok := mwauth.UserFromContext(ctx.Request.Context())
+	if !ok {
+		ctx.JSON(401, map[string]string{"error": "authentication required"})
+		return
+	}
+
+	ctx.JSON(200, service.APPUser{ID: user.UserID, Username: user.Name, Role: user.Role})
+}
@@ -0,0 +1,127 @@
+// Package auth provides password hashing and signed access tokens (JWT, HS256)
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/UnendingLoop/CommentTree/internal/model"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrInvalidToken error = errors.New("invalid token")
+	ErrTokenExpired error = errors.New("token expired")
+)
+
+// Claims - содержимое токена доступа
+type Claims struct {
+	UserID    int    `json:"uid"`
+	Name      string `json:"name"`
+	Role      string `json:"role"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// заголовок один для всех токенов - другие алгоритмы не принимаем
+var tokenHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// Tokens выпускает и проверяет токены, подписанные общим секретом
+type Tokens struct {
+	secret []byte
+	ttl    time.Duration
+	now    func() time.Time
+}
+
+func NewTokens(secret string, ttl time.Duration) *Tokens {
+	return &Tokens{secret: []byte(secret), ttl: ttl, now: time.Now}
+}
+
+// Issue выпускает токен на ttl; время выпуска и истечения проставляются здесь
+func (t *Tokens) Issue(claims Claims) (string, time.Time, error) {
+	now := t.now().UTC()
+	expiresAt := now.Add(t.ttl)
+	claims.IssuedAt, claims.ExpiresAt = now.Unix(), expiresAt.Unix()
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	unsigned := tokenHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return unsigned + "." + t.sign(unsigned), expiresAt, nil
+}
+
+// Parse проверяет подпись и срок действия токена
+func (t *Tokens) Parse(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != tokenHeader {
+		return nil, ErrInvalidToken
+	}
+	if !hmac.Equal([]byte(parts[2]), []byte(t.sign(parts[0]+"."+parts[1]))) {
+		return nil, ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.UserID <= 0 {
+		return nil, ErrInvalidToken
+	}
+	if claims.Role == "" { // токены, выпущенные до появления ролей
+		claims.Role = model.RoleUser
+	}
+	if t.now().Unix() >= claims.ExpiresAt {
+		return nil, ErrTokenExpired
+	}
+
+	return &claims, nil
+}
+
+func (t *Tokens) sign(unsigned string) string {
+	mac := hmac.New(sha256.New, t.secret)
+	mac.Write([]byte(unsigned))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// NewSecret - случайный секрет для передачи клиенту (токены правки, ключи API)
+func NewSecret() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// HashSecret - хеш секрета для хранения в базе; секреты случайные и длинные, поэтому медленный хеш не нужен
+func HashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// CheckSecret - совпадает ли секрет с хешем из базы
+func CheckSecret(hash, secret string) bool {
+	return hash != "" && subtle.ConstantTimeCompare([]byte(hash), []byte(HashSecret(secret))) == 1
+}
+
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// CheckPassword - совпадает ли пароль с хешем из базы
+func CheckPassword(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
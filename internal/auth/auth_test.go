@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokens_RoundTrip(t *testing.T) {
+	tokens := NewTokens("secret", time.Hour)
+
+	token, expiresAt, err := tokens.Issue(Claims{UserID: 7, Name: "alice"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if time.Until(expiresAt) <= 59*time.Minute {
+		t.Fatalf("expected token to live about an hour, got %v", time.Until(expiresAt))
+	}
+
+	claims, err := tokens.Parse(token)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if claims.UserID != 7 || claims.Name != "alice" {
+		t.Fatalf("unexpected claims: %+v", claims)
+	}
+}
+
+func TestTokens_Rejects(t *testing.T) {
+	tokens := NewTokens("secret", time.Hour)
+	token, _, _ := tokens.Issue(Claims{UserID: 7, Name: "alice"})
+
+	// подпись чужим секретом
+	if _, err := NewTokens("other", time.Hour).Parse(token); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expected ErrInvalidToken for foreign secret, got %v", err)
+	}
+
+	// подменённое содержимое при сохранённой подписи
+	parts := strings.Split(token, ".")
+	forged, _, _ := NewTokens("other", time.Hour).Issue(Claims{UserID: 1, Name: "admin"})
+	parts[1] = strings.Split(forged, ".")[1]
+	if _, err := tokens.Parse(strings.Join(parts, ".")); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expected ErrInvalidToken for forged payload, got %v", err)
+	}
+
+	// истёкший токен
+	tokens.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	if _, err := tokens.Parse(token); !errors.Is(err, ErrTokenExpired) {
+		t.Fatalf("expected ErrTokenExpired, got %v", err)
+	}
+}
+
+func TestPassword(t *testing.T) {
+	hash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !CheckPassword(hash, "correct horse") || CheckPassword(hash, "wrong horse") {
+		t.Fatalf("password check mismatch")
+	}
+}
@@ -0,0 +1,2 @@
+-- Индекс под keyset-пагинацию корней и детей: (pid, created_at, cid)
+CREATE INDEX IF NOT EXISTS idx_comments_pid_created_cid ON comments (pid, created_at, cid);
@@ -0,0 +1,17 @@
+ALTER TABLE comments
+    ADD COLUMN IF NOT EXISTS edited_at TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS edit_count INT NOT NULL DEFAULT 0;
+
+-- Предыдущие версии текста: created_at - с какого момента версия была актуальной, replaced_at - когда её заменили.
+-- next_diff - дифф версии со следующей, посчитанный при правке; NULL - текст успели поменять параллельно, дифф считается при чтении
+CREATE TABLE IF NOT EXISTS comment_revisions (
+    rid SERIAL PRIMARY KEY,
+    cid INT NOT NULL,
+    content TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    replaced_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    next_diff JSONB,
+    CONSTRAINT fk_revisions_comment FOREIGN KEY (cid) REFERENCES comments (cid) ON UPDATE CASCADE ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_comment_revisions_cid ON comment_revisions (cid, rid);
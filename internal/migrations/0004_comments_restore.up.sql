@@ -0,0 +1,4 @@
+-- Кто и когда последним восстановил мягко удалённый комментарий
+ALTER TABLE comments
+    ADD COLUMN IF NOT EXISTS restored_at TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS restored_by TEXT;
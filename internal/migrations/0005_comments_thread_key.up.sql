@@ -0,0 +1,11 @@
+-- Ветки обсуждений под разными внешними ресурсами (статья, товар и т.п.);
+-- существующие комментарии переносятся в общий поток default
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS thread_key TEXT;
+
+UPDATE comments SET thread_key = 'default' WHERE thread_key IS NULL;
+
+ALTER TABLE comments
+    ALTER COLUMN thread_key SET DEFAULT 'default',
+    ALTER COLUMN thread_key SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_comments_thread_roots ON comments (thread_key, created_at, cid) WHERE pid IS NULL;
@@ -0,0 +1,7 @@
+-- Блокировка ответов: на комментарий (действует на всю ветку под ним) и на поток целиком
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS locked_at TIMESTAMPTZ;
+
+CREATE TABLE IF NOT EXISTS threads (
+    thread_key TEXT PRIMARY KEY,
+    locked_at TIMESTAMPTZ
+);
@@ -0,0 +1,4 @@
+-- Закреплённые корневые комментарии (объявления, FAQ) выводятся над обычной выдачей первой страницы
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS pinned_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_comments_thread_pinned ON comments (thread_key, pinned_at) WHERE pinned_at IS NOT NULL;
@@ -0,0 +1,13 @@
+-- Голоса: один голос на комментарий от одного голосующего; счётчики в comments нужны для сортировки по рейтингу
+CREATE TABLE IF NOT EXISTS comment_votes (
+    cid INT NOT NULL,
+    voter TEXT NOT NULL,
+    value SMALLINT NOT NULL CHECK (value IN (-1, 1)),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (cid, voter),
+    CONSTRAINT fk_votes_comment FOREIGN KEY (cid) REFERENCES comments (cid) ON UPDATE CASCADE ON DELETE CASCADE
+);
+
+ALTER TABLE comments
+    ADD COLUMN IF NOT EXISTS upvotes INT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS downvotes INT NOT NULL DEFAULT 0;
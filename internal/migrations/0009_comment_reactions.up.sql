@@ -0,0 +1,9 @@
+-- Реакции: каждый клиент может поставить комментарию по одной реакции каждого вида
+CREATE TABLE IF NOT EXISTS comment_reactions (
+    cid INT NOT NULL,
+    emoji TEXT NOT NULL,
+    reactor TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (cid, emoji, reactor),
+    CONSTRAINT fk_reactions_comment FOREIGN KEY (cid) REFERENCES comments (cid) ON UPDATE CASCADE ON DELETE CASCADE
+);
@@ -0,0 +1,12 @@
+-- Пользователи; author у комментария остаётся отображаемым именем, а принадлежность определяется author_id
+CREATE TABLE IF NOT EXISTS users (
+    id SERIAL PRIMARY KEY,
+    username TEXT NOT NULL UNIQUE,
+    password_hash TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+ALTER TABLE comments
+    ADD COLUMN IF NOT EXISTS author_id INT REFERENCES users (id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_comments_author_id ON comments (author_id) WHERE author_id IS NOT NULL;
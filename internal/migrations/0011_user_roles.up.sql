@@ -0,0 +1,3 @@
+-- Роли пользователей: user - обычный автор, moderator - скрывает и восстанавливает чужие комментарии, admin - ещё и удаляет навсегда
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'user' CHECK (role IN ('user', 'moderator', 'admin'));
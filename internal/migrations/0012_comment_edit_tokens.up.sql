@@ -0,0 +1,3 @@
+-- Секрет для правки и скрытия анонимного комментария без аккаунта; хранится только sha256-хеш
+ALTER TABLE comments
+    ADD COLUMN IF NOT EXISTS edit_token_hash TEXT;
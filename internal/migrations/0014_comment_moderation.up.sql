@@ -0,0 +1,11 @@
+-- Премодерация: в премодерируемых потоках новые комментарии ждут решения модератора (pending) и до одобрения видны только автору
+ALTER TABLE comments
+    ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'approved' CHECK (status IN ('pending', 'approved', 'rejected')),
+    ADD COLUMN IF NOT EXISTS moderation_reason TEXT,
+    ADD COLUMN IF NOT EXISTS moderated_by TEXT,
+    ADD COLUMN IF NOT EXISTS moderated_at TIMESTAMPTZ;
+
+-- очередь модерации читается от старых к новым
+CREATE INDEX IF NOT EXISTS idx_comments_pending ON comments (created_at, cid) WHERE status = 'pending';
+
+ALTER TABLE threads ADD COLUMN IF NOT EXISTS premoderated BOOLEAN NOT NULL DEFAULT false;
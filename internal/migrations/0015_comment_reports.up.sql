@@ -0,0 +1,18 @@
+-- Жалобы читателей на комментарии; от одного клиента на комментарий принимается одна открытая жалоба
+CREATE TABLE IF NOT EXISTS comment_reports (
+    id SERIAL PRIMARY KEY,
+    cid INT NOT NULL REFERENCES comments (cid) ON DELETE CASCADE,
+    reporter TEXT NOT NULL,
+    reason TEXT NOT NULL CHECK (reason IN ('spam', 'abuse', 'harassment', 'off_topic', 'other')),
+    details TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    resolved_at TIMESTAMPTZ,
+    resolved_by TEXT,
+    resolution TEXT
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_comment_reports_open ON comment_reports (cid, reporter) WHERE resolved_at IS NULL;
+
+-- Скрытие по жалобам отдельно от мягкого удаления: скрытый комментарий ждёт решения модератора
+-- и не удаляется чисткой
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS hidden_at TIMESTAMPTZ;
@@ -0,0 +1,28 @@
+-- Наивный байесовский спам-классификатор, обучаемый на решениях модераторов
+
+-- оценка классификатора на момент создания или последней правки; NULL - не оценивался
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS spam_score DOUBLE PRECISION;
+
+-- сколько комментариев каждого класса видел классификатор
+CREATE TABLE IF NOT EXISTS spam_classes (
+    label TEXT PRIMARY KEY CHECK (label IN ('spam', 'ham')),
+    docs INT NOT NULL DEFAULT 0
+);
+
+INSERT INTO spam_classes (label) VALUES ('spam'), ('ham') ON CONFLICT DO NOTHING;
+
+-- в скольких комментариях каждого класса встречался токен (слово или признак автора)
+CREATE TABLE IF NOT EXISTS spam_tokens (
+    token TEXT PRIMARY KEY,
+    spam INT NOT NULL DEFAULT 0,
+    ham INT NOT NULL DEFAULT 0
+);
+
+-- на чём уже обучен классификатор: при пересмотре решения старый вклад вычитается по сохранённым токенам;
+-- без внешнего ключа, чтобы удаление комментария навсегда не стирало обучение
+CREATE TABLE IF NOT EXISTS spam_training (
+    cid INT PRIMARY KEY,
+    label TEXT CHECK (label IN ('spam', 'ham')),
+    tokens TEXT[] NOT NULL DEFAULT '{}',
+    trained_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,8 @@
+-- Вёдра лимита создания комментариев, общие для всех экземпляров приложения
+CREATE TABLE IF NOT EXISTS rate_limits (
+    key TEXT PRIMARY KEY,
+    tokens DOUBLE PRECISION NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_rate_limits_updated ON rate_limits (updated_at);
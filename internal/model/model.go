@@ -9,26 +9,255 @@ const (
 	ByCreated = "created"
 	OrderASC  = "ascending"
 	OrderDESC = "descending"
+
+	// ключи сортировки братьев внутри дерева
+	SortOldest = "oldest"
+	SortNewest = "newest"
+	SortAuthor = "author"
+
+	// ключи сортировки по голосам - общие для выдачи корней, детей и братьев в дереве
+	SortTop           = "top"
+	SortControversial = "controversial"
+	SortHot           = "hot"
+
+	DefaultThread = "default" // поток, в который попадают комментарии без явного thread_key
+
+	// роли пользователей
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+
+	// права ключей доступа серверных интеграций
+	ScopeCommentsWrite  = "comments:write"  // создание комментариев от имени ключа
+	ScopeCommentsDelete = "comments:delete" // скрытие и удаление любых комментариев
+	ScopeModeration     = "moderation"      // действия модератора: восстановление, перенос, закрепление, блокировки
+
+	// статусы премодерации комментария
+	StatusPending  = "pending"
+	StatusApproved = "approved"
+	StatusRejected = "rejected"
+
+	// категории жалоб на комментарий
+	ReportSpam       = "spam"
+	ReportAbuse      = "abuse"
+	ReportHarassment = "harassment"
+	ReportOffTopic   = "off_topic"
+	ReportOther      = "other"
+
+	// решения модератора по жалобам
+	ResolveDismiss = "dismiss" // жалобы необоснованны, комментарий остаётся как есть
+	ResolveHide    = "hide"    // комментарий скрывается (hidden_at) до отмены через dismiss
+	ResolveDelete  = "delete"  // комментарий удаляется навсегда вместе с ветвью
 )
 
 type DBComment struct {
-	ID        int
-	ParentID  *int
-	Text      string
-	CreatedAt time.Time
-	DeletedAt *time.Time
-	Author    string
+	ID         int
+	ParentID   *int
+	Text       string
+	CreatedAt  time.Time
+	DeletedAt  *time.Time
+	Author     string
+	AuthorID   *int   // nil - анонимный комментарий
+	EditHash   string // sha256-хеш секрета правки анонимного комментария
+	EditedAt   *time.Time
+	EditCount  int
+	RestoredAt *time.Time
+	RestoredBy string
+	ThreadKey  string
+	LockedAt   *time.Time
+	PinnedAt   *time.Time
+	Upvotes    int
+	Downvotes  int
+	Status     string     // статус премодерации, до одобрения комментарий виден только автору
+	ModReason  string     // причина решения модератора
+	ModBy      string     // кто принял решение
+	ModAt      *time.Time // когда принято решение
+	SpamScore  *float64   // оценка спам-классификатора, nil - не оценивался
+	HiddenAt   *time.Time // скрыт по жалобам до решения модератора
+
+	ReplyCount      int    // количество прямых ответов, заполняется в выборках с превью, списке детей и на срезе глубины
+	DescendantCount int    // количество всех потомков, заполняется только в выборке прямых детей
+	SortKey         string // значение ключа сортировки в текстовом виде, заполняется только в постраничных выборках
 }
 
 type CommentCreateData struct {
-	ParentID *int   `json:"parent_id,omitempty"`
-	Text     string `json:"content"`
-	Author   string `json:"author,omitempty"`
+	ParentID  *int     `json:"parent_id,omitempty"`
+	Text      string   `json:"content"`
+	Author    string   `json:"author,omitempty"`
+	ThreadKey string   `json:"thread_key,omitempty"` // для ответа по умолчанию берётся поток родителя
+	AuthorID  *int     `json:"-"`                    // проставляется сервисом из токена, вместе с Author
+	EditHash  string   `json:"-"`                    // хеш секрета правки, только у анонимных комментариев
+	Status    string   `json:"-"`                    // pending в премодерируемом потоке, иначе approved
+	ModReason string   `json:"-"`                    // почему фильтр отправил комментарий в очередь модерации
+	SpamScore *float64 `json:"-"`                    // оценка спам-классификатора
+}
+
+type CommentEditData struct {
+	Text string `json:"content"`
+}
+
+// CommentVoteData - голос: 1 - за, -1 - против, 0 - отозвать свой голос
+type CommentVoteData struct {
+	Value int `json:"value"`
+}
+
+// DBReaction - количество реакций одного вида на комментарий; Mine - среди них есть реакция запросившего клиента
+type DBReaction struct {
+	CommentID int
+	Emoji     string
+	Count     int
+	Mine      bool
+}
+
+type DBUser struct {
+	ID           int
+	Username     string
+	PasswordHash string
+	Role         string
+	CreatedAt    time.Time
+}
+
+// UserCredentials - тело запросов регистрации и входа
+type UserCredentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// UserRoleData - тело запроса смены роли
+type UserRoleData struct {
+	Role string `json:"role"`
+}
+
+// DBAPIKey - ключ доступа интеграции; сам ключ не хранится, только его хеш
+type DBAPIKey struct {
+	ID         int
+	Name       string
+	Prefix     string
+	KeyHash    string
+	Scopes     []string
+	CreatedBy  string
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+}
+
+// APIKeyCreateData - тело запроса выпуска ключа
+type APIKeyCreateData struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+// CommentReportData - тело жалобы на комментарий
+type CommentReportData struct {
+	Reason  string `json:"reason"`
+	Details string `json:"details,omitempty"`
+}
+
+// ReportListRequest - параметры списка открытых жалоб
+type ReportListRequest struct {
+	Page  int `form:"page"`
+	Limit int `form:"limit"`
+}
+
+// ReportResolveData - решение модератора по всем открытым жалобам на комментарий
+type ReportResolveData struct {
+	Action string `json:"action"`
+}
+
+// DBReportGroup - открытые жалобы на один комментарий
+type DBReportGroup struct {
+	Comment DBComment
+	Count   int
+	Reasons []string // категории всех открытых жалоб, от первой к последней
+	Details []string // непустые пояснения жалоб
+	FirstAt time.Time
+	LastAt  time.Time
+}
+
+// Классы спам-классификатора
+const (
+	SpamLabel = "spam"
+	HamLabel  = "ham"
+)
+
+// DBSpamStats - статистика классификатора: сколько решений каждого класса он видел
+// и в скольких комментариях каждого класса встречались запрошенные токены
+type DBSpamStats struct {
+	SpamDocs int
+	HamDocs  int
+	Tokens   map[string]SpamTokenCount // только токены, которые уже встречались
+}
+
+type SpamTokenCount struct {
+	Spam int
+	Ham  int
+}
+
+// DBThread - настройки потока; строки нет, пока поток ни разу не настраивали
+type DBThread struct {
+	Key          string
+	LockedAt     *time.Time
+	Premoderated bool
+}
+
+// CommentMoveData - новый родитель перемещаемой ветки, null - сделать комментарий корневым
+type CommentMoveData struct {
+	ParentID *int `json:"parent_id"`
+}
+
+// DBRevision - предыдущая версия текста комментария
+type DBRevision struct {
+	ID         int
+	CommentID  int
+	Text       string
+	CreatedAt  time.Time // с какого момента версия была актуальной
+	ReplacedAt time.Time // когда версию заменили правкой
+	NextDiff   []byte    // JSON диффа со следующей версией, nil - не сохранён
 }
 
 type RootRequest struct {
-	Page  int    `form:"page"`
-	Limit int    `form:"limit"`
-	Sort  string `form:"sort"`
-	Order string `form:"order"`
+	Page     int    `form:"page"`
+	Limit    int    `form:"limit"`
+	Sort     string `form:"sort"`
+	Order    string `form:"order"`
+	Replies  int    `form:"replies"`  // сколько первых ответов встраивать в каждый узел превью
+	Depth    int    `form:"depth"`    // глубина превью ответов под корнем
+	Cursor   string `form:"cursor"`   // непрозрачный курсор из X-Next-Cursor; если задан, page, sort и order игнорируются
+	Envelope bool   `form:"envelope"` // ответ в виде конверта {items, page, limit, total, next, prev}
+	Thread   string `form:"-"`        // ключ потока из пути, для /comments - default
+}
+
+// PageQuery - провалидированные параметры выборки страницы для repository-слоя
+type PageQuery struct {
+	Limit  int
+	Offset int
+	Sort   string  // колонка сортировки
+	Order  string  // ASC/DESC
+	After  *Cursor // keyset-позиция; если задана, Offset равен 0
+	Thread string  // поток, в котором выбираются корни
+	Viewer int     // автор, которому видны его непроверенные комментарии; 0 - только одобренные
+}
+
+// ModerationRequest - параметры очереди премодерации
+type ModerationRequest struct {
+	Page   int    `form:"page"`
+	Limit  int    `form:"limit"`
+	Thread string `form:"thread"` // пусто - очередь всех потоков
+}
+
+// CommentModerationData - тело решения модератора
+type CommentModerationData struct {
+	Reason string `json:"reason,omitempty"`
+}
+
+// Cursor - позиция последнего элемента предыдущей страницы: (ключ сортировки, cid)
+type Cursor struct {
+	Value string
+	ID    int
+}
+
+type TreeRequest struct {
+	Sort    string `form:"sort"`
+	Depth   int    `form:"depth"`   // сколько уровней потомков отдавать, 0 - без ограничения
+	Context int    `form:"context"` // сколько предков показать над комментарием (permalink-вид), 0 - без предков
 }
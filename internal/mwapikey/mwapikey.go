@@ -0,0 +1,87 @@
+// Package mwapikey provides authentication of server-to-server requests by API keys
+package mwapikey
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/UnendingLoop/CommentTree/internal/model"
+	"github.com/UnendingLoop/CommentTree/internal/mwclient"
+	"github.com/UnendingLoop/CommentTree/internal/mwlogger"
+	"github.com/wb-go/wbf/ginext"
+)
+
+type apiKey struct{}
+
+// HeaderAPIKey - заголовок, в котором интеграция передаёт свой ключ
+const HeaderAPIKey = "X-API-Key"
+
+// Authenticator проверяет ключ и возвращает его запись; для неизвестного или отозванного ключа - ok == false
+type Authenticator interface {
+	AuthenticateAPIKey(ctx context.Context, key string) (res *model.DBAPIKey, ok bool, err error)
+}
+
+// NewMWAPIKey - обёртка, проверяющая ключ из X-API-Key и кладущая его в контекст запроса;
+// запросы без ключа проходят дальше как есть, с негодным ключом - получают 401
+func NewMWAPIKey(next http.Handler, keys Authenticator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimSpace(r.Header.Get(HeaderAPIKey))
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		logger := mwlogger.LoggerFromContext(r.Context())
+		res, ok, err := keys.AuthenticateAPIKey(r.Context(), key)
+		switch {
+		case err != nil: // причина уже записана в лог сервисом
+			writeError(w, http.StatusInternalServerError, "something went wrong. Try again later")
+			return
+		case !ok:
+			logger.Warn().Msg("Request rejected: invalid or revoked API key")
+			writeError(w, http.StatusUnauthorized, "invalid or revoked API key")
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(WithAPIKey(r.Context(), res)))
+	})
+}
+
+// RequireScope - обработчик маршрута gin, стоящий перед основным: запрос с ключом без права scope получает 403
+// ещё до обработчика. Запросы без ключа проходят дальше - права пользователей зависят от роли и авторства
+// комментария, их проверяет сервис
+func RequireScope(scope string) ginext.HandlerFunc {
+	return func(ctx *ginext.Context) {
+		key, ok := APIKeyFromContext(ctx.Request.Context())
+		if ok && !slices.Contains(key.Scopes, scope) {
+			logger := mwlogger.LoggerFromContext(ctx.Request.Context())
+			logger.Warn().Msg(fmt.Sprintf("Request rejected: API key %d has no %q scope", key.ID, scope))
+			ctx.AbortWithStatusJSON(http.StatusForbidden, map[string]string{"error": "API key has no " + scope + " scope"})
+			return
+		}
+		ctx.Next()
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
+
+// WithAPIKey кладёт ключ в контекст; он же становится идентификатором клиента для реакций
+func WithAPIKey(ctx context.Context, key *model.DBAPIKey) context.Context {
+	ctx = mwclient.WithClient(ctx, "key:"+strconv.Itoa(key.ID))
+	return context.WithValue(ctx, apiKey{}, key)
+}
+
+// APIKeyFromContext extracts authenticated API key from context - used in service-layer
+func APIKeyFromContext(ctx context.Context) (*model.DBAPIKey, bool) {
+	key, ok := ctx.Value(apiKey{}).(*model.DBAPIKey)
+	return key, ok
+}
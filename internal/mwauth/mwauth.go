@@ -0,0 +1,97 @@
+// Package mwauth provides authentication of requests by bearer tokens
+package mwauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/UnendingLoop/CommentTree/internal/auth"
+	"github.com/UnendingLoop/CommentTree/internal/mwclient"
+	"github.com/UnendingLoop/CommentTree/internal/mwlogger"
+)
+
+type authenticatedUser struct{}
+
+type editToken struct{}
+
+// HeaderEditToken - заголовок с секретом, выданным при создании анонимного комментария
+const HeaderEditToken = "X-Edit-Token"
+
+// Users возвращает актуальную роль пользователя; для удалённого пользователя - ok == false
+type Users interface {
+	CurrentRole(ctx context.Context, userID int) (role string, ok bool, err error)
+}
+
+// NewMWAuth - обёртка, проверяющая токен из Authorization: Bearer и кладущая пользователя в контекст запроса;
+// запросы без токена проходят анонимно, с негодным токеном - получают 401.
+// Роль берётся из базы, а не из токена: смена роли действует сразу, без повторного входа
+func NewMWAuth(next http.Handler, tokens *auth.Tokens, users Users) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token := strings.TrimSpace(r.Header.Get(HeaderEditToken)); token != "" {
+			r = r.WithContext(WithEditToken(r.Context(), token))
+		}
+
+		header := r.Header.Get("Authorization")
+		if header == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token, ok := strings.CutPrefix(header, "Bearer ")
+		claims, err := tokens.Parse(strings.TrimSpace(token))
+		logger := mwlogger.LoggerFromContext(r.Context())
+		if !ok || err != nil {
+			logger.Warn().Msg("Request rejected: invalid or expired token")
+			writeError(w, http.StatusUnauthorized, "invalid or expired token")
+			return
+		}
+
+		role, found, err := users.CurrentRole(r.Context(), claims.UserID)
+		switch {
+		case err != nil:
+			logger.Error().Err(err).Msg(fmt.Sprintf("Failed to fetch role of user %d", claims.UserID))
+			writeError(w, http.StatusInternalServerError, "something went wrong. Try again later")
+			return
+		case !found:
+			logger.Warn().Msg(fmt.Sprintf("Request rejected: user %d no longer exists", claims.UserID))
+			writeError(w, http.StatusUnauthorized, "invalid or expired token")
+			return
+		}
+		claims.Role = role
+
+		next.ServeHTTP(w, r.WithContext(WithUser(r.Context(), claims)))
+	})
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
+
+// WithUser кладёт пользователя в контекст; он же становится идентификатором клиента для реакций
+func WithUser(ctx context.Context, claims *auth.Claims) context.Context {
+	ctx = mwclient.WithClient(ctx, "user:"+strconv.Itoa(claims.UserID))
+	return context.WithValue(ctx, authenticatedUser{}, claims)
+}
+
+// WithEditToken кладёт секрет правки в контекст
+func WithEditToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, editToken{}, token)
+}
+
+// EditTokenFromContext extracts edit token of anonymous comment from context - used in service-layer
+func EditTokenFromContext(ctx context.Context) string {
+	token, _ := ctx.Value(editToken{}).(string)
+	return token
+}
+
+// UserFromContext extracts authenticated user from context - used in service-layer
+func UserFromContext(ctx context.Context) (*auth.Claims, bool) {
+	claims, ok := ctx.Value(authenticatedUser{}).(*auth.Claims)
+	return claims, ok
+}
@@ -0,0 +1,68 @@
+// Package mwclient provides client identity for every request
+package mwclient
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+)
+
+type clientID struct{}
+
+type clientIP struct{}
+
+// HeaderClientID - заголовок, которым клиент (браузер, сессия) представляется для реакций
+const HeaderClientID = "X-Client-Id"
+
+// NewMWClient - обёртка, кладущая идентификатор клиента из заголовка и его IP в контекст запроса;
+// trustProxy - приложение стоит за прокси, и IP клиента берётся из X-Forwarded-For
+func NewMWClient(next http.Handler, trustProxy bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(WithIP(r.Context(), remoteIP(r, trustProxy)))
+		// пространства user: и key: заняты пользователями и ключами интеграций - из заголовка их не принимаем
+		id := strings.TrimSpace(r.Header.Get(HeaderClientID))
+		if id != "" && len(id) <= 256 && !strings.HasPrefix(id, "user:") && !strings.HasPrefix(id, "key:") {
+			r = r.WithContext(WithClient(r.Context(), id))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// remoteIP - адрес соединения; за доверенным прокси - последний адрес из X-Forwarded-For: его дописал сам прокси,
+// а всё, что левее, мог прислать клиент
+func remoteIP(r *http.Request, trustProxy bool) string {
+	if forwarded := r.Header.Get("X-Forwarded-For"); trustProxy && forwarded != "" {
+		last := forwarded[strings.LastIndex(forwarded, ",")+1:]
+		if ip := net.ParseIP(strings.TrimSpace(last)); ip != nil {
+			return ip.String()
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// WithIP кладёт IP клиента в контекст
+func WithIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIP{}, ip)
+}
+
+// IPFromContext extracts client IP from context - used in service-layer, "" outside of HTTP requests
+func IPFromContext(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIP{}).(string)
+	return ip
+}
+
+// WithClient кладёт идентификатор клиента в контекст
+func WithClient(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, clientID{}, id)
+}
+
+// ClientFromContext extracts client identity from context - used in service-layer, "" if client is anonymous
+func ClientFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(clientID{}).(string)
+	return id
+}
@@ -5,15 +5,15 @@ import (
 	"context"
 	"net/http"
 
-	"github.com/wb-go/wbf/ginext"
 	"github.com/wb-go/wbf/helpers"
 	"github.com/wb-go/wbf/zlog"
 )
 
 type loggerWithRequestID struct{}
 
-// NewMWLogger - обёртка для логирования запросов с присвоением UUID каждому запросу и пробросу логгера в контекст запроса
-func NewMWLogger(next *ginext.Engine) http.Handler {
+// NewMWLogger - обёртка для логирования запросов с присвоением UUID каждому запросу и пробросу логгера в контекст запроса;
+// должна быть внешней, чтобы отказы других обёрток тоже попадали в лог с request_id
+func NewMWLogger(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Fetching/generating UUID for request
 		reqID := r.Header.Get("X-Request-Id")
@@ -0,0 +1,122 @@
+// Package ratelimit provides token buckets for throttling comment creation
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrInvalidRule error = errors.New(`rate limit must look like "10/1m"`)
+
+// Rule - не больше Limit запросов за Per; ведро вмещает Limit жетонов и равномерно пополняется за Per.
+// Нулевой Limit выключает правило
+type Rule struct {
+	Limit int
+	Per   time.Duration
+}
+
+// ParseRule читает правило вида "10/1m"; пустая строка или "0" - правило выключено
+func ParseRule(s string) (Rule, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "0" {
+		return Rule{}, nil
+	}
+	limitRaw, perRaw, ok := strings.Cut(s, "/")
+	if !ok {
+		return Rule{}, fmt.Errorf("%w, got %q", ErrInvalidRule, s)
+	}
+	limit, err := strconv.Atoi(strings.TrimSpace(limitRaw))
+	if err != nil || limit < 0 {
+		return Rule{}, fmt.Errorf("%w, got %q", ErrInvalidRule, s)
+	}
+	per, err := time.ParseDuration(strings.TrimSpace(perRaw))
+	if err != nil || per <= 0 {
+		return Rule{}, fmt.Errorf("%w, got %q", ErrInvalidRule, s)
+	}
+	return Rule{Limit: limit, Per: per}, nil
+}
+
+// Bucket - ведро конкретного ключа (автора, IP, потока) по правилу
+type Bucket struct {
+	Key string
+	Rule
+}
+
+// State - остаток жетонов ведра на момент UpdatedAt
+type State struct {
+	Tokens    float64
+	UpdatedAt time.Time
+}
+
+// Store хранит вёдра. Take берёт по жетону из каждого ведра разом: если хоть в одном пусто,
+// не списывается ничего и возвращается, через сколько можно повторить; 0 - запрос разрешён
+type Store interface {
+	Take(ctx context.Context, buckets []Bucket) (retryAfter time.Duration, err error)
+}
+
+// Apply - общая для хранилищ логика Take над состояниями вёдер: states - найденные состояния по ключу,
+// отсутствующее ведро считается полным. При успехе states обновляется, при отказе остаётся как было
+func Apply(buckets []Bucket, states map[string]State, now time.Time) time.Duration {
+	next := make(map[string]State, len(buckets))
+	var retryAfter time.Duration
+	for _, b := range buckets {
+		rate := float64(b.Limit) / b.Per.Seconds() // жетонов в секунду
+		tokens := float64(b.Limit)
+		if s, ok := states[b.Key]; ok {
+			elapsed := max(now.Sub(s.UpdatedAt).Seconds(), 0)
+			tokens = min(float64(b.Limit), s.Tokens+elapsed*rate)
+		}
+
+		if tokens < 1 {
+			wait := time.Duration(math.Ceil((1 - tokens) / rate * float64(time.Second)))
+			retryAfter = max(retryAfter, wait)
+			continue
+		}
+		next[b.Key] = State{Tokens: tokens - 1, UpdatedAt: now}
+	}
+
+	if retryAfter > 0 {
+		return retryAfter
+	}
+	for key, s := range next {
+		states[key] = s
+	}
+	return 0
+}
+
+// MemoryStore - вёдра в памяти процесса: лимит действует в пределах одного экземпляра приложения
+type MemoryStore struct {
+	mu        sync.Mutex
+	states    map[string]State
+	ttl       time.Duration // ведро, не тронутое дольше ttl, снова полное - его можно забыть
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// NewMemoryStore - ttl должен быть не меньше самого длинного Per среди правил
+func NewMemoryStore(ttl time.Duration) *MemoryStore {
+	return &MemoryStore{states: make(map[string]State), ttl: ttl, now: time.Now}
+}
+
+func (m *MemoryStore) Take(ctx context.Context, buckets []Bucket) (time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	if now.Sub(m.lastSweep) > m.ttl {
+		for key, s := range m.states {
+			if now.Sub(s.UpdatedAt) > m.ttl {
+				delete(m.states, key)
+			}
+		}
+		m.lastSweep = now
+	}
+
+	return Apply(buckets, m.states, now), nil
+}
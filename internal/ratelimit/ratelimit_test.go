@@ -0,0 +1,125 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"testing"
+	"time"
+)
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Rule
+		err  bool
+	}{
+		{"10/1m", Rule{Limit: 10, Per: time.Minute}, false},
+		{" 3 / 10s ", Rule{Limit: 3, Per: 10 * time.Second}, false},
+		{"", Rule{}, false},
+		{"0", Rule{}, false},
+		{"10", Rule{}, true},
+		{"x/1m", Rule{}, true},
+		{"10/0s", Rule{}, true},
+		{"-1/1m", Rule{}, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseRule(tt.in)
+		if tt.err != (err != nil) || got != tt.want {
+			t.Fatalf("ParseRule(%q) = %+v, %v", tt.in, got, err)
+		}
+		if tt.err && !errors.Is(err, ErrInvalidRule) {
+			t.Fatalf("ParseRule(%q): expected ErrInvalidRule, got %v", tt.in, err)
+		}
+	}
+}
+
+func TestMemoryStore_Take(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	store := NewMemoryStore(time.Minute)
+	store.now = func() time.Time { return now }
+
+	author := Bucket{Key: "author:a", Rule: Rule{Limit: 2, Per: time.Minute}}
+	thread := Bucket{Key: "thread:t", Rule: Rule{Limit: 3, Per: time.Minute}}
+	take := func() time.Duration {
+		retry, err := store.Take(context.Background(), []Bucket{author, thread})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return retry
+	}
+
+	// ведро автора вмещает два запроса подряд
+	if take() != 0 || take() != 0 {
+		t.Fatalf("expected burst of 2 to pass")
+	}
+	// третий упирается в автора: жетон вернётся через 30 секунд, из ведра потока ничего не списано
+	if retry := take(); retry != 30*time.Second {
+		t.Fatalf("expected retry after 30s, got %v", retry)
+	}
+	if s := store.states["thread:t"]; s.Tokens != 1 {
+		t.Fatalf("expected denied request not to consume thread tokens, got %v", s.Tokens)
+	}
+
+	now = now.Add(30 * time.Second)
+	if take() != 0 {
+		t.Fatalf("expected refilled token to pass")
+	}
+	// ведро автора снова пусто
+	if retry := take(); retry != 30*time.Second {
+		t.Fatalf("expected retry after 30s, got %v", retry)
+	}
+
+	// давно не тронутые вёдра забываются
+	now = now.Add(2 * time.Minute)
+	if _, err := store.Take(context.Background(), []Bucket{{Key: "ip:1", Rule: Rule{Limit: 1, Per: time.Minute}}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(store.states) != 1 {
+		t.Fatalf("expected stale buckets to be swept, got %d", len(store.states))
+	}
+}
+
+func TestApply_StoredState(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	ip := Bucket{Key: "ip:1", Rule: Rule{Limit: 6, Per: time.Minute}}              // жетон за 10 секунд
+	author := Bucket{Key: "author:user:7", Rule: Rule{Limit: 2, Per: time.Minute}} // жетон за 30 секунд
+
+	// остаток пополняется от сохранённого состояния, но не выше ёмкости ведра
+	states := map[string]State{
+		ip.Key:     {Tokens: 0.5, UpdatedAt: now.Add(-5 * time.Second)},
+		author.Key: {Tokens: 1, UpdatedAt: now.Add(-time.Hour)},
+	}
+	if retry := Apply([]Bucket{ip, author}, states, now); retry != 0 {
+		t.Fatalf("expected request to pass, got retry after %v", retry)
+	}
+	if s := states[ip.Key]; s.Tokens != 0 || !s.UpdatedAt.Equal(now) {
+		t.Fatalf("expected ip bucket refilled to 1 and spent, got %+v", s)
+	}
+	if s := states[author.Key]; s.Tokens != 1 {
+		t.Fatalf("expected author bucket capped at 2 before spending, got %+v", s)
+	}
+
+	// пусты оба ведра: ждать столько, сколько нужно самому медленному, и ничего не списано
+	states[author.Key] = State{Tokens: 0.25, UpdatedAt: now}
+	before := maps.Clone(states)
+	if retry := Apply([]Bucket{ip, author}, states, now); retry != 22500*time.Millisecond {
+		t.Fatalf("expected retry after 22.5s, got %v", retry)
+	}
+	if !maps.Equal(states, before) {
+		t.Fatalf("expected denied request to leave states untouched, got %+v", states)
+	}
+
+	// часы экземпляра отстают от сохранённого времени - пополнения нет, но и отрицательного тоже
+	states = map[string]State{ip.Key: {Tokens: 1, UpdatedAt: now.Add(time.Minute)}}
+	if retry := Apply([]Bucket{ip}, states, now); retry != 0 || states[ip.Key].Tokens != 0 {
+		t.Fatalf("expected single token to be spent without refill, got %+v", states[ip.Key])
+	}
+
+	// ведра без сохранённого состояния считаются полными
+	states = map[string]State{}
+	if retry := Apply([]Bucket{author}, states, now); retry != 0 || states[author.Key].Tokens != 1 {
+		t.Fatalf("expected new bucket to start full, got %+v", states[author.Key])
+	}
+}
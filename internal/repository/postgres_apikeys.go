@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/UnendingLoop/CommentTree/internal/model"
+	"github.com/lib/pq"
+)
+
+const apiKeyColumns = `id, name, prefix, key_hash, scopes, created_by, created_at, last_used_at, revoked_at`
+
+func scanAPIKey(row rowScanner, k *model.DBAPIKey) error {
+	return row.Scan(&k.ID, &k.Name, &k.Prefix, &k.KeyHash, pq.Array(&k.Scopes), &k.CreatedBy, &k.CreatedAt, &k.LastUsedAt, &k.RevokedAt)
+}
+
+func (p PostgresRepo) CreateAPIKey(ctx context.Context, key *model.DBAPIKey) (*model.DBAPIKey, error) {
+	query := `INSERT INTO api_keys (name, prefix, key_hash, scopes, created_by) VALUES ($1, $2, $3, $4, $5)
+	RETURNING ` + apiKeyColumns
+	var k model.DBAPIKey
+	row := p.db.QueryRowContext(ctx, query, key.Name, key.Prefix, key.KeyHash, pq.Array(key.Scopes), key.CreatedBy)
+	if err := scanAPIKey(row, &k); err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+func (p PostgresRepo) ListAPIKeys(ctx context.Context) ([]model.DBAPIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys ORDER BY id`
+	rows, err := p.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []model.DBAPIKey{}
+	for rows.Next() {
+		var k model.DBAPIKey
+		if err := scanAPIKey(rows, &k); err != nil {
+			return nil, err
+		}
+		res = append(res, k)
+	}
+	return res, rows.Err()
+}
+
+// RevokeAPIKey отзывает ключ; повторный отзыв оставляет прежнее время отзыва
+func (p PostgresRepo) RevokeAPIKey(ctx context.Context, id int) (*model.DBAPIKey, error) {
+	query := `UPDATE api_keys SET revoked_at = COALESCE(revoked_at, now()) WHERE id = $1 RETURNING ` + apiKeyColumns
+	var k model.DBAPIKey
+	if err := scanAPIKey(p.db.QueryRowContext(ctx, query, id), &k); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrAPIKeyNotFound // 404
+		default:
+			return nil, err
+		}
+	}
+	return &k, nil
+}
+
+// UseAPIKey находит действующий ключ по хешу и отмечает время его использования
+func (p PostgresRepo) UseAPIKey(ctx context.Context, keyHash string) (*model.DBAPIKey, error) {
+	query := `UPDATE api_keys SET last_used_at = now() WHERE key_hash = $1 AND revoked_at IS NULL RETURNING ` + apiKeyColumns
+	var k model.DBAPIKey
+	if err := scanAPIKey(p.db.QueryRowContext(ctx, query, keyHash), &k); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrAPIKeyNotFound // 404
+		default:
+			return nil, err
+		}
+	}
+	return &k, nil
+}
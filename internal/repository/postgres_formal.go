@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 	"time"
 
+	"github.com/UnendingLoop/CommentTree/internal/ratelimit"
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/postgres"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
@@ -21,6 +22,19 @@ func NewPostgresRepo(dbconn *dbpg.DB) CommentRepository {
 	return &PostgresRepo{db: dbconn}
 }
 
+func NewUserRepo(dbconn *dbpg.DB) UserRepository {
+	return &PostgresRepo{db: dbconn}
+}
+
+func NewAPIKeyRepo(dbconn *dbpg.DB) APIKeyRepository {
+	return &PostgresRepo{db: dbconn}
+}
+
+// NewRateLimitRepo - хранилище лимитов, общее для всех экземпляров; ttl - самый длинный период среди правил
+func NewRateLimitRepo(dbconn *dbpg.DB, ttl time.Duration) ratelimit.Store {
+	return &RateLimitRepo{db: dbconn, ttl: ttl}
+}
+
 func ConnectWithRetries(appConfig *config.Config, retryCount int, idleTime time.Duration) *dbpg.DB {
 	dbOptions := dbpg.Options{
 		MaxOpenConns:    5,
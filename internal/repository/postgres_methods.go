@@ -8,25 +8,26 @@ import (
 	"time"
 
 	"github.com/UnendingLoop/CommentTree/internal/model"
+	"github.com/lib/pq"
 )
 
 func (p PostgresRepo) Create(ctx context.Context, n *model.CommentCreateData) (*model.DBComment, error) {
-	query := `INSERT INTO comments (cid, pid, content, created_at, author)
-	VALUES (DEFAULT, $1, $2, DEFAULT, $3) 
-	RETURNING cid, pid, content, created_at, author`
+	query := `INSERT INTO comments AS c (cid, pid, content, created_at, author, thread_key, author_id, edit_token_hash, status, moderation_reason, spam_score)
+	VALUES (DEFAULT, $1, $2, DEFAULT, $3, $4, $5, NULLIF($6, ''), COALESCE(NULLIF($7, ''), 'approved'), NULLIF($8, ''), $9) 
+	RETURNING ` + commentColumns
 	res := model.DBComment{}
-	if err := p.db.QueryRowContext(ctx, query, n.ParentID, n.Text, n.Author).Scan(&res.ID, &res.ParentID, &res.Text, &res.CreatedAt, &res.Author); err != nil {
+	row := p.db.QueryRowContext(ctx, query, n.ParentID, n.Text, n.Author, n.ThreadKey, n.AuthorID, n.EditHash, n.Status, n.ModReason, n.SpamScore)
+	if err := scanComment(row, &res); err != nil {
 		return nil, err
 	}
 	return &res, nil
 }
 
 func (p PostgresRepo) GetCommentByID(ctx context.Context, id int) (*model.DBComment, error) {
-	query := `SELECT pid, content, created_at, deleted_at, author FROM comments WHERE cid = $1`
+	query := `SELECT ` + commentColumns + ` FROM comments c WHERE c.cid = $1`
 	var comment model.DBComment
-	comment.ID = id
 
-	err := p.db.QueryRowContext(ctx, query, id).Scan(&comment.ParentID, &comment.Text, &comment.CreatedAt, &comment.DeletedAt, &comment.Author)
+	err := scanComment(p.db.QueryRowContext(ctx, query, id), &comment)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -39,79 +40,218 @@ func (p PostgresRepo) GetCommentByID(ctx context.Context, id int) (*model.DBComm
 	return &comment, nil
 }
 
-func (p PostgresRepo) GetAllRoot(ctx context.Context, limit, offset int, sort, order string) ([]model.DBComment, error) {
-	query := fmt.Sprintf(`SELECT cid, pid, content, created_at, deleted_at, author 
-	FROM comments
-	ORDER BY %s %s 
-	LIMIT $1 
-	OFFSET $2`, sort, order)
+func (p PostgresRepo) GetAllRoot(ctx context.Context, q *model.PageQuery) ([]model.DBComment, error) {
+	return p.listComments(ctx, nil, q)
+}
 
-	rows, err := p.db.QueryContext(ctx, query, limit, offset)
-	if err != nil {
-		return nil, err
+func (p PostgresRepo) GetChildren(ctx context.Context, parentID int, q *model.PageQuery) ([]model.DBComment, error) {
+	return p.listComments(ctx, &parentID, q)
+}
+
+func (p PostgresRepo) CountRoot(ctx context.Context, thread string, viewer int) (int, error) {
+	// закреплённые идут поверх пагинации и в общее количество страниц не входят
+	query := `SELECT count(*) FROM comments c WHERE c.pid IS NULL AND c.thread_key = $1 AND c.pinned_at IS NULL AND ` + visibleTo("c", 2)
+	var total int
+	if err := p.db.QueryRowContext(ctx, query, thread, viewer).Scan(&total); err != nil {
+		return 0, err
 	}
+	return total, nil
+}
 
-	defer rows.Close()
+func (p PostgresRepo) CountChildren(ctx context.Context, parentID, viewer int) (int, error) {
+	query := `SELECT count(*) FROM comments c WHERE c.pid = $1 AND ` + visibleTo("c", 2)
+	var total int
+	if err := p.db.QueryRowContext(ctx, query, parentID, viewer).Scan(&total); err != nil {
+		return 0, err
+	}
+	return total, nil
+}
 
-	comments := make([]model.DBComment, 0, limit)
-	for rows.Next() {
-		var c model.DBComment
-		if err := rows.Scan(&c.ID, &c.ParentID, &c.Text, &c.CreatedAt, &c.DeletedAt, &c.Author); err != nil {
-			return nil, err
+// sortKey описывает SQL-выражение ключа сортировки и тип для приведения значения курсора обратно из текста
+type sortKey struct {
+	expr string
+	cast string
+}
+
+var sortKeys = map[string]sortKey{
+	"created_at": {expr: "c.created_at", cast: "timestamptz"},
+	"author":     {expr: "COALESCE(c.author, '')", cast: "text"},
+	"content":    {expr: "c.content", cast: "text"},
+	"top":        {expr: "(c.upvotes - c.downvotes)", cast: "int"},
+	// спорность: много голосов примерно поровну за и против
+	"controversial": {expr: `(CASE WHEN c.upvotes = 0 OR c.downvotes = 0 THEN 0
+		ELSE power(c.upvotes + c.downvotes, LEAST(c.upvotes, c.downvotes)::float8 / GREATEST(c.upvotes, c.downvotes))
+		END)::float8`, cast: "float8"},
+	// hot: логарифм рейтинга плюс свежесть, каждые 12.5 часов весят как десятикратный рейтинг
+	"hot": {expr: `(sign(c.upvotes - c.downvotes) * log(GREATEST(abs(c.upvotes - c.downvotes), 1)::float8)
+		+ (extract(epoch FROM c.created_at)::float8 - 1134028003) / 45000)::float8`, cast: "float8"},
+}
+
+// listComments - постраничная выборка корней потока q.Thread (parentID == nil) или прямых детей указанного комментария:
+// при наличии курсора используется keyset по (ключ сортировки, cid), иначе LIMIT/OFFSET
+func (p PostgresRepo) listComments(ctx context.Context, parentID *int, q *model.PageQuery) ([]model.DBComment, error) {
+	key, ok := sortKeys[q.Sort]
+	if !ok {
+		return nil, fmt.Errorf("unsupported sort key %q", q.Sort)
+	}
+	if q.Order != "ASC" && q.Order != "DESC" {
+		return nil, fmt.Errorf("unsupported sort order %q", q.Order)
+	}
+
+	args := make([]any, 0, 6)
+	args = append(args, q.Viewer) // $1 - автор для условий видимости, общий для всех подзапросов
+	var where, descendants string
+	switch parentID {
+	case nil: // корни выбираются в пределах потока, закреплённые отдаются отдельно через GetPinnedRoots
+		args = append(args, q.Thread)
+		where = fmt.Sprintf("c.pid IS NULL AND c.thread_key = $%d AND c.pinned_at IS NULL", len(args))
+		descendants = "0" // для корней не считаем - превью и так несёт reply_count
+	default:
+		args = append(args, *parentID)
+		where = fmt.Sprintf("c.pid = $%d", len(args))
+		descendants = `(WITH RECURSIVE sub AS (
+        SELECT s.cid FROM comments s WHERE s.pid = c.cid AND ` + visibleTo("s", 1) + `
+        UNION ALL
+        SELECT d.cid FROM comments d JOIN sub ON d.pid = sub.cid WHERE ` + visibleTo("d", 1) + `
+    ) SELECT count(*) FROM sub)`
+	}
+	where += " AND " + visibleTo("c", 1)
+
+	offset := q.Offset
+	if q.After != nil {
+		cmp := ">"
+		if q.Order == "DESC" {
+			cmp = "<"
 		}
-		comments = append(comments, c)
+		args = append(args, q.After.Value, q.After.ID)
+		where += fmt.Sprintf(" AND (%s, c.cid) %s ($%d::%s, $%d)", key.expr, cmp, len(args)-1, key.cast, len(args))
+		offset = 0
 	}
 
-	if rows.Err() != nil {
-		return nil, rows.Err()
+	args = append(args, q.Limit, offset)
+	query := fmt.Sprintf(`SELECT %s,
+	(SELECT count(*) FROM comments ch WHERE ch.pid = c.cid AND %s) AS reply_count,
+	%s AS descendant_count,
+	(%s)::text AS sort_key
+	FROM comments c
+	WHERE %s
+	ORDER BY %s %s, c.cid %s
+	LIMIT $%d
+	OFFSET $%d`, commentColumns, visibleTo("ch", 1), descendants, key.expr, where, key.expr, q.Order, q.Order, len(args)-1, len(args))
+
+	rows, err := p.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
 	}
 
-	return comments, nil
+	return collectComments(rows, func(c *model.DBComment) []any {
+		return []any{&c.ReplyCount, &c.DescendantCount, &c.SortKey}
+	})
 }
 
-func (p PostgresRepo) GetCommentWithChildrenByID(ctx context.Context, id int) ([]model.DBComment, error) {
+func (p PostgresRepo) GetRepliesPreview(ctx context.Context, rootIDs []int, perParent, depth, viewer int) ([]model.DBComment, error) {
+	// на каждом уровне берём только первые perParent видимых ответов каждого узла
+	query := `WITH RECURSIVE preview AS (
+    SELECT r.*, 1 AS depth
+    FROM unnest($1::int[]) AS root(id)
+    CROSS JOIN LATERAL (
+        SELECT v.*
+        FROM comments v
+        WHERE v.pid = root.id AND ` + visibleTo("v", 4) + `
+        ORDER BY v.created_at ASC, v.cid ASC
+        LIMIT $2
+    ) r
+
+    UNION ALL
+
+    SELECT r.*, p.depth + 1
+    FROM preview p
+    CROSS JOIN LATERAL (
+        SELECT v.*
+        FROM comments v
+        WHERE v.pid = p.cid AND ` + visibleTo("v", 4) + `
+        ORDER BY v.created_at ASC, v.cid ASC
+        LIMIT $2
+    ) r
+    WHERE p.depth < $3
+	)
+
+	SELECT ` + commentColumns + `,
+	(SELECT count(*) FROM comments ch WHERE ch.pid = c.cid AND ` + visibleTo("ch", 4) + `) AS reply_count
+	FROM preview c`
+
+	rows, err := p.db.QueryContext(ctx, query, pq.Array(rootIDs), perParent, depth, viewer)
+	if err != nil {
+		return nil, err
+	}
+
+	return collectComments(rows, withReplyCount)
+}
+
+func (p PostgresRepo) GetCommentWithChildrenByID(ctx context.Context, id, depth, viewer int) ([]model.DBComment, error) {
+	// обход останавливается на depth уровнях (0 - без ограничения); узлам на срезе считаем количество ответов,
+	// чтобы клиент мог показать ссылку "продолжить ветку". Видимость самого комментария проверяет сервис:
+	// гость видит свой непроверенный комментарий по секрету правки, которого в запросе нет
 	query := `WITH RECURSIVE comment_tree AS (
-    SELECT *
-    FROM comments
-    WHERE cid = $1
+    SELECT c.*, 0 AS level
+    FROM comments c
+    WHERE c.cid = $1
 
     UNION ALL
 
-    SELECT c.*
+    SELECT c.*, ct.level + 1
     FROM comments c
     JOIN comment_tree ct ON c.pid = ct.cid
-    WHERE c.deleted_at IS NULL
+    WHERE ($2 = 0 OR ct.level < $2) AND ` + visibleTo("c", 3) + `
 	)
 
-	SELECT cid, pid, content, created_at, deleted_at, author 
-	FROM comment_tree
-	ORDER BY pid ASC, created_at DESC`
+	SELECT ` + commentColumns + `,
+	CASE WHEN $2 > 0 AND c.level = $2
+		THEN (SELECT count(*) FROM comments ch WHERE ch.pid = c.cid AND ` + visibleTo("ch", 3) + `)
+		ELSE 0
+	END AS reply_count
+	FROM comment_tree c`
 
-	rows, err := p.db.QueryContext(ctx, query, id)
+	rows, err := p.db.QueryContext(ctx, query, id, depth, viewer)
 	if err != nil {
 		return nil, err
 	}
 
-	defer rows.Close()
+	return collectComments(rows, withReplyCount)
+}
 
-	comments := make([]model.DBComment, 0)
-	for rows.Next() {
-		var c model.DBComment
-		if err := rows.Scan(&c.ID, &c.ParentID, &c.Text, &c.CreatedAt, &c.DeletedAt, &c.Author); err != nil {
-			return nil, err
-		}
-		comments = append(comments, c)
-	}
+func (p PostgresRepo) GetAncestorsByID(ctx context.Context, id, limit, viewer int) ([]model.DBComment, error) {
+	// поднимаемся от родителя к корню не более чем на limit уровней (0 - до корня), отдаём начиная с самого верхнего;
+	// на невидимом зрителю предке цепочка обрывается
+	query := `WITH RECURSIVE ancestors AS (
+    SELECT p.*, 1 AS distance
+    FROM comments c
+    JOIN comments p ON p.cid = c.pid
+    WHERE c.cid = $1 AND ` + visibleTo("p", 3) + `
 
-	if rows.Err() != nil {
-		return nil, rows.Err()
+    UNION ALL
+
+    SELECT c.*, a.distance + 1
+    FROM comments c
+    JOIN ancestors a ON c.cid = a.pid
+    WHERE ($2 = 0 OR a.distance < $2) AND ` + visibleTo("c", 3) + `
+	)
+
+	SELECT ` + commentColumns + `,
+	(SELECT count(*) FROM comments ch WHERE ch.pid = c.cid AND ` + visibleTo("ch", 3) + `) AS reply_count
+	FROM ancestors c
+	ORDER BY c.distance DESC`
+
+	rows, err := p.db.QueryContext(ctx, query, id, limit, viewer)
+	if err != nil {
+		return nil, err
 	}
 
-	return comments, nil
+	return collectComments(rows, withReplyCount)
 }
 
-func (p PostgresRepo) DeleteByID(ctx context.Context, id int) error {
-	query := `WITH RECURSIVE comment_tree AS (
+// deleteSubtreeQuery удаляет комментарий $1 навсегда вместе с ветвью
+const deleteSubtreeQuery = `WITH RECURSIVE comment_tree AS (
     SELECT *
     FROM comments
     WHERE cid = $1
@@ -129,7 +269,8 @@ func (p PostgresRepo) DeleteByID(ctx context.Context, id int) error {
     SELECT cid FROM comment_tree
 	)`
 
-	row := p.db.QueryRowContext(ctx, query, id)
+func (p PostgresRepo) DeleteByID(ctx context.Context, id int) error {
+	row := p.db.QueryRowContext(ctx, deleteSubtreeQuery, id)
 	if row.Err() != nil {
 		switch {
 		case errors.Is(row.Err(), sql.ErrNoRows):
@@ -157,33 +298,572 @@ func (p PostgresRepo) MarkAsDeletedByID(ctx context.Context, id int) error {
 	return nil
 }
 
-func (p PostgresRepo) RunSearchQuery(ctx context.Context, q string) ([]model.DBComment, error) {
-	query := `SELECT cid, pid, content, created_at, author,
-	ts_rank(content_tsv, websearch_to_tsquery('simple', $1)) AS rank
-	FROM comments
-	WHERE deleted_at IS NULL
-	AND content_tsv @@ websearch_to_tsquery('russian', $1)
-	ORDER BY rank DESC, created_at DESC;`
-	rows, err := p.db.QueryContext(ctx, query, q)
+// RestoreByID снимает отметку мягкого удаления; если коммент уже не удалён, возвращается ErrCommentNotFound
+func (p PostgresRepo) RestoreByID(ctx context.Context, id int, restoredBy string) (*model.DBComment, error) {
+	query := `UPDATE comments AS c
+	SET deleted_at = NULL, restored_at = $1, restored_by = NULLIF($2, '')
+	WHERE c.cid = $3 AND c.deleted_at IS NOT NULL
+	RETURNING ` + commentColumns
+
+	var res model.DBComment
+	if err := scanComment(p.db.QueryRowContext(ctx, query, time.Now().UTC(), restoredBy, id), &res); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrCommentNotFound // 404
+		default:
+			return nil, err
+		}
+	}
+	return &res, nil
+}
+
+// moveLockKey - ключ advisory-блокировки: перемещения выполняются строго по одному,
+// иначе два встречных переноса могли бы вместе замкнуть цикл, не заметив друг друга
+const moveLockKey = 0x636f6d6d // "comm"
+
+// MoveSubtree переподвешивает комментарий вместе со всеми потомками под parentID (nil - в корни);
+// существование цели, её удаление и попадание внутрь переносимой ветки проверяются в той же транзакции
+func (p PostgresRepo) MoveSubtree(ctx context.Context, id int, parentID *int) (*model.DBComment, error) {
+	tx, err := p.db.Master.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }() // после Commit откат ничего не делает
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, moveLockKey); err != nil {
+		return nil, err
+	}
+
+	var exists bool
+	if err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM comments WHERE cid = $1)`, id).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrCommentNotFound // 404
+	}
+
+	if parentID != nil {
+		var deletedAt, hiddenAt *time.Time
+		var status string
+		var sameThread bool
+		query := `SELECT t.deleted_at, t.hidden_at, t.status, t.thread_key = m.thread_key
+		FROM comments t, comments m
+		WHERE t.cid = $1 AND m.cid = $2
+		FOR SHARE OF t`
+		if err := tx.QueryRowContext(ctx, query, *parentID, id).Scan(&deletedAt, &hiddenAt, &status, &sameThread); err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return nil, ErrMoveTargetNotFound
+			default:
+				return nil, err
+			}
+		}
+		// те же правила, что для родителя нового ответа: непроверенная цель для выдачи не существует,
+		// под мягко удалённую или скрытую по жалобам ветку переносить нельзя
+		if status != model.StatusApproved {
+			return nil, ErrMoveTargetNotFound
+		}
+		if deletedAt != nil || hiddenAt != nil {
+			return nil, ErrMoveTargetDeleted
+		}
+		if !sameThread { // ответы не покидают поток своего корня
+			return nil, ErrMoveAcrossThreads
+		}
+
+		// поднимаемся от цели к корню: если по пути встретился переносимый коммент, цель - его потомок
+		query = `WITH RECURSIVE up AS (
+	    SELECT cid, pid FROM comments WHERE cid = $1
+
+	    UNION ALL
+
+	    SELECT c.cid, c.pid
+	    FROM comments c
+	    JOIN up ON c.cid = up.pid
+		)
+
+		SELECT EXISTS (SELECT 1 FROM up WHERE cid = $2)`
+		var cycle bool
+		if err := tx.QueryRowContext(ctx, query, *parentID, id).Scan(&cycle); err != nil {
+			return nil, err
+		}
+		if cycle {
+			return nil, ErrMoveIntoSubtree
+		}
+	}
+
+	// закрепление имеет смысл только для корней: уходя под родителя, коммент открепляется
+	query := `UPDATE comments AS c
+	SET pid = $1, pinned_at = CASE WHEN $1::int IS NULL THEN c.pinned_at END
+	WHERE c.cid = $2
+	RETURNING ` + commentColumns
+	var res model.DBComment
+	if err := scanComment(tx.QueryRowContext(ctx, query, parentID, id), &res); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// Vote ставит, меняет или отзывает (value == 0) голос voter и пересчитывает счётчики комментария в той же транзакции
+func (p PostgresRepo) Vote(ctx context.Context, id int, voter string, value int) (*model.DBComment, error) {
+	tx, err := p.db.Master.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }() // после Commit откат ничего не делает
+
+	var exists bool
+	if err := tx.QueryRowContext(ctx, `SELECT true FROM comments WHERE cid = $1 FOR UPDATE`, id).Scan(&exists); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrCommentNotFound // 404
+		default:
+			return nil, err
+		}
+	}
+
+	prev := 0
+	query := `SELECT value FROM comment_votes WHERE cid = $1 AND voter = $2`
+	if err := tx.QueryRowContext(ctx, query, id, voter).Scan(&prev); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	switch value {
+	case 0:
+		query = `DELETE FROM comment_votes WHERE cid = $1 AND voter = $2`
+		_, err = tx.ExecContext(ctx, query, id, voter)
+	default:
+		query = `INSERT INTO comment_votes (cid, voter, value) VALUES ($1, $2, $3)
+		ON CONFLICT (cid, voter) DO UPDATE SET value = EXCLUDED.value, created_at = now()`
+		_, err = tx.ExecContext(ctx, query, id, voter, value)
+	}
 	if err != nil {
 		return nil, err
 	}
 
+	// снимаем прежний голос и учитываем новый
+	prevUp, prevDown := splitVote(prev)
+	newUp, newDown := splitVote(value)
+	up, down := newUp-prevUp, newDown-prevDown
+
+	query = `UPDATE comments AS c
+	SET upvotes = c.upvotes + $1, downvotes = c.downvotes + $2
+	WHERE c.cid = $3
+	RETURNING ` + commentColumns
+	var res model.DBComment
+	if err := scanComment(tx.QueryRowContext(ctx, query, up, down, id), &res); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// SetReaction ставит (on) или снимает реакцию клиента; повторная постановка ничего не меняет
+func (p PostgresRepo) SetReaction(ctx context.Context, id int, emoji, reactor string, on bool) error {
+	query := `DELETE FROM comment_reactions WHERE cid = $1 AND emoji = $2 AND reactor = $3`
+	if on {
+		query = `INSERT INTO comment_reactions (cid, emoji, reactor) VALUES ($1, $2, $3)
+		ON CONFLICT (cid, emoji, reactor) DO NOTHING`
+	}
+
+	if _, err := p.db.ExecContext(ctx, query, id, emoji, reactor); err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23503" { // коммент удалили между проверкой и вставкой
+			return ErrCommentNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// GetReactions агрегирует реакции сразу для всех комментариев выдачи одним запросом
+func (p PostgresRepo) GetReactions(ctx context.Context, ids []int, reactor string) ([]model.DBReaction, error) {
+	query := `SELECT cid, emoji, count(*), COALESCE(bool_or(reactor = $2), false)
+	FROM comment_reactions
+	WHERE cid = ANY($1)
+	GROUP BY cid, emoji`
+
+	rows, err := p.db.QueryContext(ctx, query, pq.Array(ids), reactor)
+	if err != nil {
+		return nil, err
+	}
 	defer rows.Close()
 
-	comments := make([]model.DBComment, 0)
+	var res []model.DBReaction
 	for rows.Next() {
-		var c model.DBComment
-		rank := 0.99
-		if err := rows.Scan(&c.ID, &c.ParentID, &c.Text, &c.CreatedAt, &c.Author, &rank); err != nil {
+		var r model.DBReaction
+		if err := rows.Scan(&r.CommentID, &r.Emoji, &r.Count, &r.Mine); err != nil {
 			return nil, err
 		}
-		comments = append(comments, c)
+		res = append(res, r)
+	}
+	return res, rows.Err()
+}
+
+// splitVote раскладывает голос на приращения счётчиков за/против
+func splitVote(value int) (up, down int) {
+	switch value {
+	case 1:
+		return 1, 0
+	case -1:
+		return 0, 1
+	default:
+		return 0, 0
+	}
+}
+
+func (p PostgresRepo) SetPinned(ctx context.Context, id int, pinned bool) (*model.DBComment, error) {
+	query := `UPDATE comments AS c
+	SET pinned_at = CASE WHEN $1 THEN COALESCE(c.pinned_at, $2) END
+	WHERE c.cid = $3
+	RETURNING ` + commentColumns
+
+	var res model.DBComment
+	if err := scanComment(p.db.QueryRowContext(ctx, query, pinned, time.Now().UTC(), id), &res); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrCommentNotFound // 404
+		default:
+			return nil, err
+		}
+	}
+	return &res, nil
+}
+
+// GetPinnedRoots - закреплённые корни потока, последние закреплённые выше
+func (p PostgresRepo) GetPinnedRoots(ctx context.Context, thread string, viewer int) ([]model.DBComment, error) {
+	query := `SELECT ` + commentColumns + `,
+	(SELECT count(*) FROM comments ch WHERE ch.pid = c.cid AND ` + visibleTo("ch", 2) + `) AS reply_count
+	FROM comments c
+	WHERE c.pid IS NULL AND c.thread_key = $1 AND c.pinned_at IS NOT NULL AND ` + visibleTo("c", 2) + `
+	ORDER BY c.pinned_at DESC, c.cid DESC`
+
+	rows, err := p.db.QueryContext(ctx, query, thread, viewer)
+	if err != nil {
+		return nil, err
+	}
+
+	return collectComments(rows, withReplyCount)
+}
+
+func (p PostgresRepo) SetCommentLock(ctx context.Context, id int, locked bool) (*model.DBComment, error) {
+	query := `UPDATE comments AS c
+	SET locked_at = CASE WHEN $1 THEN COALESCE(c.locked_at, $2) END
+	WHERE c.cid = $3
+	RETURNING ` + commentColumns
+
+	var res model.DBComment
+	if err := scanComment(p.db.QueryRowContext(ctx, query, locked, time.Now().UTC(), id), &res); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrCommentNotFound // 404
+		default:
+			return nil, err
+		}
+	}
+	return &res, nil
+}
+
+func (p PostgresRepo) SetThreadLock(ctx context.Context, key string, locked bool) (*model.DBThread, error) {
+	query := `INSERT INTO threads AS t (thread_key, locked_at)
+	VALUES ($1, CASE WHEN $2 THEN $3::timestamptz END)
+	ON CONFLICT (thread_key) DO UPDATE
+	SET locked_at = CASE WHEN $2 THEN COALESCE(t.locked_at, $3::timestamptz) END
+	RETURNING thread_key, locked_at, premoderated`
+
+	var res model.DBThread
+	if err := p.db.QueryRowContext(ctx, query, key, locked, time.Now().UTC()).Scan(&res.Key, &res.LockedAt, &res.Premoderated); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+func (p PostgresRepo) SetThreadPremoderation(ctx context.Context, key string, on bool) (*model.DBThread, error) {
+	query := `INSERT INTO threads AS t (thread_key, premoderated) VALUES ($1, $2)
+	ON CONFLICT (thread_key) DO UPDATE SET premoderated = $2
+	RETURNING thread_key, locked_at, premoderated`
+
+	var res model.DBThread
+	if err := p.db.QueryRowContext(ctx, query, key, on).Scan(&res.Key, &res.LockedAt, &res.Premoderated); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+func (p PostgresRepo) IsThreadPremoderated(ctx context.Context, key string) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM threads WHERE thread_key = $1 AND premoderated)`
+	var on bool
+	if err := p.db.QueryRowContext(ctx, query, key).Scan(&on); err != nil {
+		return false, err
+	}
+	return on, nil
+}
+
+// GetModerationQueue - ожидающие решения комментарии потока (пустой thread - всех потоков), от старых к новым
+func (p PostgresRepo) GetModerationQueue(ctx context.Context, thread string, limit, offset int) ([]model.DBComment, error) {
+	query := `SELECT ` + commentColumns + `
+	FROM comments c
+	WHERE c.status = 'pending' AND c.deleted_at IS NULL AND ($1 = '' OR c.thread_key = $1)
+	ORDER BY c.created_at ASC, c.cid ASC
+	LIMIT $2
+	OFFSET $3`
+
+	rows, err := p.db.QueryContext(ctx, query, thread, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return collectComments(rows, nil)
+}
+
+// SetModeration записывает решение модератора: статус, причину, кто и когда решил
+func (p PostgresRepo) SetModeration(ctx context.Context, id int, status, reason, by string) (*model.DBComment, error) {
+	query := `UPDATE comments AS c
+	SET status = $1, moderation_reason = NULLIF($2, ''), moderated_by = NULLIF($3, ''), moderated_at = $4
+	WHERE c.cid = $5
+	RETURNING ` + commentColumns
+
+	var res model.DBComment
+	if err := scanComment(p.db.QueryRowContext(ctx, query, status, reason, by, time.Now().UTC(), id), &res); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrCommentNotFound // 404
+		default:
+			return nil, err
+		}
+	}
+	return &res, nil
+}
+
+// IsBranchLocked - закрыты ли ответы под комментарием: заблокирован он сам, кто-то из предков или весь поток
+func (p PostgresRepo) IsBranchLocked(ctx context.Context, id int) (bool, error) {
+	query := `WITH RECURSIVE up AS (
+    SELECT cid, pid, locked_at, thread_key FROM comments WHERE cid = $1
+
+    UNION ALL
+
+    SELECT c.cid, c.pid, c.locked_at, c.thread_key
+    FROM comments c
+    JOIN up ON c.cid = up.pid
+	)
+
+	SELECT EXISTS (SELECT 1 FROM up WHERE locked_at IS NOT NULL)
+	OR EXISTS (
+		SELECT 1 FROM threads t
+		JOIN up ON up.pid IS NULL AND t.thread_key = up.thread_key
+		WHERE t.locked_at IS NOT NULL
+	)`
+
+	var locked bool
+	if err := p.db.QueryRowContext(ctx, query, id).Scan(&locked); err != nil {
+		return false, err
+	}
+	return locked, nil
+}
+
+func (p PostgresRepo) IsThreadLocked(ctx context.Context, key string) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM threads WHERE thread_key = $1 AND locked_at IS NOT NULL)`
+	var locked bool
+	if err := p.db.QueryRowContext(ctx, query, key).Scan(&locked); err != nil {
+		return false, err
+	}
+	return locked, nil
+}
+
+func (p PostgresRepo) RunSearchQuery(ctx context.Context, q string, viewer int) ([]model.DBComment, error) {
+	query := `SELECT ` + commentColumns + `,
+	ts_rank(c.content_tsv, websearch_to_tsquery('simple', $1)) AS rank
+	FROM comments c
+	WHERE c.deleted_at IS NULL AND c.hidden_at IS NULL AND ` + visibleTo("c", 2) + `
+	AND c.content_tsv @@ websearch_to_tsquery('russian', $1)
+	ORDER BY rank DESC, c.created_at DESC;`
+	rows, err := p.db.QueryContext(ctx, query, q, viewer)
+	if err != nil {
+		return nil, err
+	}
+
+	return collectComments(rows, func(c *model.DBComment) []any {
+		return []any{new(float64)} // rank нужен только для сортировки
+	})
+}
+
+// UpdateText заменяет текст комментария, предварительно сохраняя текущую версию в comment_revisions;
+// строка блокируется на время транзакции, чтобы параллельные правки не потеряли версию.
+// diff - заранее посчитанный дифф prevText -> text, сохраняется при версии, только если в базе всё ещё prevText
+func (p PostgresRepo) UpdateText(ctx context.Context, id int, prevText, text string, diff []byte) (*model.DBComment, error) {
+	tx, err := p.db.Master.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }() // после Commit откат ничего не делает
+
+	var storedText string
+	var prevSince time.Time
+	query := `SELECT content, COALESCE(edited_at, created_at) FROM comments WHERE cid = $1 FOR UPDATE`
+	if err := tx.QueryRowContext(ctx, query, id).Scan(&storedText, &prevSince); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrCommentNotFound // 404
+		default:
+			return nil, err
+		}
+	}
+
+	// JSONB передаётся строкой: []byte драйвер отправил бы как bytea
+	var nextDiff sql.NullString
+	if storedText == prevText && diff != nil { // иначе текст поменяли параллельно и дифф посчитан не от той версии
+		nextDiff = sql.NullString{String: string(diff), Valid: true}
+	}
+
+	now := time.Now().UTC()
+	query = `INSERT INTO comment_revisions (cid, content, created_at, replaced_at, next_diff) VALUES ($1, $2, $3, $4, $5)`
+	if _, err := tx.ExecContext(ctx, query, id, storedText, prevSince, now, nextDiff); err != nil {
+		return nil, err
+	}
+
+	query = `UPDATE comments AS c
+	SET content = $1, edited_at = $2, edit_count = c.edit_count + 1
+	WHERE c.cid = $3
+	RETURNING ` + commentColumns
+	var res model.DBComment
+	if err := scanComment(tx.QueryRowContext(ctx, query, text, now, id), &res); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+func (p PostgresRepo) GetRevisions(ctx context.Context, id int) ([]model.DBRevision, error) {
+	query := `SELECT rid, cid, content, created_at, replaced_at, next_diff
+	FROM comment_revisions
+	WHERE cid = $1
+	ORDER BY rid ASC`
+	rows, err := p.db.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	revisions := make([]model.DBRevision, 0)
+	for rows.Next() {
+		var r model.DBRevision
+		if err := rows.Scan(&r.ID, &r.CommentID, &r.Text, &r.CreatedAt, &r.ReplacedAt, &r.NextDiff); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, r)
 	}
 
 	if rows.Err() != nil {
 		return nil, rows.Err()
 	}
 
-	return comments, nil
+	return revisions, nil
+}
+
+// PurgeDeleted окончательно удаляет комментарии, мягко удалённые раньше cutoff: сначала целиком мёртвые ветки
+// (в поддереве нет ни живых, ни недавно удалённых, ни скрытых по жалобам комментариев), затем схлопывает цепочки из просроченных
+// надгробий с единственным ребёнком, оставляя в каждой цепочке только верхнее
+func (p PostgresRepo) PurgeDeleted(ctx context.Context, cutoff time.Time) (int, int, error) {
+	tx, err := p.db.Master.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer func() { _ = tx.Rollback() }() // после Commit откат ничего не делает
+
+	// blocked - живые, недавно удалённые и ждущие модератора комментарии вместе со всеми предками, их трогать нельзя
+	query := `WITH RECURSIVE blocked AS (
+    SELECT cid, pid FROM comments WHERE deleted_at IS NULL OR deleted_at >= $1 OR hidden_at IS NOT NULL
+
+    UNION
+
+    SELECT c.cid, c.pid
+    FROM comments c
+    JOIN blocked b ON c.cid = b.pid
+	)
+
+	DELETE FROM comments c
+	WHERE c.deleted_at < $1
+	AND NOT EXISTS (SELECT 1 FROM blocked b WHERE b.cid = c.cid)`
+	res, err := tx.ExecContext(ctx, query, cutoff)
+	if err != nil {
+		return 0, 0, err
+	}
+	purged, err := res.RowsAffected()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// за один проход из каждой цепочки убирается второе сверху надгробие: его дети переходят к верхнему
+	query = `SELECT t.cid, t.pid
+	FROM comments t
+	JOIN comments p ON p.cid = t.pid
+	WHERE t.deleted_at < $1 AND t.hidden_at IS NULL AND p.deleted_at < $1 AND p.hidden_at IS NULL
+	AND NOT EXISTS (SELECT 1 FROM comments s WHERE s.pid = p.cid AND s.cid <> t.cid)
+	AND NOT EXISTS (
+		SELECT 1 FROM comments g
+		WHERE g.cid = p.pid AND g.deleted_at < $1 AND g.hidden_at IS NULL
+		AND NOT EXISTS (SELECT 1 FROM comments s WHERE s.pid = g.cid AND s.cid <> p.cid)
+	)`
+	collapsed := 0
+	for {
+		ids, parents, err := collectLinks(ctx, tx, query, cutoff)
+		if err != nil {
+			return 0, 0, err
+		}
+		if len(ids) == 0 {
+			break
+		}
+
+		reparent := `UPDATE comments c SET pid = v.pid
+		FROM unnest($1::int[], $2::int[]) AS v(cid, pid)
+		WHERE c.pid = v.cid`
+		if _, err := tx.ExecContext(ctx, reparent, pq.Array(ids), pq.Array(parents)); err != nil {
+			return 0, 0, err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM comments WHERE cid = ANY($1)`, pq.Array(ids)); err != nil {
+			return 0, 0, err
+		}
+		collapsed += len(ids)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, 0, err
+	}
+	return int(purged), collapsed, nil
+}
+
+// collectLinks вычитывает пары (cid, pid) из запроса внутри транзакции
+func collectLinks(ctx context.Context, tx *sql.Tx, query string, args ...any) ([]int, []int, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer rows.Close()
+
+	ids, parents := make([]int, 0), make([]int, 0)
+	for rows.Next() {
+		var id, parent int
+		if err := rows.Scan(&id, &parent); err != nil {
+			return nil, nil, err
+		}
+		ids = append(ids, id)
+		parents = append(parents, parent)
+	}
+
+	if rows.Err() != nil {
+		return nil, nil, rows.Err()
+	}
+
+	return ids, parents, nil
 }
@@ -0,0 +1,99 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/UnendingLoop/CommentTree/internal/ratelimit"
+	"github.com/lib/pq"
+	"github.com/wb-go/wbf/dbpg"
+)
+
+type RateLimitRepo struct {
+	db        *dbpg.DB
+	ttl       time.Duration // ведро, не тронутое дольше ttl, снова полное - строку можно удалить
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+// Take берёт жетоны в одной транзакции: строки вёдер блокируются, остаток считается ratelimit.Apply от того,
+// что лежит в строке, и записывается до снятия блокировки - параллельный запрос с другого экземпляра
+// не затрёт чужое списание. Строки блокируются в порядке ключей, чтобы запросы с пересекающимися вёдрами
+// не взаимоблокировались; время - now() базы, а не часы экземпляра
+func (p *RateLimitRepo) Take(ctx context.Context, buckets []ratelimit.Bucket) (time.Duration, error) {
+	p.sweep(ctx)
+
+	keys := make([]string, 0, len(buckets))
+	caps := make([]float64, 0, len(buckets))
+	for _, b := range buckets {
+		keys = append(keys, b.Key)
+		caps = append(caps, float64(b.Limit))
+	}
+
+	tx, err := p.db.Master.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }() // после Commit откат ничего не делает
+
+	// новое ведро появляется полным; если его одновременно создаёт другой запрос, вставка ничего не делает
+	query := `INSERT INTO rate_limits (key, tokens, updated_at)
+	SELECT v.key, v.cap, now() FROM unnest($1::text[], $2::float8[]) AS v(key, cap)
+	ORDER BY v.key
+	ON CONFLICT (key) DO NOTHING`
+	if _, err := tx.ExecContext(ctx, query, pq.Array(keys), pq.Array(caps)); err != nil {
+		return 0, err
+	}
+
+	query = `SELECT key, tokens, updated_at, now() FROM rate_limits WHERE key = ANY($1) ORDER BY key FOR UPDATE`
+	rows, err := tx.QueryContext(ctx, query, pq.Array(keys))
+	if err != nil {
+		return 0, err
+	}
+	states := make(map[string]ratelimit.State, len(buckets))
+	var now time.Time
+	for rows.Next() {
+		var key string
+		var s ratelimit.State
+		if err := rows.Scan(&key, &s.Tokens, &s.UpdatedAt, &now); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		states[key] = s
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return 0, rows.Err()
+	}
+
+	if retryAfter := ratelimit.Apply(buckets, states, now); retryAfter > 0 {
+		return retryAfter, nil // транзакция откатится - ни одно ведро не тронуто
+	}
+
+	tokens := make([]float64, 0, len(keys))
+	for _, key := range keys {
+		tokens = append(tokens, states[key].Tokens)
+	}
+	query = `UPDATE rate_limits r SET tokens = v.tokens, updated_at = $3
+	FROM unnest($1::text[], $2::float8[]) AS v(key, tokens)
+	WHERE r.key = v.key`
+	if _, err := tx.ExecContext(ctx, query, pq.Array(keys), pq.Array(tokens), now); err != nil {
+		return 0, err
+	}
+	return 0, tx.Commit()
+}
+
+// sweep не чаще раза в ttl удаляет давно полные вёдра; ошибка очистки на сам лимит не влияет
+func (p *RateLimitRepo) sweep(ctx context.Context) {
+	p.mu.Lock()
+	if time.Since(p.lastSweep) < p.ttl {
+		p.mu.Unlock()
+		return
+	}
+	p.lastSweep = time.Now()
+	p.mu.Unlock()
+
+	query := `DELETE FROM rate_limits WHERE updated_at < now() - make_interval(secs => $1)`
+	_, _ = p.db.ExecContext(ctx, query, p.ttl.Seconds())
+}
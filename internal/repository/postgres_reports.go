@@ -0,0 +1,139 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/UnendingLoop/CommentTree/internal/model"
+	"github.com/lib/pq"
+)
+
+// AddReport принимает жалобу и возвращает число открытых жалоб на комментарий;
+// повторная жалоба того же клиента до решения модератора ничего не меняет
+func (p PostgresRepo) AddReport(ctx context.Context, id int, reporter string, data *model.CommentReportData) (int, error) {
+	query := `INSERT INTO comment_reports (cid, reporter, reason, details) VALUES ($1, $2, $3, NULLIF($4, ''))
+	ON CONFLICT (cid, reporter) WHERE resolved_at IS NULL DO NOTHING`
+	if _, err := p.db.ExecContext(ctx, query, id, reporter, data.Reason, data.Details); err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23503" { // коммент удалили между проверкой и вставкой
+			return 0, ErrCommentNotFound
+		}
+		return 0, err
+	}
+
+	query = `SELECT count(*) FROM comment_reports WHERE cid = $1 AND resolved_at IS NULL`
+	var open int
+	if err := p.db.QueryRowContext(ctx, query, id).Scan(&open); err != nil {
+		return 0, err
+	}
+	return open, nil
+}
+
+// GetReportGroups - комментарии с открытыми жалобами, самые обжалованные первыми
+func (p PostgresRepo) GetReportGroups(ctx context.Context, limit, offset int) ([]model.DBReportGroup, error) {
+	query := `SELECT ` + commentColumns + `, r.cnt, r.reasons, r.details, r.first_at, r.last_at
+	FROM (
+		SELECT cid, count(*) AS cnt,
+		array_agg(reason ORDER BY created_at) AS reasons,
+		array_remove(array_agg(details ORDER BY created_at), NULL) AS details,
+		min(created_at) AS first_at, max(created_at) AS last_at
+		FROM comment_reports
+		WHERE resolved_at IS NULL
+		GROUP BY cid
+	) r
+	JOIN comments c ON c.cid = r.cid
+	ORDER BY r.cnt DESC, r.last_at DESC, c.cid
+	LIMIT $1
+	OFFSET $2`
+
+	rows, err := p.db.QueryContext(ctx, query, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []model.DBReportGroup{}
+	for rows.Next() {
+		var g model.DBReportGroup
+		if err := scanComment(rows, &g.Comment, &g.Count, pq.Array(&g.Reasons), pq.Array(&g.Details), &g.FirstAt, &g.LastAt); err != nil {
+			return nil, err
+		}
+		res = append(res, g)
+	}
+	return res, rows.Err()
+}
+
+// SetHidden скрывает комментарий до решения модератора или снимает скрытие; повторное скрытие время не меняет
+func (p PostgresRepo) SetHidden(ctx context.Context, id int, hidden bool) error {
+	query := `UPDATE comments SET hidden_at = CASE WHEN $2 THEN COALESCE(hidden_at, now()) END WHERE cid = $1`
+	res, err := p.db.ExecContext(ctx, query, id, hidden)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrCommentNotFound // 404
+	}
+	return nil
+}
+
+// ResolveReports закрывает все открытые жалобы на комментарий решением модератора и в той же транзакции
+// применяет его: dismiss снимает скрытие, hide скрывает, delete удаляет ветвь. Возвращает число закрытых жалоб.
+// dismiss без открытых жалоб снимает скрытие, оставшееся от прежнего hide; если снимать нечего - ErrNoOpenReports
+func (p PostgresRepo) ResolveReports(ctx context.Context, id int, resolution, by string) (int, error) {
+	tx, err := p.db.Master.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }() // после Commit откат ничего не делает
+
+	query := `UPDATE comment_reports SET resolved_at = now(), resolved_by = $2, resolution = $3
+	WHERE cid = $1 AND resolved_at IS NULL`
+	resolved, err := execCount(ctx, tx, query, id, by, resolution)
+	if err != nil {
+		return 0, err
+	}
+
+	switch resolution {
+	case model.ResolveDismiss:
+		unhidden, err := execCount(ctx, tx, `UPDATE comments SET hidden_at = NULL WHERE cid = $1 AND hidden_at IS NOT NULL`, id)
+		if err != nil {
+			return 0, err
+		}
+		if resolved == 0 && unhidden == 0 {
+			return 0, ErrNoOpenReports // 404
+		}
+	case model.ResolveHide:
+		if resolved == 0 {
+			return 0, ErrNoOpenReports // 404
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE comments SET hidden_at = COALESCE(hidden_at, now()) WHERE cid = $1`, id); err != nil {
+			return 0, err
+		}
+	case model.ResolveDelete:
+		if resolved == 0 {
+			return 0, ErrNoOpenReports // 404
+		}
+		if _, err := tx.ExecContext(ctx, deleteSubtreeQuery, id); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return resolved, nil
+}
+
+func execCount(ctx context.Context, tx *sql.Tx, query string, args ...any) (int, error) {
+	res, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
@@ -0,0 +1,64 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/UnendingLoop/CommentTree/internal/model"
+)
+
+// commentColumns - общий набор колонок комментария для всех выборок;
+// таблица или CTE, из которой читается комментарий, должна иметь алиас c
+const commentColumns = `c.cid, c.pid, c.content, c.created_at, c.deleted_at, COALESCE(c.author, ''), c.author_id,
+	c.edited_at, c.edit_count, c.restored_at, COALESCE(c.restored_by, ''), c.thread_key,
+	c.locked_at, c.pinned_at, c.upvotes, c.downvotes, COALESCE(c.edit_token_hash, ''),
+	c.status, COALESCE(c.moderation_reason, ''), COALESCE(c.moderated_by, ''), c.moderated_at, c.spam_score,
+	c.hidden_at`
+
+// visibleTo - условие публичной видимости комментария с алиасом alias: одобренные видны всем,
+// непроверенные и отклонённые - только автору, id которого передан параметром $arg (0 - никому)
+func visibleTo(alias string, arg int) string {
+	return fmt.Sprintf("(%[1]s.status = 'approved' OR %[1]s.author_id = $%[2]d)", alias, arg)
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanComment читает commentColumns и следующие за ними дополнительные колонки запроса
+func scanComment(row rowScanner, c *model.DBComment, extra ...any) error {
+	dest := []any{&c.ID, &c.ParentID, &c.Text, &c.CreatedAt, &c.DeletedAt, &c.Author, &c.AuthorID, &c.EditedAt, &c.EditCount,
+		&c.RestoredAt, &c.RestoredBy, &c.ThreadKey, &c.LockedAt, &c.PinnedAt,
+		&c.Upvotes, &c.Downvotes, &c.EditHash,
+		&c.Status, &c.ModReason, &c.ModBy, &c.ModAt, &c.SpamScore,
+		&c.HiddenAt}
+	return row.Scan(append(dest, extra...)...)
+}
+
+// collectComments вычитывает все строки выборки; extra возвращает указатели на дополнительные колонки запроса
+func collectComments(rows *sql.Rows, extra func(c *model.DBComment) []any) ([]model.DBComment, error) {
+	defer rows.Close()
+
+	comments := make([]model.DBComment, 0)
+	for rows.Next() {
+		var c model.DBComment
+		var dest []any
+		if extra != nil {
+			dest = extra(&c)
+		}
+		if err := scanComment(rows, &c, dest...); err != nil {
+			return nil, err
+		}
+		comments = append(comments, c)
+	}
+
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return comments, nil
+}
+
+func withReplyCount(c *model.DBComment) []any {
+	return []any{&c.ReplyCount}
+}
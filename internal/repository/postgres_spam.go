@@ -0,0 +1,120 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/UnendingLoop/CommentTree/internal/model"
+	"github.com/lib/pq"
+)
+
+// GetSpamStats - число обученных решений по классам и счётчики тех токенов, которые классификатор уже видел
+func (p PostgresRepo) GetSpamStats(ctx context.Context, tokens []string) (*model.DBSpamStats, error) {
+	res := model.DBSpamStats{Tokens: make(map[string]model.SpamTokenCount, len(tokens))}
+	query := `SELECT
+	COALESCE(sum(docs) FILTER (WHERE label = 'spam'), 0),
+	COALESCE(sum(docs) FILTER (WHERE label = 'ham'), 0)
+	FROM spam_classes`
+	if err := p.db.QueryRowContext(ctx, query).Scan(&res.SpamDocs, &res.HamDocs); err != nil {
+		return nil, err
+	}
+
+	query = `SELECT token, spam, ham FROM spam_tokens WHERE token = ANY($1)`
+	rows, err := p.db.QueryContext(ctx, query, pq.Array(tokens))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var token string
+		var cnt model.SpamTokenCount
+		if err := rows.Scan(&token, &cnt.Spam, &cnt.Ham); err != nil {
+			return nil, err
+		}
+		res.Tokens[token] = cnt
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+	return &res, nil
+}
+
+// TrainSpam учит классификатор на комментарии id с меткой label. Повторное решение с той же меткой ничего не меняет,
+// при смене метки (одобрили отклонённый) прежний вклад вычитается по токенам, сохранённым при прошлом обучении
+func (p PostgresRepo) TrainSpam(ctx context.Context, id int, label string, tokens []string) error {
+	tx, err := p.db.Master.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }() // после Commit откат ничего не делает
+
+	// строка обучения заводится заранее, чтобы параллельные решения по одному комментарию шли по очереди
+	query := `INSERT INTO spam_training (cid) VALUES ($1) ON CONFLICT (cid) DO NOTHING`
+	if _, err := tx.ExecContext(ctx, query, id); err != nil {
+		return err
+	}
+
+	var prevLabel sql.NullString
+	var prevTokens []string
+	query = `SELECT label, tokens FROM spam_training WHERE cid = $1 FOR UPDATE`
+	if err := tx.QueryRowContext(ctx, query, id).Scan(&prevLabel, pq.Array(&prevTokens)); err != nil {
+		return err
+	}
+	if prevLabel.String == label {
+		return tx.Commit()
+	}
+
+	if prevLabel.Valid {
+		if err := countSpamTokens(ctx, tx, prevLabel.String, prevTokens, -1); err != nil {
+			return err
+		}
+	}
+	if err := countSpamTokens(ctx, tx, label, tokens, 1); err != nil {
+		return err
+	}
+
+	query = `UPDATE spam_training SET label = $1, tokens = $2, trained_at = now() WHERE cid = $3`
+	if _, err := tx.ExecContext(ctx, query, label, pq.Array(tokens), id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// countSpamTokens добавляет (delta = 1) или вычитает (delta = -1) один комментарий класса label
+func countSpamTokens(ctx context.Context, tx *sql.Tx, label string, tokens []string, delta int) error {
+	spam, ham := 0, 0
+	if label == model.SpamLabel {
+		spam = delta
+	} else {
+		ham = delta
+	}
+
+	query := `INSERT INTO spam_tokens AS t (token, spam, ham)
+	SELECT token, GREATEST($2, 0), GREATEST($3, 0) FROM unnest($1::text[]) AS token
+	ON CONFLICT (token) DO UPDATE
+	SET spam = GREATEST(t.spam + $2, 0), ham = GREATEST(t.ham + $3, 0)`
+	if _, err := tx.ExecContext(ctx, query, pq.Array(tokens), spam, ham); err != nil {
+		return err
+	}
+
+	query = `UPDATE spam_classes SET docs = GREATEST(docs + $1, 0) WHERE label = $2`
+	_, err := tx.ExecContext(ctx, query, delta, label)
+	return err
+}
+
+func (p PostgresRepo) SetSpamScore(ctx context.Context, id int, score float64) error {
+	query := `UPDATE comments SET spam_score = $1 WHERE cid = $2`
+	res, err := p.db.ExecContext(ctx, query, score, id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrCommentNotFound // 404
+	}
+	return nil
+}
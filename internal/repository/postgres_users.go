@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/UnendingLoop/CommentTree/internal/model"
+	"github.com/lib/pq"
+)
+
+const userColumns = `id, username, password_hash, role, created_at`
+
+func scanUser(row rowScanner, u *model.DBUser) error {
+	return row.Scan(&u.ID, &u.Username, &u.PasswordHash, &u.Role, &u.CreatedAt)
+}
+
+func (p PostgresRepo) CreateUser(ctx context.Context, username, passwordHash, role string) (*model.DBUser, error) {
+	query := `INSERT INTO users (username, password_hash, role) VALUES ($1, $2, $3)
+	RETURNING ` + userColumns
+	var u model.DBUser
+	if err := scanUser(p.db.QueryRowContext(ctx, query, username, passwordHash, role), &u); err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" { // unique_violation по username
+			return nil, ErrUserExists // 409
+		}
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (p PostgresRepo) GetUserByName(ctx context.Context, username string) (*model.DBUser, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE username = $1`
+	var u model.DBUser
+	if err := scanUser(p.db.QueryRowContext(ctx, query, username), &u); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrUserNotFound // 404
+		default:
+			return nil, err
+		}
+	}
+	return &u, nil
+}
+
+func (p PostgresRepo) GetUserByID(ctx context.Context, id int) (*model.DBUser, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = $1`
+	var u model.DBUser
+	if err := scanUser(p.db.QueryRowContext(ctx, query, id), &u); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrUserNotFound // 404
+		default:
+			return nil, err
+		}
+	}
+	return &u, nil
+}
+
+func (p PostgresRepo) SetUserRole(ctx context.Context, username, role string) (*model.DBUser, error) {
+	query := `UPDATE users SET role = $2 WHERE username = $1 RETURNING ` + userColumns
+	var u model.DBUser
+	if err := scanUser(p.db.QueryRowContext(ctx, query, username, role), &u); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrUserNotFound // 404
+		default:
+			return nil, err
+		}
+	}
+	return &u, nil
+}
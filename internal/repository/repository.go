@@ -4,18 +4,73 @@ package repository
 import (
 	"context"
 	"errors"
+	"time"
 
 	"github.com/UnendingLoop/CommentTree/internal/model"
 )
 
 type CommentRepository interface {
 	Create(ctx context.Context, n *model.CommentCreateData) (*model.DBComment, error)
-	GetAllRoot(ctx context.Context, limit, offset int, sort, order string) ([]model.DBComment, error)
+	GetAllRoot(ctx context.Context, q *model.PageQuery) ([]model.DBComment, error)
+	GetChildren(ctx context.Context, parentID int, q *model.PageQuery) ([]model.DBComment, error)
+	CountRoot(ctx context.Context, thread string, viewer int) (int, error)
+	CountChildren(ctx context.Context, parentID, viewer int) (int, error)
+	GetRepliesPreview(ctx context.Context, rootIDs []int, perParent, depth, viewer int) ([]model.DBComment, error)
 	DeleteByID(ctx context.Context, id int) error
 	GetCommentByID(ctx context.Context, id int) (*model.DBComment, error)
-	GetCommentWithChildrenByID(ctx context.Context, id int) ([]model.DBComment, error)
+	GetCommentWithChildrenByID(ctx context.Context, id, depth, viewer int) ([]model.DBComment, error)
+	GetAncestorsByID(ctx context.Context, id, limit, viewer int) ([]model.DBComment, error)
 	MarkAsDeletedByID(ctx context.Context, id int) error
-	RunSearchQuery(ctx context.Context, query string) ([]model.DBComment, error)
+	RestoreByID(ctx context.Context, id int, restoredBy string) (*model.DBComment, error)
+	MoveSubtree(ctx context.Context, id int, parentID *int) (*model.DBComment, error)
+	Vote(ctx context.Context, id int, voter string, value int) (*model.DBComment, error)
+	SetReaction(ctx context.Context, id int, emoji, reactor string, on bool) error
+	GetReactions(ctx context.Context, ids []int, reactor string) ([]model.DBReaction, error)
+	SetPinned(ctx context.Context, id int, pinned bool) (*model.DBComment, error)
+	GetPinnedRoots(ctx context.Context, thread string, viewer int) ([]model.DBComment, error)
+	SetCommentLock(ctx context.Context, id int, locked bool) (*model.DBComment, error)
+	SetThreadLock(ctx context.Context, key string, locked bool) (*model.DBThread, error)
+	IsBranchLocked(ctx context.Context, id int) (bool, error)
+	IsThreadLocked(ctx context.Context, key string) (bool, error)
+	SetThreadPremoderation(ctx context.Context, key string, on bool) (*model.DBThread, error)
+	IsThreadPremoderated(ctx context.Context, key string) (bool, error)
+	GetModerationQueue(ctx context.Context, thread string, limit, offset int) ([]model.DBComment, error)
+	SetModeration(ctx context.Context, id int, status, reason, by string) (*model.DBComment, error)
+	PurgeDeleted(ctx context.Context, cutoff time.Time) (purged, collapsed int, err error)
+	UpdateText(ctx context.Context, id int, prevText, text string, diff []byte) (*model.DBComment, error)
+	GetRevisions(ctx context.Context, id int) ([]model.DBRevision, error)
+	RunSearchQuery(ctx context.Context, query string, viewer int) ([]model.DBComment, error)
+	AddReport(ctx context.Context, id int, reporter string, data *model.CommentReportData) (open int, err error)
+	GetReportGroups(ctx context.Context, limit, offset int) ([]model.DBReportGroup, error)
+	ResolveReports(ctx context.Context, id int, resolution, by string) (int, error)
+	SetHidden(ctx context.Context, id int, hidden bool) error
+	GetSpamStats(ctx context.Context, tokens []string) (*model.DBSpamStats, error)
+	TrainSpam(ctx context.Context, id int, label string, tokens []string) error
+	SetSpamScore(ctx context.Context, id int, score float64) error
 }
 
-var ErrCommentNotFound error = errors.New("specified comment doesn't exist")
+type UserRepository interface {
+	CreateUser(ctx context.Context, username, passwordHash, role string) (*model.DBUser, error)
+	GetUserByName(ctx context.Context, username string) (*model.DBUser, error)
+	GetUserByID(ctx context.Context, id int) (*model.DBUser, error)
+	SetUserRole(ctx context.Context, username, role string) (*model.DBUser, error)
+}
+
+type APIKeyRepository interface {
+	CreateAPIKey(ctx context.Context, key *model.DBAPIKey) (*model.DBAPIKey, error)
+	ListAPIKeys(ctx context.Context) ([]model.DBAPIKey, error)
+	RevokeAPIKey(ctx context.Context, id int) (*model.DBAPIKey, error)
+	UseAPIKey(ctx context.Context, keyHash string) (*model.DBAPIKey, error)
+}
+
+var (
+	ErrUserExists         error = errors.New("username is already taken")
+	ErrUserNotFound       error = errors.New("specified user doesn't exist")
+	ErrAPIKeyNotFound     error = errors.New("specified API key doesn't exist")
+	ErrNoOpenReports      error = errors.New("comment has no open reports")
+	ErrCommentNotFound    error = errors.New("specified comment doesn't exist")
+	ErrMoveTargetNotFound error = errors.New("move target doesn't exist")
+	ErrMoveTargetDeleted  error = errors.New("move target is deleted or hidden")
+	ErrMoveIntoSubtree    error = errors.New("move target is inside the moved subtree")
+	ErrMoveAcrossThreads  error = errors.New("move target belongs to another thread")
+)
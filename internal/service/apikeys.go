@@ -0,0 +1,179 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/UnendingLoop/CommentTree/internal/auth"
+	"github.com/UnendingLoop/CommentTree/internal/model"
+	"github.com/UnendingLoop/CommentTree/internal/mwlogger"
+	"github.com/UnendingLoop/CommentTree/internal/repository"
+)
+
+// apiKeyPrefix отличает ключи интеграций от прочих секретов; по нему же ключ узнаётся в логах и списке
+const apiKeyPrefix = "ctk_"
+
+var apiKeyScopes = []string{model.ScopeCommentsWrite, model.ScopeCommentsDelete, model.ScopeModeration}
+
+type APIKeyService interface {
+	CreateAPIKey(ctx context.Context, data *model.APIKeyCreateData) (*APPAPIKey, error)
+	ListAPIKeys(ctx context.Context) ([]APPAPIKey, error)
+	RevokeAPIKey(ctx context.Context, id int) (*APPAPIKey, error)
+	AuthenticateAPIKey(ctx context.Context, key string) (*model.DBAPIKey, bool, error)
+}
+
+// APPAPIKey - ключ в выдаче; сам ключ (Key) возвращается только при выпуске
+type APPAPIKey struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedBy  string     `json:"created_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	Key        string     `json:"key,omitempty"`
+}
+
+type KService struct {
+	repo repository.APIKeyRepository
+}
+
+func NewAPIKeyService(keyRep repository.APIKeyRepository) APIKeyService {
+	return &KService{repo: keyRep}
+}
+
+func (k KService) CreateAPIKey(ctx context.Context, data *model.APIKeyCreateData) (*APPAPIKey, error) {
+	logger := mwlogger.LoggerFromContext(ctx)
+	admin, err := requireRole(ctx, model.RoleAdmin)
+	if err != nil {
+		return nil, err
+	}
+	name := strings.TrimSpace(data.Name)
+	scopes, ok := normalizeScopes(data.Scopes)
+	if name == "" || utf8.RuneCountInString(name) > 64 || !ok {
+		return nil, ErrInvalidAPIKey
+	}
+
+	secret, err := auth.NewSecret()
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to generate new API key")
+		return nil, ErrCommon500
+	}
+	key := apiKeyPrefix + secret
+
+	res, err := k.repo.CreateAPIKey(ctx, &model.DBAPIKey{
+		Name:      name,
+		Prefix:    key[:len(apiKeyPrefix)+8],
+		KeyHash:   auth.HashSecret(key),
+		Scopes:    scopes,
+		CreatedBy: admin.Name,
+	})
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to create new API key in DB")
+		return nil, ErrCommon500
+	}
+
+	logger.Info().Msg(fmt.Sprintf("API key %d %q issued by admin %d with scopes %v", res.ID, res.Name, admin.UserID, res.Scopes))
+	created := convertToAPPAPIKey(res)
+	created.Key = key
+	return created, nil
+}
+
+func (k KService) ListAPIKeys(ctx context.Context) ([]APPAPIKey, error) {
+	logger := mwlogger.LoggerFromContext(ctx)
+	if _, err := requireRole(ctx, model.RoleAdmin); err != nil {
+		return nil, err
+	}
+
+	keys, err := k.repo.ListAPIKeys(ctx)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to fetch API keys from DB")
+		return nil, ErrCommon500
+	}
+
+	res := make([]APPAPIKey, 0, len(keys))
+	for i := range keys {
+		res = append(res, *convertToAPPAPIKey(&keys[i]))
+	}
+	return res, nil
+}
+
+func (k KService) RevokeAPIKey(ctx context.Context, id int) (*APPAPIKey, error) {
+	logger := mwlogger.LoggerFromContext(ctx)
+	if id <= 0 {
+		return nil, ErrIncorrectID
+	}
+	admin, err := requireRole(ctx, model.RoleAdmin)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := k.repo.RevokeAPIKey(ctx, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrAPIKeyNotFound):
+			return nil, err
+		default:
+			logger.Error().Err(err).Msg(fmt.Sprintf("Failed to revoke API key %d", id))
+			return nil, ErrCommon500
+		}
+	}
+
+	logger.Info().Msg(fmt.Sprintf("API key %d revoked by admin %d", res.ID, admin.UserID))
+	return convertToAPPAPIKey(res), nil
+}
+
+// AuthenticateAPIKey - проверка ключа для middleware; заодно отмечает время использования ключа
+func (k KService) AuthenticateAPIKey(ctx context.Context, key string) (*model.DBAPIKey, bool, error) {
+	logger := mwlogger.LoggerFromContext(ctx)
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return nil, false, nil
+	}
+
+	res, err := k.repo.UseAPIKey(ctx, auth.HashSecret(key))
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrAPIKeyNotFound):
+			return nil, false, nil
+		default:
+			logger.Error().Err(err).Msg("Failed to check API key in DB")
+			return nil, false, err
+		}
+	}
+	return res, true, nil
+}
+
+func convertToAPPAPIKey(k *model.DBAPIKey) *APPAPIKey {
+	return &APPAPIKey{
+		ID:         k.ID,
+		Name:       k.Name,
+		Prefix:     k.Prefix,
+		Scopes:     k.Scopes,
+		CreatedBy:  k.CreatedBy,
+		CreatedAt:  k.CreatedAt,
+		LastUsedAt: k.LastUsedAt,
+		RevokedAt:  k.RevokedAt,
+	}
+}
+
+// normalizeScopes - непустой набор известных прав без повторов, в порядке apiKeyScopes
+func normalizeScopes(scopes []string) ([]string, bool) {
+	res := []string{}
+	for _, s := range scopes {
+		if !slices.Contains(apiKeyScopes, strings.TrimSpace(s)) {
+			return nil, false
+		}
+	}
+	for _, s := range apiKeyScopes {
+		if slices.ContainsFunc(scopes, func(v string) bool { return strings.TrimSpace(v) == s }) {
+			res = append(res, s)
+		}
+	}
+	return res, len(res) > 0
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/UnendingLoop/CommentTree/internal/model"
+)
+
+// pageCursor - содержимое непрозрачного курсора: курсор фиксирует сортировку, с которой была получена страница
+type pageCursor struct {
+	Sort  string `json:"s"`
+	Order string `json:"o"`
+	Value string `json:"v"`
+	ID    int    `json:"id"`
+}
+
+// CommentPage - страница комментариев и курсор для получения следующей;
+// Total заполняется только по запросу конверта, чтобы не выполнять лишний count
+type CommentPage struct {
+	Items      []APPComment `json:"items"`
+	NextCursor string       `json:"next_cursor,omitempty"`
+	Total      int          `json:"total"`
+}
+
+func encodeCursor(sort, order string, last *model.DBComment) string {
+	raw, _ := json.Marshal(pageCursor{Sort: sort, Order: order, Value: last.SortKey, ID: last.ID})
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCursor(token string) (*pageCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var cur pageCursor
+	if err := json.Unmarshal(raw, &cur); err != nil || cur.ID <= 0 {
+		return nil, ErrInvalidCursor
+	}
+
+	// курсор приходит от клиента - сортировку допускаем только из провалидированного набора
+	switch cur.Sort {
+	case "created_at", "author", "content", model.SortTop, model.SortControversial, model.SortHot:
+	default:
+		return nil, ErrInvalidCursor
+	}
+	if cur.Order != "ASC" && cur.Order != "DESC" {
+		return nil, ErrInvalidCursor
+	}
+
+	return &cur, nil
+}
+
+// buildPageQuery переводит провалидированный запрос в параметры выборки;
+// запрашивается на один элемент больше лимита, чтобы понять, есть ли следующая страница
+func buildPageQuery(req *model.RootRequest) (*model.PageQuery, error) {
+	q := &model.PageQuery{
+		Limit:  req.Limit + 1,
+		Offset: (req.Page - 1) * req.Limit,
+		Sort:   req.Sort,
+		Order:  req.Order,
+		Thread: req.Thread,
+	}
+
+	// курсор главнее page: позиция и сортировка берутся из него, смещение по странице не применяется
+	if req.Cursor != "" {
+		cur, err := decodeCursor(req.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		req.Sort, req.Order = cur.Sort, cur.Order
+		q.Sort, q.Order = cur.Sort, cur.Order
+		q.Offset = 0
+		q.After = &model.Cursor{Value: cur.Value, ID: cur.ID}
+	}
+
+	return q, nil
+}
+
+// cutPage отрезает лишний элемент выборки и формирует курсор следующей страницы
+func cutPage(res []model.DBComment, req *model.RootRequest) ([]model.DBComment, string) {
+	if len(res) <= req.Limit {
+		return res, ""
+	}
+	res = res[:req.Limit]
+	return res, encodeCursor(req.Sort, req.Order, &res[len(res)-1])
+}
@@ -0,0 +1,120 @@
+package service
+
+import "unicode"
+
+const (
+	DiffEqual  = "equal"
+	DiffInsert = "insert"
+	DiffDelete = "delete"
+)
+
+// DiffOp - фрагмент пословного диффа между двумя версиями текста
+type DiffOp struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// maxDiffCells - предел таблицы НОП: произведение числа токенов изменённой середины старого и нового текста.
+// 1<<20 ячеек int32 - 4 МБ; середина крупнее отдаётся целиком как delete+insert
+const maxDiffCells = 1 << 20
+
+// diffWords строит пословный дифф через наибольшую общую подпоследовательность токенов;
+// пробелы - отдельные токены, поэтому склейка equal+delete даёт старый текст, equal+insert - новый.
+// Общие начало и конец отсекаются заранее: обычная правка меняет малую часть текста, и НОП считается только по ней
+func diffWords(oldText, newText string) []DiffOp {
+	a, b := tokenize(oldText), tokenize(newText)
+
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	midA, midB := a[pre:len(a)-suf], b[pre:len(b)-suf]
+
+	ops := make([]DiffOp, 0)
+	push := func(op, text string) {
+		if n := len(ops); n > 0 && ops[n-1].Op == op {
+			ops[n-1].Text += text
+			return
+		}
+		ops = append(ops, DiffOp{Op: op, Text: text})
+	}
+
+	for _, t := range a[:pre] {
+		push(DiffEqual, t)
+	}
+	if len(midA)*len(midB) > maxDiffCells {
+		for _, t := range midA {
+			push(DiffDelete, t)
+		}
+		for _, t := range midB {
+			push(DiffInsert, t)
+		}
+	} else {
+		diffLCS(midA, midB, push)
+	}
+	for _, t := range a[len(a)-suf:] {
+		push(DiffEqual, t)
+	}
+
+	return ops
+}
+
+// diffLCS раскладывает a и b на общие, удалённые и добавленные токены по таблице НОП
+func diffLCS(a, b []string, push func(op, text string)) {
+	// lcs[i*w+j] - длина НОП для суффиксов a[i:] и b[j:]
+	w := len(b) + 1
+	lcs := make([]int32, (len(a)+1)*w)
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i*w+j] = lcs[(i+1)*w+j+1] + 1
+			default:
+				lcs[i*w+j] = max(lcs[(i+1)*w+j], lcs[i*w+j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			push(DiffEqual, a[i])
+			i++
+			j++
+		case lcs[(i+1)*w+j] >= lcs[i*w+j+1]:
+			push(DiffDelete, a[i])
+			i++
+		default:
+			push(DiffInsert, b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		push(DiffDelete, a[i])
+	}
+	for ; j < len(b); j++ {
+		push(DiffInsert, b[j])
+	}
+}
+
+// tokenize режет текст на чередующиеся группы пробельных и непробельных символов
+func tokenize(s string) []string {
+	tokens := make([]string, 0)
+	start, inSpace := 0, false
+	for i, r := range s {
+		if space := unicode.IsSpace(r); i > start && space != inSpace {
+			tokens = append(tokens, s[start:i])
+			start = i
+		}
+		inSpace = unicode.IsSpace(r)
+	}
+	if start < len(s) {
+		tokens = append(tokens, s[start:])
+	}
+	return tokens
+}
@@ -0,0 +1,278 @@
+package service
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Действия фильтра при срабатывании
+const (
+	FilterReject   = "reject"   // отказать в создании или правке
+	FilterMask     = "mask"     // исправить текст и пропустить дальше
+	FilterModerate = "moderate" // сохранить, но отправить в очередь модерации
+)
+
+// DefaultMaxLength - предел длины комментария в символах, если в конфиге не задан другой
+const DefaultMaxLength = 10000
+
+// ContentFilter - одна проверка текста комментария перед сохранением
+type ContentFilter interface {
+	Name() string
+	Check(text string) FilterVerdict
+}
+
+// FilterVerdict - решение фильтра; пустой Action - текст прошёл проверку
+type FilterVerdict struct {
+	Action string
+	Text   string // исправленный текст для mask
+	Detail string // что именно не понравилось фильтру
+}
+
+// FilterError - отказ фильтра, в ответе API отдаются название фильтра и причина
+type FilterError struct {
+	Filter string
+	Detail string
+}
+
+func (e *FilterError) Error() string {
+	return ErrFiltered.Error()
+}
+
+func (e *FilterError) Is(target error) bool {
+	return target == ErrFiltered
+}
+
+// FilterConfig - настройки встроенных фильтров; нулевой предел или пустой список выключает фильтр,
+// пустое действие заменяется действием по умолчанию для этого фильтра
+type FilterConfig struct {
+	Blocklist       []string
+	BlocklistAction string // по умолчанию mask
+	MaxLinks        int
+	LinksAction     string // по умолчанию moderate
+	MaxRepeat       int
+	RepeatAction    string // по умолчанию mask
+	MaxLength       int
+	LengthAction    string // по умолчанию reject
+}
+
+// NewContentFilters собирает цепочку встроенных фильтров в порядке применения:
+// стоп-слова, ссылки, повторы символов, длина - длина последней, чтобы учитывать уже замаскированный текст
+func NewContentFilters(cfg FilterConfig) ([]ContentFilter, error) {
+	var filters []ContentFilter
+
+	words := make(map[string]struct{}, len(cfg.Blocklist))
+	for _, w := range cfg.Blocklist {
+		if w = normalizeWord(strings.TrimSpace(w)); w != "" {
+			words[w] = struct{}{}
+		}
+	}
+	if len(words) > 0 {
+		action, err := filterAction("blocklist", cfg.BlocklistAction, FilterMask)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, blocklistFilter{words: words, action: action})
+	}
+
+	if cfg.MaxLinks > 0 {
+		action, err := filterAction("links", cfg.LinksAction, FilterModerate)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, linksFilter{max: cfg.MaxLinks, action: action})
+	}
+
+	if cfg.MaxRepeat > 0 {
+		action, err := filterAction("repeats", cfg.RepeatAction, FilterMask)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, repeatFilter{max: cfg.MaxRepeat, action: action})
+	}
+
+	if cfg.MaxLength > 0 {
+		action, err := filterAction("length", cfg.LengthAction, FilterReject)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, lengthFilter{max: cfg.MaxLength, action: action})
+	}
+
+	return filters, nil
+}
+
+func filterAction(name, action, def string) (string, error) {
+	switch action = strings.ToLower(strings.TrimSpace(action)); action {
+	case "":
+		return def, nil
+	case FilterReject, FilterMask, FilterModerate:
+		return action, nil
+	default:
+		return "", fmt.Errorf("unknown action %q for content filter %q", action, name)
+	}
+}
+
+// filterText прогоняет текст через цепочку: reject прерывает её, mask подменяет текст для следующих фильтров,
+// moderate только копит причины - по ним комментарий уйдёт в очередь модерации
+func (c CService) filterText(text string) (string, string, error) {
+	var flagged []string
+	for _, f := range c.filters {
+		v := f.Check(text)
+		switch v.Action {
+		case FilterReject:
+			return "", "", &FilterError{Filter: f.Name(), Detail: v.Detail}
+		case FilterMask:
+			text = v.Text
+		case FilterModerate:
+			flagged = append(flagged, v.Detail)
+		}
+	}
+	return text, strings.Join(flagged, "; "), nil
+}
+
+/*
+	Встроенные фильтры
+*/
+
+// foldWord приводит слово к виду для сравнения со стоп-листом: совместимые формы (NFKD), без диакритики
+// и невидимых символов, без учёта регистра - так "Ёлка", "ёлка" и "е\u200bлка" совпадают
+var foldWord = transform.Chain(norm.NFKD, runes.Remove(runes.In(unicode.Mn)), runes.Remove(runes.In(unicode.Cf)), cases.Fold(), norm.NFC)
+
+func normalizeWord(w string) string {
+	res, _, err := transform.String(foldWord, w)
+	if err != nil {
+		return strings.ToLower(w)
+	}
+	return res
+}
+
+// isWordRune - символ внутри слова; невидимые символы и диакритика слово не разрывают
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.In(r, unicode.Mn, unicode.Cf)
+}
+
+type blocklistFilter struct {
+	words  map[string]struct{}
+	action string
+}
+
+func (f blocklistFilter) Name() string { return "blocklist" }
+
+// Check сравнивает со стоп-листом каждое слово целиком; при маскировании буквы слова заменяются звёздочками,
+// невидимые символы и диакритика выбрасываются
+func (f blocklistFilter) Check(text string) FilterVerdict {
+	var b strings.Builder
+	found := 0
+	for len(text) > 0 {
+		r, size := utf8.DecodeRuneInString(text)
+		if !isWordRune(r) {
+			b.WriteString(text[:size])
+			text = text[size:]
+			continue
+		}
+
+		end := strings.IndexFunc(text, func(r rune) bool { return !isWordRune(r) })
+		if end < 0 {
+			end = len(text)
+		}
+		word := text[:end]
+		if _, ok := f.words[normalizeWord(word)]; ok {
+			found++
+			b.WriteString(strings.Map(func(r rune) rune {
+				if unicode.In(r, unicode.Mn, unicode.Cf) {
+					return -1
+				}
+				return '*'
+			}, word))
+		} else {
+			b.WriteString(word)
+		}
+		text = text[end:]
+	}
+
+	if found == 0 {
+		return FilterVerdict{}
+	}
+	return FilterVerdict{Action: f.action, Text: b.String(), Detail: fmt.Sprintf("blocked words: %d", found)}
+}
+
+var linkPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s<>"]+`)
+
+type linksFilter struct {
+	max    int
+	action string
+}
+
+func (f linksFilter) Name() string { return "links" }
+
+// Check считает ссылки; при маскировании первые max остаются, остальные заменяются на [link]
+func (f linksFilter) Check(text string) FilterVerdict {
+	found := len(linkPattern.FindAllStringIndex(text, -1))
+	if found <= f.max {
+		return FilterVerdict{}
+	}
+
+	n := 0
+	masked := linkPattern.ReplaceAllStringFunc(text, func(link string) string {
+		if n++; n <= f.max {
+			return link
+		}
+		return "[link]"
+	})
+	return FilterVerdict{Action: f.action, Text: masked, Detail: fmt.Sprintf("too many links: %d of %d allowed", found, f.max)}
+}
+
+type repeatFilter struct {
+	max    int
+	action string
+}
+
+func (f repeatFilter) Name() string { return "repeats" }
+
+// Check ищет серии одного и того же символа длиннее max ("ааааааа", "!!!!!!!"); пробелы не считаются,
+// при маскировании серия укорачивается до max
+func (f repeatFilter) Check(text string) FilterVerdict {
+	var b strings.Builder
+	var prev rune
+	run, longest := 0, 0
+	for _, r := range text {
+		if r == prev && !unicode.IsSpace(r) {
+			run++
+		} else {
+			prev, run = r, 1
+		}
+		longest = max(longest, run)
+		if run <= f.max {
+			b.WriteRune(r)
+		}
+	}
+
+	if longest <= f.max {
+		return FilterVerdict{}
+	}
+	return FilterVerdict{Action: f.action, Text: b.String(), Detail: fmt.Sprintf("character repeated %d times, %d allowed", longest, f.max)}
+}
+
+type lengthFilter struct {
+	max    int
+	action string
+}
+
+func (f lengthFilter) Name() string { return "length" }
+
+// Check ограничивает длину в символах; при маскировании текст обрезается
+func (f lengthFilter) Check(text string) FilterVerdict {
+	n := utf8.RuneCountInString(text)
+	if n <= f.max {
+		return FilterVerdict{}
+	}
+	return FilterVerdict{Action: f.action, Text: string([]rune(text)[:f.max]), Detail: fmt.Sprintf("comment is too long: %d of %d characters", n, f.max)}
+}
@@ -1,6 +1,10 @@
 package service
 
 import (
+	"cmp"
+	"encoding/json"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/UnendingLoop/CommentTree/internal/model"
@@ -8,72 +12,238 @@ import (
 
 var deletedComment string = "[Комментарий удалён]"
 
+var hiddenComment string = "[Комментарий скрыт модератором]"
+
 type APPComment struct {
-	ID        int          `json:"id,omitempty"`
-	ParentID  *int         `json:"parent_id,omitempty"`
-	Text      string       `json:"content"`
-	CreatedAt time.Time    `json:"created_at,omitempty"`
-	IsDeleted bool         `json:"deleted,omitempty"`
-	CanReply  bool         `json:"replyable,omitempty"`
-	Author    string       `json:"author,omitempty"`
-	Children  []APPComment `json:"children,omitempty"`
+	ID         int           `json:"id,omitempty"`
+	ParentID   *int          `json:"parent_id,omitempty"`
+	Text       string        `json:"content"`
+	CreatedAt  time.Time     `json:"created_at,omitempty"`
+	IsDeleted  bool          `json:"deleted,omitempty"`
+	IsHidden   bool          `json:"hidden,omitempty"` // скрыт по жалобам до решения модератора
+	CanReply   bool          `json:"replyable,omitempty"`
+	Author     string        `json:"author,omitempty"`
+	AuthorID   *int          `json:"author_id,omitempty"`
+	Guest      bool          `json:"guest,omitempty"` // имя указал гость, оно не проверялось
+	EditedAt   *time.Time    `json:"edited_at,omitempty"`
+	EditCount  int           `json:"edit_count,omitempty"`
+	RestoredAt *time.Time    `json:"restored_at,omitempty"`
+	RestoredBy string        `json:"restored_by,omitempty"`
+	ThreadKey  string        `json:"thread_key,omitempty"`
+	Locked     bool          `json:"locked,omitempty"` // ответы закрыты блокировкой этого коммента, предка или потока
+	Pinned     bool          `json:"pinned,omitempty"` // корень закреплён над выдачей первой страницы
+	Score      int           `json:"score"`            // голоса за минус голоса против
+	Upvotes    int           `json:"upvotes,omitempty"`
+	Downvotes  int           `json:"downvotes,omitempty"`
+	Reactions  []APPReaction `json:"reactions,omitempty"`
+	EditToken  string        `json:"edit_token,omitempty"` // секрет правки анонимного комментария, только в ответе на создание
+	Status     string        `json:"status,omitempty"`     // pending/rejected - виден только автору и модераторам, у одобренных пусто
+	ModReason  string        `json:"moderation_reason,omitempty"`
+	SpamScore  *float64      `json:"spam_score,omitempty"` // оценка спам-классификатора, только в очереди модерации и жалобах
+	Children   []*APPComment `json:"children,omitempty"`
+
+	ReplyCount      int  `json:"reply_count,omitempty"`       // количество прямых ответов (в превью и списке детей)
+	DescendantCount int  `json:"descendant_count,omitempty"`  // количество всех потомков (в списке детей)
+	HasMore         bool `json:"has_more,omitempty"`          // не все прямые ответы встроены в Children
+	HasMoreChildren bool `json:"has_more_children,omitempty"` // ответы есть, но ни один не загружен - ветку нужно продолжить отдельным запросом
+	Focused         bool `json:"focused,omitempty"`           // запрошенный комментарий в permalink-виде с предками
+}
+
+// APPThread - состояние потока комментариев
+type APPThread struct {
+	ThreadKey    string     `json:"thread_key"`
+	Locked       bool       `json:"locked"`
+	LockedAt     *time.Time `json:"locked_at,omitempty"`
+	Premoderated bool       `json:"premoderated"` // новые комментарии ждут одобрения модератора
+}
+
+// APPRevision - версия текста комментария; последняя в списке - текущий текст
+type APPRevision struct {
+	Version    int        `json:"version"`
+	Text       string     `json:"content"`
+	CreatedAt  time.Time  `json:"created_at"`            // с какого момента версия актуальна
+	ReplacedAt *time.Time `json:"replaced_at,omitempty"` // когда версию заменили, у текущей - пусто
+	Diff       []DiffOp   `json:"diff,omitempty"`        // изменения относительно предыдущей версии
 }
 
 func convertToAPPComment(c *model.DBComment) *APPComment {
 	isDeleted := c.DeletedAt != nil
+	isHidden := c.HiddenAt != nil
 
 	content := c.Text
-	if isDeleted {
+	switch {
+	case isDeleted:
 		content = deletedComment
+	case isHidden:
+		content = hiddenComment
+	}
+
+	status := c.Status
+	if status == model.StatusApproved { // одобренный - обычное состояние, в выдаче не отмечается
+		status = ""
 	}
 
 	return &APPComment{
-		ID:        c.ID,
-		ParentID:  c.ParentID,
-		Text:      content,
-		CreatedAt: c.CreatedAt,
-		IsDeleted: isDeleted,
-		CanReply:  !isDeleted,
+		ID:         c.ID,
+		ParentID:   c.ParentID,
+		Text:       content,
+		CreatedAt:  c.CreatedAt,
+		IsDeleted:  isDeleted,
+		IsHidden:   isHidden,
+		CanReply:   !isDeleted && !isHidden && c.LockedAt == nil,
+		Author:     c.Author,
+		AuthorID:   c.AuthorID,
+		Guest:      c.AuthorID == nil && c.EditHash != "",
+		EditedAt:   c.EditedAt,
+		EditCount:  c.EditCount,
+		RestoredAt: c.RestoredAt,
+		RestoredBy: c.RestoredBy,
+		ThreadKey:  c.ThreadKey,
+		Locked:     c.LockedAt != nil,
+		Pinned:     c.PinnedAt != nil,
+		Score:      c.Upvotes - c.Downvotes,
+		Upvotes:    c.Upvotes,
+		Downvotes:  c.Downvotes,
+		Status:     status,
+		ModReason:  c.ModReason,
+
+		ReplyCount:      c.ReplyCount,
+		DescendantCount: c.DescendantCount,
 	}
 }
 
-func compileToAPPCommentTree(comments []model.DBComment, parentID *int) []APPComment {
-	index := map[int]*APPComment{}
+func convertToAPPThread(t *model.DBThread) *APPThread {
+	return &APPThread{ThreadKey: t.Key, Locked: t.LockedAt != nil, LockedAt: t.LockedAt, Premoderated: t.Premoderated}
+}
+
+// compileToAPPCommentTree собирает дерево из плоского списка за один проход: узлы хранятся по указателям,
+// поэтому потомки, привязанные позже своего родителя, не теряются. Корни сохраняют порядок входного списка,
+// братья внутри каждого узла упорядочиваются по ключу siblingSort.
+func compileToAPPCommentTree(comments []model.DBComment, parentID *int, siblingSort string) []APPComment {
+	index := make(map[int]*APPComment, len(comments))
+	orphans := map[int][]*APPComment{} // дети, пришедшие раньше своего родителя
+	roots := make([]*APPComment, 0)
 
-	// конвертируем в респонсный вид
-	for _, c := range comments {
-		resp := convertToAPPComment(&c)
-		index[resp.ID] = resp
+	for i := range comments {
+		node := convertToAPPComment(&comments[i])
+		node.Children = orphans[node.ID]
+		delete(orphans, node.ID)
+		index[node.ID] = node
+
+		if node.ParentID == nil {
+			roots = append(roots, node)
+			continue
+		}
+		if parent, ok := index[*node.ParentID]; ok {
+			parent.Children = append(parent.Children, node)
+			continue
+		}
+		orphans[*node.ParentID] = append(orphans[*node.ParentID], node)
 	}
 
-	// связываем все узлы между собой
-	for _, resp := range index {
-		if resp.ParentID != nil {
-			parent, ok := index[*resp.ParentID]
-			if ok {
-				parent.Children = append(parent.Children, *resp)
-			}
+	// упорядочиваем братьев детерминированно и отмечаем узлы с невстроенными ответами
+	less := siblingComparator(siblingSort)
+	for _, node := range index {
+		if len(node.Children) > 1 {
+			slices.SortFunc(node.Children, less)
 		}
+		markContinuation(node)
 	}
+
 	// сбор в итоговый массив
-	result := make([]APPComment, 0)
-	for _, resp := range index {
-		switch parentID {
-		case nil: // ищем только корни
-			if resp.ParentID == nil {
-				result = append(result, *resp)
-			}
-		default:
-			if resp.ID == *parentID { // ищем только родителя всей ветки
-				result = append(result, *resp)
-				return result
-			}
+	result := make([]APPComment, 0, len(roots))
+	switch parentID {
+	case nil: // ищем только корни
+		for _, root := range roots {
+			result = append(result, *root)
+		}
+	default: // ищем только родителя всей ветки
+		if branch, ok := index[*parentID]; ok {
+			result = append(result, *branch)
 		}
 	}
 
 	return result
 }
 
+// wrapWithAncestors подвешивает ветку под цепочку предков (ancestors упорядочены от верхнего к ближайшему):
+// у каждого предка остаётся только один ребёнок на пути к запрошенному комментарию
+func wrapWithAncestors(target APPComment, ancestors []model.DBComment) []APPComment {
+	target.Focused = true
+	node := &target
+	for i := len(ancestors) - 1; i >= 0; i-- {
+		parent := convertToAPPComment(&ancestors[i])
+		parent.Children = []*APPComment{node}
+		markContinuation(parent)
+		node = parent
+	}
+	return []APPComment{*node}
+}
+
+// propagateLock распространяет блокировку ответов вниз по деревьям: inherited - заблокировано ли что-то выше корней
+func propagateLock(nodes []APPComment, inherited bool) {
+	for i := range nodes {
+		lockNode(&nodes[i], inherited)
+	}
+}
+
+// propagateChainLock - то же для цепочки предков, упорядоченной от верхнего к ближайшему
+func propagateChainLock(chain []APPComment, inherited bool) {
+	for i := range chain {
+		lockNode(&chain[i], inherited)
+		inherited = chain[i].Locked
+	}
+}
+
+func lockNode(node *APPComment, inherited bool) {
+	node.Locked = node.Locked || inherited
+	node.CanReply = node.CanReply && !node.Locked
+	for _, child := range node.Children {
+		lockNode(child, node.Locked)
+	}
+}
+
+// markContinuation отмечает узлы, у которых загружены не все прямые ответы
+func markContinuation(node *APPComment) {
+	node.HasMore = node.ReplyCount > len(node.Children)
+	node.HasMoreChildren = node.ReplyCount > 0 && len(node.Children) == 0
+}
+
+// siblingComparator возвращает функцию сравнения братьев для указанного ключа сортировки;
+// при равенстве ключей порядок определяется ID, чтобы выдача была стабильной между запросами
+func siblingComparator(siblingSort string) func(a, b *APPComment) int {
+	switch siblingSort {
+	case model.SortOldest:
+		return func(a, b *APPComment) int {
+			return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), cmp.Compare(a.ID, b.ID))
+		}
+	case model.SortAuthor:
+		return func(a, b *APPComment) int {
+			return cmp.Or(
+				strings.Compare(strings.ToLower(a.Author), strings.ToLower(b.Author)),
+				a.CreatedAt.Compare(b.CreatedAt),
+				cmp.Compare(a.ID, b.ID),
+			)
+		}
+	case model.SortTop:
+		return func(a, b *APPComment) int {
+			return cmp.Or(cmp.Compare(b.Score, a.Score), b.CreatedAt.Compare(a.CreatedAt), cmp.Compare(b.ID, a.ID))
+		}
+	case model.SortControversial:
+		return func(a, b *APPComment) int {
+			return cmp.Or(cmp.Compare(controversy(b), controversy(a)), b.CreatedAt.Compare(a.CreatedAt), cmp.Compare(b.ID, a.ID))
+		}
+	case model.SortHot:
+		return func(a, b *APPComment) int {
+			return cmp.Or(cmp.Compare(hotness(b), hotness(a)), cmp.Compare(b.ID, a.ID))
+		}
+	default: // model.SortNewest
+		return func(a, b *APPComment) int {
+			return cmp.Or(b.CreatedAt.Compare(a.CreatedAt), cmp.Compare(b.ID, a.ID))
+		}
+	}
+}
+
 func convertSearchResults(input []model.DBComment) []APPComment {
 	res := make([]APPComment, 0, len(input))
 	for _, resp := range input {
@@ -81,3 +251,42 @@ func convertSearchResults(input []model.DBComment) []APPComment {
 	}
 	return res
 }
+
+// convertFlatList конвертирует список комментариев без вложенности: ответы не встраиваются,
+// поэтому любой комментарий с ответами помечается has_more
+func convertFlatList(input []model.DBComment) []APPComment {
+	res := make([]APPComment, 0, len(input))
+	for _, c := range input {
+		item := convertToAPPComment(&c)
+		markContinuation(item)
+		res = append(res, *item)
+	}
+	return res
+}
+
+// compileRevisions выстраивает историю версий от исходной к текущей и считает дифф каждой версии с предыдущей
+func compileRevisions(revisions []model.DBRevision, current *model.DBComment) []APPRevision {
+	res := make([]APPRevision, 0, len(revisions)+1)
+	for _, r := range revisions {
+		res = append(res, APPRevision{Text: r.Text, CreatedAt: r.CreatedAt, ReplacedAt: &r.ReplacedAt})
+	}
+
+	since := current.CreatedAt
+	if current.EditedAt != nil {
+		since = *current.EditedAt
+	}
+	res = append(res, APPRevision{Text: current.Text, CreatedAt: since})
+
+	for i := range res {
+		res[i].Version = i + 1
+		if i == 0 {
+			continue
+		}
+		// дифф сохранён при правке вместе с предыдущей версией; у старых версий его нет - считаем на месте
+		if cached := revisions[i-1].NextDiff; cached != nil && json.Unmarshal(cached, &res[i].Diff) == nil {
+			continue
+		}
+		res[i].Diff = diffWords(res[i-1].Text, res[i].Text)
+	}
+	return res
+}
@@ -1,6 +1,8 @@
 package service
 
 import (
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
@@ -19,7 +21,7 @@ func TestCompileToAPPCommentTree(t *testing.T) {
 		{ID: 4, Text: "child3", ParentID: ptr(1), DeletedAt: &deleted},
 	}
 
-	tree := compileToAPPCommentTree(comments, &comments[0].ID)
+	tree := compileToAPPCommentTree(comments, &comments[0].ID, model.SortOldest)
 
 	if len(tree) != 1 {
 		t.Fatalf("Branch-compile: expected 1 parent, got %d", len(tree))
@@ -49,11 +51,98 @@ func TestCompileToAPPCommentTree(t *testing.T) {
 		{ID: 8, Text: "child1", ParentID: ptr(7)},
 		{ID: 9, Text: "child2", ParentID: ptr(7)},
 	}
-	tree = compileToAPPCommentTree(comments, nil)
+	tree = compileToAPPCommentTree(comments, nil, model.SortOldest)
 
 	if len(tree) != 3 {
 		t.Fatalf("Root-compile: expected 3 roots, got %d", len(tree))
 	}
+
+	for i, id := range []int{1, 4, 7} {
+		if tree[i].ID != id {
+			t.Fatalf("Root-compile: expected roots to keep input order, got %d at position %d", tree[i].ID, i)
+		}
+	}
+}
+
+func TestCompileToAPPCommentTree_KeepsGrandchildren(t *testing.T) {
+	// потомки приходят в произвольном порядке, в том числе раньше своих родителей
+	comments := []model.DBComment{
+		{ID: 5, Text: "grandgrandchild", ParentID: ptr(4)},
+		{ID: 1, Text: "root"},
+		{ID: 4, Text: "grandchild", ParentID: ptr(2)},
+		{ID: 2, Text: "child1", ParentID: ptr(1)},
+		{ID: 3, Text: "child2", ParentID: ptr(1)},
+	}
+
+	tree := compileToAPPCommentTree(comments, ptr(1), model.SortOldest)
+	if len(tree) != 1 || len(tree[0].Children) != 2 {
+		t.Fatalf("expected root with 2 children, got %+v", tree)
+	}
+
+	child := tree[0].Children[0]
+	if child.ID != 2 || len(child.Children) != 1 || len(child.Children[0].Children) != 1 {
+		t.Fatalf("expected full chain 2 -> 4 -> 5 to be preserved, got %+v", child)
+	}
+}
+
+func TestCompileToAPPCommentTree_SiblingOrder(t *testing.T) {
+	base := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	comments := []model.DBComment{
+		{ID: 1, Text: "root", CreatedAt: base},
+		{ID: 2, ParentID: ptr(1), Author: "bob", CreatedAt: base.Add(time.Minute)},
+		{ID: 3, ParentID: ptr(1), Author: "Alice", CreatedAt: base.Add(3 * time.Minute)},
+		{ID: 4, ParentID: ptr(1), Author: "carol", CreatedAt: base.Add(2 * time.Minute)},
+		{ID: 5, ParentID: ptr(1), Author: "dave", CreatedAt: base.Add(2 * time.Minute)},
+	}
+
+	tests := []struct {
+		sort string
+		want []int
+	}{
+		{model.SortOldest, []int{2, 4, 5, 3}},
+		{model.SortNewest, []int{3, 5, 4, 2}},
+		{model.SortAuthor, []int{3, 2, 4, 5}},
+	}
+
+	for _, tt := range tests {
+		for range 5 { // порядок не должен зависеть от обхода map
+			tree := compileToAPPCommentTree(comments, ptr(1), tt.sort)
+			for i, id := range tt.want {
+				if got := tree[0].Children[i].ID; got != id {
+					t.Fatalf("sort %q: expected %d at position %d, got %d", tt.sort, id, i, got)
+				}
+			}
+		}
+	}
+}
+
+func TestCompileToAPPCommentTree_VoteOrder(t *testing.T) {
+	base := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	comments := []model.DBComment{
+		{ID: 1, Text: "root", CreatedAt: base},
+		{ID: 2, ParentID: ptr(1), Upvotes: 5, CreatedAt: base.Add(time.Minute)},
+		{ID: 3, ParentID: ptr(1), Upvotes: 3, Downvotes: 3, CreatedAt: base.Add(3 * time.Minute)},
+		{ID: 4, ParentID: ptr(1), Upvotes: 1, CreatedAt: base.Add(2 * time.Minute)},
+		{ID: 5, ParentID: ptr(1), Downvotes: 2, CreatedAt: base.Add(10 * time.Hour)},
+	}
+
+	tests := []struct {
+		sort string
+		want []int
+	}{
+		{model.SortTop, []int{2, 4, 3, 5}},
+		{model.SortControversial, []int{3, 5, 4, 2}}, // без голосов против спорность нулевая, дальше - новые
+		{model.SortHot, []int{2, 5, 3, 4}},           // свежесть перевешивает отрицательный счёт у 5
+	}
+
+	for _, tt := range tests {
+		tree := compileToAPPCommentTree(comments, ptr(1), tt.sort)
+		for i, id := range tt.want {
+			if got := tree[0].Children[i].ID; got != id {
+				t.Fatalf("sort %q: expected %d at position %d, got %d", tt.sort, id, i, got)
+			}
+		}
+	}
 }
 
 func TestConvertSearchResults(t *testing.T) {
@@ -79,3 +168,77 @@ func TestConvertSearchResults(t *testing.T) {
 		t.Fatalf("Search-conversion: expected comment to become non-replyable(false), got %v", tree[3].CanReply)
 	}
 }
+
+func TestPropagateLock(t *testing.T) {
+	now := time.Now()
+	tree := compileToAPPCommentTree([]model.DBComment{
+		{ID: 1},
+		{ID: 2, ParentID: ptr(1), LockedAt: &now},
+		{ID: 3, ParentID: ptr(2)},
+		{ID: 4, ParentID: ptr(1)},
+	}, nil, model.SortOldest)
+
+	propagateLock(tree, false)
+
+	root := tree[0]
+	locked, open := root.Children[0], root.Children[1]
+	if !root.CanReply || root.Locked {
+		t.Fatalf("expected root to stay open, got %+v", root)
+	}
+	if locked.CanReply || locked.Children[0].CanReply || !locked.Children[0].Locked {
+		t.Fatalf("expected locked branch to be non-replyable down to leaves, got %+v", locked)
+	}
+	if !open.CanReply {
+		t.Fatalf("expected sibling branch to stay open, got %+v", open)
+	}
+}
+
+func TestConvertToAPPComment_Hidden(t *testing.T) {
+	now := time.Now()
+	res := convertToAPPComment(&model.DBComment{ID: 1, Text: "spam", HiddenAt: &now})
+
+	if res.Text != hiddenComment || !res.IsHidden || res.IsDeleted || res.CanReply {
+		t.Fatalf("expected hidden comment to be replaced with placeholder, got %+v", res)
+	}
+}
+
+func TestDiffWords(t *testing.T) {
+	oldText := "привет  большой мир"
+	newText := "привет  новый мир!"
+
+	ops := diffWords(oldText, newText)
+
+	var before, after string
+	for _, op := range ops {
+		if op.Op != DiffInsert {
+			before += op.Text
+		}
+		if op.Op != DiffDelete {
+			after += op.Text
+		}
+	}
+	if before != oldText || after != newText {
+		t.Fatalf("diff does not reproduce both versions: %+v", ops)
+	}
+	if ops[0] != (DiffOp{Op: DiffEqual, Text: "привет  "}) {
+		t.Fatalf("expected common prefix to be kept, got %+v", ops[0])
+	}
+}
+
+func TestDiffWords_LargeMiddle(t *testing.T) {
+	oldText := "начало " + strings.Repeat("a ", 2000) + "конец"
+	newText := "начало " + strings.Repeat("b ", 2000) + "конец"
+
+	// середина 4000x4000 токенов больше maxDiffCells - она заменяется целиком, без таблицы НОП
+	ops := diffWords(oldText, newText)
+
+	want := []DiffOp{
+		{Op: DiffEqual, Text: "начало "},
+		{Op: DiffDelete, Text: strings.Repeat("a ", 1999) + "a"},
+		{Op: DiffInsert, Text: strings.Repeat("b ", 1999) + "b"},
+		{Op: DiffEqual, Text: " конец"},
+	}
+	if !slices.Equal(ops, want) {
+		t.Fatalf("expected middle replaced as a whole, got %d ops", len(ops))
+	}
+}
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/UnendingLoop/CommentTree/internal/model"
+	"github.com/UnendingLoop/CommentTree/internal/mwlogger"
+	"github.com/UnendingLoop/CommentTree/internal/repository"
+)
+
+// initialStatus - статус нового комментария: в премодерируемом потоке (или при включённой премодерации всего развёртывания)
+// комментарий ждёт решения, если его пишет не модератор
+func (c CService) initialStatus(ctx context.Context, thread string) (string, error) {
+	if _, err := requireModerator(ctx); err == nil {
+		return model.StatusApproved, nil
+	}
+	if c.premoderation {
+		return model.StatusPending, nil
+	}
+
+	on, err := c.repo.IsThreadPremoderated(ctx, thread)
+	if err != nil {
+		return "", err
+	}
+	if on {
+		return model.StatusPending, nil
+	}
+	return model.StatusApproved, nil
+}
+
+func (c CService) GetModerationQueue(ctx context.Context, req *model.ModerationRequest) ([]APPComment, error) {
+	logger := mwlogger.LoggerFromContext(ctx)
+	if _, err := requireModerator(ctx); err != nil {
+		return nil, err
+	}
+	if req.Page < 1 {
+		req.Page = 1
+	}
+	if req.Limit < 1 || req.Limit > 100 {
+		req.Limit = 20
+	}
+	if req.Thread != "" {
+		thread, err := normalizeThread(req.Thread)
+		if err != nil {
+			return nil, err
+		}
+		req.Thread = thread
+	}
+
+	res, err := c.repo.GetModerationQueue(ctx, req.Thread, req.Limit, (req.Page-1)*req.Limit)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to fetch moderation queue from DB")
+		return nil, ErrCommon500
+	}
+	queue := convertFlatList(res)
+	for i := range queue { // оценку классификатора видит только модератор
+		queue[i].SpamScore = res[i].SpamScore
+	}
+	return queue, nil
+}
+
+// ModerateComment одобряет (approved) или отклоняет (rejected) комментарий; для отказа причина обязательна.
+// Решение можно пересмотреть: отклонить ранее одобренный комментарий или одобрить отклонённый
+func (c CService) ModerateComment(ctx context.Context, id int, status string, data *model.CommentModerationData) (*APPComment, error) {
+	logger := mwlogger.LoggerFromContext(ctx)
+	if id <= 0 {
+		return nil, ErrIncorrectID
+	}
+	moderator, err := requireModerator(ctx)
+	if err != nil {
+		return nil, err
+	}
+	data.Reason = strings.TrimSpace(data.Reason)
+	if (status == model.StatusRejected && data.Reason == "") || utf8.RuneCountInString(data.Reason) > 500 {
+		return nil, ErrBadReason
+	}
+
+	res, err := c.repo.SetModeration(ctx, id, status, data.Reason, moderator)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrCommentNotFound):
+			return nil, err
+		default:
+			logger.Error().Err(err).Msg(fmt.Sprintf("Failed to set moderation status %q for comment %d", status, id))
+			return nil, ErrCommon500
+		}
+	}
+
+	label := model.HamLabel
+	if status == model.StatusRejected {
+		label = model.SpamLabel
+	}
+	c.trainSpam(ctx, res, label)
+
+	logger.Info().Msg(fmt.Sprintf("Comment %d %s by %q", id, status, moderator))
+	return convertToAPPComment(res), nil
+}
+
+func (c CService) SetThreadPremoderation(ctx context.Context, key string, on bool) (*APPThread, error) {
+	logger := mwlogger.LoggerFromContext(ctx)
+	key, err := normalizeThread(key)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := requireModerator(ctx); err != nil {
+		return nil, err
+	}
+
+	res, err := c.repo.SetThreadPremoderation(ctx, key, on)
+	if err != nil {
+		logger.Error().Err(err).Msg(fmt.Sprintf("Failed to set premoderation=%v on thread %q", on, key))
+		return nil, ErrCommon500
+	}
+
+	logger.Info().Msg(fmt.Sprintf("Thread %q premoderation set to %v", key, on))
+	return convertToAPPThread(res), nil
+}
@@ -0,0 +1,144 @@
+package service
+
+import (
+	"context"
+	"slices"
+
+	"github.com/UnendingLoop/CommentTree/internal/auth"
+	"github.com/UnendingLoop/CommentTree/internal/model"
+	"github.com/UnendingLoop/CommentTree/internal/mwapikey"
+	"github.com/UnendingLoop/CommentTree/internal/mwauth"
+)
+
+// requireUser - действие доступно только вошедшему пользователю; ключ интеграции без нужного права - это отказ, а не отсутствие входа
+func requireUser(ctx context.Context) (*auth.Claims, error) {
+	user, ok := mwauth.UserFromContext(ctx)
+	if !ok {
+		if _, isKey := mwapikey.APIKeyFromContext(ctx); isKey {
+			return nil, ErrForbidden
+		}
+		return nil, ErrUnauthorized
+	}
+	return user, nil
+}
+
+// requireRole - действие доступно только пользователю с одной из ролей
+func requireRole(ctx context.Context, roles ...string) (*auth.Claims, error) {
+	user, err := requireUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(roles, user.Role) {
+		return nil, ErrForbidden
+	}
+	return user, nil
+}
+
+// hasScope - запрос сделан ключом интеграции с этим правом
+func hasScope(ctx context.Context, scope string) bool {
+	key, ok := mwapikey.APIKeyFromContext(ctx)
+	return ok && slices.Contains(key.Scopes, scope)
+}
+
+// requireModerator - действия модерации доступны модератору, админу и ключу с правом moderation; возвращает имя действующего
+func requireModerator(ctx context.Context) (string, error) {
+	if key, ok := mwapikey.APIKeyFromContext(ctx); ok && hasScope(ctx, model.ScopeModeration) {
+		return key.Name, nil
+	}
+	user, err := requireRole(ctx, model.RoleModerator, model.RoleAdmin)
+	if err != nil {
+		return "", err
+	}
+	return user.Name, nil
+}
+
+// requireCommentUser - как requireUser, но неподошедший секрет правки - это отказ в доступе, а не отсутствие входа
+func requireCommentUser(ctx context.Context) (*auth.Claims, error) {
+	user, err := requireUser(ctx)
+	if err != nil && mwauth.EditTokenFromContext(ctx) != "" {
+		return nil, ErrForbidden
+	}
+	return user, err
+}
+
+// isOwner - комментарий написан этим пользователем; анонимные комментарии не принадлежат никому
+func isOwner(user *auth.Claims, comment *model.DBComment) bool {
+	return comment.AuthorID != nil && *comment.AuthorID == user.UserID
+}
+
+// hasEditToken - запрос несёт секрет, выданный при создании этого анонимного комментария
+func hasEditToken(ctx context.Context, comment *model.DBComment) bool {
+	token := mwauth.EditTokenFromContext(ctx)
+	return token != "" && auth.CheckSecret(comment.EditHash, token)
+}
+
+// isAuthor - запрос от автора комментария: вошедшего владельца или держателя секрета правки
+func isAuthor(ctx context.Context, comment *model.DBComment) bool {
+	if hasEditToken(ctx, comment) {
+		return true
+	}
+	user, ok := mwauth.UserFromContext(ctx)
+	return ok && isOwner(user, comment)
+}
+
+// canEdit: текст правит автор или владелец секрета анонимного комментария
+func canEdit(ctx context.Context, comment *model.DBComment) error {
+	if hasEditToken(ctx, comment) {
+		return nil
+	}
+	user, err := requireCommentUser(ctx)
+	if err != nil {
+		return err
+	}
+	if !isOwner(user, comment) {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// canDelete: навсегда удаляет только админ или ключ с правом comments:delete,
+// скрыть чужой комментарий может модератор, свой - автор или владелец секрета
+func canDelete(ctx context.Context, comment *model.DBComment, isSoftDelete bool) error {
+	if (isSoftDelete && hasEditToken(ctx, comment)) || hasScope(ctx, model.ScopeCommentsDelete) {
+		return nil
+	}
+	user, err := requireCommentUser(ctx)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case user.Role == model.RoleAdmin:
+		return nil
+	case !isSoftDelete:
+		return ErrForbidden
+	case user.Role == model.RoleModerator, isOwner(user, comment):
+		return nil
+	default:
+		return ErrForbidden
+	}
+}
+
+// isRemoved - комментарий мягко удалён или скрыт по жалобам: вместо текста показывается заглушка,
+// отвечать на него, править его и голосовать за него нельзя
+func isRemoved(comment *model.DBComment) bool {
+	return comment.DeletedAt != nil || comment.HiddenAt != nil
+}
+
+// viewerID - вошедший пользователь, которому в выдаче видны его непроверенные комментарии; 0 - аноним
+func viewerID(ctx context.Context) int {
+	if user, ok := mwauth.UserFromContext(ctx); ok {
+		return user.UserID
+	}
+	return 0
+}
+
+// isVisible - комментарий виден в публичной выдаче: одобрен или его смотрит автор - вошедший владелец
+// или гость с секретом правки. Выборки списков знают только вошедшего зрителя (visibleTo), поэтому гость
+// видит свой непроверенный комментарий лишь при запросе его самого по id
+func isVisible(ctx context.Context, comment *model.DBComment) bool {
+	if comment.Status == "" || comment.Status == model.StatusApproved {
+		return true
+	}
+	return isAuthor(ctx, comment)
+}
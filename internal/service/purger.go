@@ -0,0 +1,146 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/UnendingLoop/CommentTree/internal/mwlogger"
+	"github.com/UnendingLoop/CommentTree/internal/repository"
+)
+
+// PurgeStatus - состояние фоновой очистки мягко удалённых комментариев
+type PurgeStatus struct {
+	Enabled        bool       `json:"enabled"`
+	Interval       string     `json:"interval"`
+	Retention      string     `json:"retention"`
+	Running        bool       `json:"running"`
+	LastRunAt      *time.Time `json:"last_run_at,omitempty"`
+	NextRunAt      *time.Time `json:"next_run_at,omitempty"`
+	LastPurged     int        `json:"last_purged"`    // удалено целиком мёртвых комментариев за последний прогон
+	LastCollapsed  int        `json:"last_collapsed"` // убрано звеньев из цепочек надгробий за последний прогон
+	TotalPurged    int        `json:"total_purged"`
+	TotalCollapsed int        `json:"total_collapsed"`
+	LastError      string     `json:"last_error,omitempty"`
+}
+
+type PurgeReporter interface {
+	Status(ctx context.Context) (PurgeStatus, error)
+}
+
+// Purger по расписанию окончательно удаляет комментарии, мягко удалённые дольше retention
+type Purger struct {
+	repo      repository.CommentRepository
+	interval  time.Duration
+	retention time.Duration
+
+	mu     sync.Mutex
+	status PurgeStatus
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewPurger - interval <= 0 отключает очистку
+func NewPurger(repo repository.CommentRepository, interval, retention time.Duration) *Purger {
+	return &Purger{
+		repo:      repo,
+		interval:  interval,
+		retention: retention,
+		status: PurgeStatus{
+			Enabled:   interval > 0,
+			Interval:  interval.String(),
+			Retention: retention.String(),
+		},
+	}
+}
+
+// Start запускает фоновый цикл очистки; остановка - через Stop
+func (p *Purger) Start() {
+	if p.interval <= 0 {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+	p.done = make(chan struct{})
+	p.scheduleNext()
+
+	go func() {
+		defer close(p.done)
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				_ = p.RunOnce(ctx) // ошибка уже в логах и в статусе
+				p.scheduleNext()
+			}
+		}
+	}()
+}
+
+// Stop прерывает текущий прогон и дожидается завершения цикла
+func (p *Purger) Stop() {
+	if p.cancel == nil {
+		return
+	}
+	p.cancel()
+	<-p.done
+}
+
+// RunOnce выполняет один прогон очистки и обновляет статус
+func (p *Purger) RunOnce(ctx context.Context) error {
+	logger := mwlogger.LoggerFromContext(ctx)
+	p.mu.Lock()
+	p.status.Running = true
+	p.mu.Unlock()
+
+	startedAt := time.Now().UTC()
+	purged, collapsed, err := p.repo.PurgeDeleted(ctx, startedAt.Add(-p.retention))
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.status.Running = false
+	p.status.LastRunAt = &startedAt
+	if err != nil {
+		p.status.LastError = err.Error()
+		logger.Error().Err(err).Msg("Failed to purge soft-deleted comments")
+		return err
+	}
+
+	p.status.LastError = ""
+	p.status.LastPurged, p.status.LastCollapsed = purged, collapsed
+	p.status.TotalPurged += purged
+	p.status.TotalCollapsed += collapsed
+	logger.Info().
+		Int("purged", purged).
+		Int("collapsed", collapsed).
+		Dur("took", time.Since(startedAt)).
+		Msg("Soft-deleted comments purged")
+	return nil
+}
+
+// Status - итоги прогонов для модераторов и админов: в них видно, сколько мягко удалённого удаляется и как часто
+func (p *Purger) Status(ctx context.Context) (PurgeStatus, error) {
+	if _, err := requireModerator(ctx); err != nil {
+		return PurgeStatus{}, err
+	}
+	return p.snapshot(), nil
+}
+
+func (p *Purger) snapshot() PurgeStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.status
+}
+
+func (p *Purger) scheduleNext() {
+	next := time.Now().UTC().Add(p.interval)
+	p.mu.Lock()
+	p.status.NextRunAt = &next
+	p.mu.Unlock()
+}
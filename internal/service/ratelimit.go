@@ -0,0 +1,76 @@
+package service
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/UnendingLoop/CommentTree/internal/mwapikey"
+	"github.com/UnendingLoop/CommentTree/internal/mwauth"
+	"github.com/UnendingLoop/CommentTree/internal/mwclient"
+	"github.com/UnendingLoop/CommentTree/internal/mwlogger"
+	"github.com/UnendingLoop/CommentTree/internal/ratelimit"
+)
+
+// RateLimitConfig - лимиты создания комментариев; без Store лимиты выключены, правило с нулевым Limit не действует
+type RateLimitConfig struct {
+	Store  ratelimit.Store
+	Author ratelimit.Rule // на вошедшего пользователя; гостя ограничивает только IP - X-Client-Id он сменит в каждом запросе
+	IP     ratelimit.Rule
+	Thread ratelimit.Rule // на поток целиком - против наплыва в одно обсуждение
+}
+
+// RateLimitError - лимит исчерпан, повторить можно через RetryAfter
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return ErrRateLimited.Error()
+}
+
+func (e *RateLimitError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+// checkRate списывает по жетону из вёдер автора, IP и потока. Модераторы и интеграции по ключу не ограничиваются.
+// Недоступное хранилище лимитов не должно останавливать комментарии - такой запрос пропускается
+func (c CService) checkRate(ctx context.Context, thread string) error {
+	if c.limits.Store == nil {
+		return nil
+	}
+	if _, err := requireModerator(ctx); err == nil {
+		return nil
+	}
+	if _, ok := mwapikey.APIKeyFromContext(ctx); ok {
+		return nil
+	}
+
+	var buckets []ratelimit.Bucket
+	add := func(key string, rule ratelimit.Rule) {
+		if key != "" && rule.Limit > 0 {
+			buckets = append(buckets, ratelimit.Bucket{Key: key, Rule: rule})
+		}
+	}
+	if user, ok := mwauth.UserFromContext(ctx); ok {
+		add("author:user:"+strconv.Itoa(user.UserID), c.limits.Author)
+	}
+	if ip := mwclient.IPFromContext(ctx); ip != "" {
+		add("ip:"+ip, c.limits.IP)
+	}
+	add("thread:"+thread, c.limits.Thread)
+	if len(buckets) == 0 {
+		return nil
+	}
+
+	logger := mwlogger.LoggerFromContext(ctx)
+	retryAfter, err := c.limits.Store.Take(ctx, buckets)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to check comment rate limits, request is let through")
+		return nil
+	}
+	if retryAfter > 0 {
+		return &RateLimitError{RetryAfter: retryAfter}
+	}
+	return nil
+}
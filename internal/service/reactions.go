@@ -0,0 +1,73 @@
+package service
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/UnendingLoop/CommentTree/internal/mwclient"
+)
+
+// DefaultReactions - набор реакций, если в конфиге он не задан
+var DefaultReactions = []string{"👍", "❤️", "😂", "😮", "😢", "🎉"}
+
+// APPReaction - количество реакций одного вида на комментарий
+type APPReaction struct {
+	Emoji string `json:"emoji"`
+	Count int    `json:"count"`
+	Mine  bool   `json:"reacted_by_me,omitempty"`
+}
+
+// normalizeReactions чистит набор из конфига от пробелов и повторов, сохраняя порядок - в нём реакции и отдаются
+func normalizeReactions(raw []string) map[string]int {
+	order := make(map[string]int, len(raw))
+	for _, emoji := range raw {
+		emoji = strings.TrimSpace(emoji)
+		if _, ok := order[emoji]; emoji != "" && !ok {
+			order[emoji] = len(order)
+		}
+	}
+	if len(order) == 0 {
+		return normalizeReactions(DefaultReactions)
+	}
+	return order
+}
+
+// attachReactions проставляет реакции всем узлам деревьев одним запросом в repository;
+// реакции, убранные из набора в конфиге, не отдаются
+func (c CService) attachReactions(ctx context.Context, items []APPComment) error {
+	index := make(map[int]*APPComment)
+	var walk func(node *APPComment)
+	walk = func(node *APPComment) {
+		index[node.ID] = node
+		for _, child := range node.Children {
+			walk(child)
+		}
+	}
+	for i := range items {
+		walk(&items[i])
+	}
+	if len(index) == 0 {
+		return nil
+	}
+
+	res, err := c.repo.GetReactions(ctx, slices.Collect(maps.Keys(index)), mwclient.ClientFromContext(ctx))
+	if err != nil {
+		return err
+	}
+
+	for _, r := range res {
+		node, ok := index[r.CommentID]
+		if _, allowed := c.reactions[r.Emoji]; !ok || !allowed {
+			continue
+		}
+		node.Reactions = append(node.Reactions, APPReaction{Emoji: r.Emoji, Count: r.Count, Mine: r.Mine})
+	}
+	for _, node := range index {
+		slices.SortFunc(node.Reactions, func(a, b APPReaction) int {
+			return c.reactions[a.Emoji] - c.reactions[b.Emoji]
+		})
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/UnendingLoop/CommentTree/internal/model"
+	"github.com/UnendingLoop/CommentTree/internal/mwapikey"
+	"github.com/UnendingLoop/CommentTree/internal/mwlogger"
+	"github.com/UnendingLoop/CommentTree/internal/repository"
+)
+
+// DefaultReportThreshold - после стольких открытых жалоб комментарий скрывается до решения модератора
+const DefaultReportThreshold = 5
+
+var reportReasons = []string{model.ReportSpam, model.ReportAbuse, model.ReportHarassment, model.ReportOffTopic, model.ReportOther}
+
+// APPReport - ответ на жалобу
+type APPReport struct {
+	CommentID int    `json:"comment_id"`
+	Reason    string `json:"reason"`
+	Reports   int    `json:"reports"` // открытых жалоб на комментарий, включая эту
+	Hidden    bool   `json:"hidden"`  // комментарий скрыт, в том числе этой жалобой
+}
+
+// APPReportGroup - открытые жалобы на комментарий в списке модератора
+type APPReportGroup struct {
+	Comment APPComment     `json:"comment"`
+	Reports int            `json:"reports"`
+	Reasons map[string]int `json:"reasons"` // сколько жалоб каждой категории
+	Details []string       `json:"details,omitempty"`
+	FirstAt time.Time      `json:"first_reported_at"`
+	LastAt  time.Time      `json:"last_reported_at"`
+}
+
+// APPReportResolution - итог решения модератора
+type APPReportResolution struct {
+	CommentID int    `json:"comment_id"`
+	Action    string `json:"action"`
+	Resolved  int    `json:"resolved"` // сколько жалоб закрыто
+}
+
+func (c CService) ReportComment(ctx context.Context, id int, data *model.CommentReportData) (*APPReport, error) {
+	logger := mwlogger.LoggerFromContext(ctx)
+	if id <= 0 {
+		return nil, ErrIncorrectID
+	}
+	data.Details = strings.TrimSpace(data.Details)
+	if !slices.Contains(reportReasons, data.Reason) || utf8.RuneCountInString(data.Details) > 500 {
+		return nil, ErrBadReport
+	}
+	// жалобы принимаются только от вошедших пользователей и ключей: X-Client-Id анонима легко подменить,
+	// и одному человеку хватило бы запросов, чтобы набрать порог скрытия
+	reporter, err := reporterID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := c.repo.GetCommentByID(ctx, id)
+	if err != nil {
+		switch {
+		case !errors.Is(err, repository.ErrCommentNotFound):
+			logger.Error().Err(err).Msg("Failed to check comment existence before reporting")
+			return nil, ErrCommon500
+		default:
+			return nil, err
+		}
+	}
+	if !isVisible(ctx, current) {
+		return nil, repository.ErrCommentNotFound
+	}
+	if isRemoved(current) {
+		return nil, ErrCommentDeleted
+	}
+
+	open, err := c.repo.AddReport(ctx, id, reporter, data)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrCommentNotFound):
+			return nil, err
+		default:
+			logger.Error().Err(err).Msg(fmt.Sprintf("Failed to save report on comment %d", id))
+			return nil, ErrCommon500
+		}
+	}
+
+	// набралось достаточно жалоб - скрываем до решения модератора: dismiss вернёт комментарий в выдачу
+	hidden := c.reportThreshold > 0 && open >= c.reportThreshold
+	if hidden {
+		if err := c.repo.SetHidden(ctx, id, true); err != nil {
+			logger.Error().Err(err).Msg(fmt.Sprintf("Failed to hide comment %d after %d reports", id, open))
+			return nil, ErrCommon500
+		}
+		logger.Info().Msg(fmt.Sprintf("Comment %d hidden after %d reports", id, open))
+	}
+
+	return &APPReport{CommentID: id, Reason: data.Reason, Reports: open, Hidden: hidden}, nil
+}
+
+// reporterID - идентификатор подающего жалобу: вошедший пользователь или ключ интеграции
+func reporterID(ctx context.Context) (string, error) {
+	if key, ok := mwapikey.APIKeyFromContext(ctx); ok {
+		return "key:" + strconv.Itoa(key.ID), nil
+	}
+	user, err := requireUser(ctx)
+	if err != nil {
+		return "", err
+	}
+	return "user:" + strconv.Itoa(user.UserID), nil
+}
+
+func (c CService) GetReports(ctx context.Context, req *model.ReportListRequest) ([]APPReportGroup, error) {
+	logger := mwlogger.LoggerFromContext(ctx)
+	if _, err := requireModerator(ctx); err != nil {
+		return nil, err
+	}
+	if req.Page < 1 {
+		req.Page = 1
+	}
+	if req.Limit < 1 || req.Limit > 100 {
+		req.Limit = 20
+	}
+
+	groups, err := c.repo.GetReportGroups(ctx, req.Limit, (req.Page-1)*req.Limit)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to fetch reported comments from DB")
+		return nil, ErrCommon500
+	}
+
+	res := make([]APPReportGroup, 0, len(groups))
+	for _, g := range groups {
+		reasons := make(map[string]int, len(reportReasons))
+		for _, r := range g.Reasons {
+			reasons[r]++
+		}
+		// модератору нужен исходный текст, даже если комментарий уже скрыт жалобами
+		comment := convertToAPPComment(&g.Comment)
+		comment.Text = g.Comment.Text
+		comment.SpamScore = g.Comment.SpamScore
+		res = append(res, APPReportGroup{
+			Comment: *comment,
+			Reports: g.Count,
+			Reasons: reasons,
+			Details: g.Details,
+			FirstAt: g.FirstAt,
+			LastAt:  g.LastAt,
+		})
+	}
+	return res, nil
+}
+
+// ResolveReports закрывает все открытые жалобы на комментарий: dismiss - оставить и снять скрытие,
+// hide - оставить скрытым, delete - удалить навсегда (только админ, как и обычное удаление).
+// Скрытие снимается dismiss и тогда, когда жалобы уже закрыты прежним hide
+func (c CService) ResolveReports(ctx context.Context, id int, data *model.ReportResolveData) (*APPReportResolution, error) {
+	logger := mwlogger.LoggerFromContext(ctx)
+	if id <= 0 {
+		return nil, ErrIncorrectID
+	}
+	moderator, err := requireModerator(ctx)
+	if err != nil {
+		return nil, err
+	}
+	switch data.Action {
+	case model.ResolveDismiss, model.ResolveHide, model.ResolveDelete:
+	default:
+		return nil, ErrBadResolution
+	}
+
+	current, err := c.repo.GetCommentByID(ctx, id)
+	if err != nil {
+		switch {
+		case !errors.Is(err, repository.ErrCommentNotFound):
+			logger.Error().Err(err).Msg("Failed to check comment existence before resolving reports")
+			return nil, ErrCommon500
+		default:
+			return nil, err
+		}
+	}
+	if data.Action != model.ResolveDismiss {
+		if err := canDelete(ctx, current, data.Action == model.ResolveHide); err != nil {
+			return nil, err
+		}
+	}
+
+	// жалобы закрываются и решение применяется в одной транзакции: сбой не оставит закрытых жалоб без действия
+	resolved, err := c.repo.ResolveReports(ctx, id, data.Action, moderator)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrNoOpenReports):
+			return nil, err
+		default:
+			logger.Error().Err(err).Msg(fmt.Sprintf("Failed to resolve reports on comment %d", id))
+			return nil, ErrCommon500
+		}
+	}
+
+	// удаление по жалобам - такой же сигнал спама, как обычное удаление чужого комментария модерацией
+	if data.Action == model.ResolveDelete && !isAuthor(ctx, current) {
+		c.trainSpam(ctx, current, model.SpamLabel)
+	}
+
+	logger.Info().Msg(fmt.Sprintf("%d reports on comment %d resolved with %q by %q", resolved, id, data.Action, moderator))
+	return &APPReportResolution{CommentID: id, Action: data.Action, Resolved: resolved}, nil
+}
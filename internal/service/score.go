@@ -0,0 +1,29 @@
+package service
+
+import (
+	"cmp"
+	"math"
+)
+
+// hotEpoch и hotDecay должны совпадать с выражением сортировки hot в repository:
+// каждые 45000 секунд (12.5 часов) свежести весят как десятикратный рейтинг
+const (
+	hotEpoch = 1134028003
+	hotDecay = 45000
+)
+
+// controversy - спорность: растёт с числом голосов и максимальна при равенстве за и против
+func controversy(c *APPComment) float64 {
+	if c.Upvotes == 0 || c.Downvotes == 0 {
+		return 0
+	}
+	balance := float64(min(c.Upvotes, c.Downvotes)) / float64(max(c.Upvotes, c.Downvotes))
+	return math.Pow(float64(c.Upvotes+c.Downvotes), balance)
+}
+
+// hotness - рейтинг с поправкой на возраст: новые комментарии поднимаются выше старых с тем же счётом
+func hotness(c *APPComment) float64 {
+	sign := float64(cmp.Compare(c.Score, 0))
+	order := math.Log10(math.Max(math.Abs(float64(c.Score)), 1))
+	return sign*order + float64(c.CreatedAt.Unix()-hotEpoch)/hotDecay
+}
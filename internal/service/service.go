@@ -3,11 +3,18 @@ package service
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"slices"
 	"strings"
+	"unicode"
 
+	"github.com/UnendingLoop/CommentTree/internal/auth"
 	"github.com/UnendingLoop/CommentTree/internal/model"
+	"github.com/UnendingLoop/CommentTree/internal/mwapikey"
+	"github.com/UnendingLoop/CommentTree/internal/mwauth"
+	"github.com/UnendingLoop/CommentTree/internal/mwclient"
 	"github.com/UnendingLoop/CommentTree/internal/mwlogger"
 	"github.com/UnendingLoop/CommentTree/internal/repository"
 )
@@ -18,26 +25,114 @@ var (
 	ErrParentNotFound error = errors.New("specified parent comment ID not found") // 404
 	ErrParentDeleted  error = errors.New("specified parent ID is deleted")        // 422
 	ErrIncorrectID    error = errors.New("incorrect comment ID")                  // 422
+	ErrInvalidCursor  error = errors.New("invalid pagination cursor")             // 400
+	ErrEmptyContent   error = errors.New("comment content is empty")              // 400
+	ErrCommentDeleted error = errors.New("specified comment is deleted")          // 409
+	ErrNotDeleted     error = errors.New("specified comment is not deleted")      // 409
+	ErrMoveIntoItself error = errors.New("can't move comment into own subtree")   // 422
+	ErrInvalidThread  error = errors.New("incorrect thread key")                  // 400
+	ErrThreadMismatch error = errors.New("reply must stay in parent's thread")    // 422
+	ErrReplyLocked    error = errors.New("replies to this branch are locked")     // 423
+	ErrThreadLocked   error = errors.New("thread is locked")                      // 423
+	ErrPinNotRoot     error = errors.New("only root comments can be pinned")      // 422
+	ErrInvalidVote    error = errors.New("incorrect voter or vote value")         // 400
+	ErrBadReaction    error = errors.New("reaction is not allowed")               // 400
+	ErrNoClientID     error = errors.New("client identity is required")           // 400
+	ErrInvalidUser    error = errors.New("incorrect username or password format") // 400
+	ErrBadCredentials error = errors.New("invalid username or password")          // 401
+	ErrUnauthorized   error = errors.New("authentication required")               // 401
+	ErrForbidden      error = errors.New("not enough rights for this action")     // 403
+	ErrInvalidRole    error = errors.New("unknown user role")                     // 400
+	ErrInvalidAPIKey  error = errors.New("incorrect API key name or scopes")      // 400
+	ErrBadReason      error = errors.New("incorrect moderation reason")           // 400
+	ErrBadReport      error = errors.New("incorrect report reason or details")    // 400
+	ErrBadResolution  error = errors.New("unknown report resolution action")      // 400
+	ErrFiltered       error = errors.New("comment rejected by content filter")    // 422
+	ErrRateLimited    error = errors.New("too many comments, try again later")    // 429
 )
 
 type CommentService interface {
 	CreateComment(ctx context.Context, comment *model.CommentCreateData) (*APPComment, error)
-	GetAllRootComments(ctx context.Context, req *model.RootRequest) ([]APPComment, error)
-	GetCommentWithChildren(ctx context.Context, id int) ([]APPComment, error)
+	GetAllRootComments(ctx context.Context, req *model.RootRequest) (*CommentPage, error)
+	GetChildComments(ctx context.Context, id int, req *model.RootRequest) (*CommentPage, error)
+	GetCommentWithChildren(ctx context.Context, id int, req *model.TreeRequest) ([]APPComment, error)
+	GetAncestors(ctx context.Context, id int) ([]APPComment, error)
+	EditComment(ctx context.Context, id int, data *model.CommentEditData) (*APPComment, error)
+	GetCommentRevisions(ctx context.Context, id int) ([]APPRevision, error)
 	DeleteCommentByID(ctx context.Context, id int, isSoftDelete bool) error
+	RestoreComment(ctx context.Context, id int) (*APPComment, error)
+	MoveComment(ctx context.Context, id int, data *model.CommentMoveData) (*APPComment, error)
+	VoteComment(ctx context.Context, id int, data *model.CommentVoteData) (*APPComment, error)
+	SetReaction(ctx context.Context, id int, emoji string, on bool) (*APPComment, error)
+	SetCommentLock(ctx context.Context, id int, locked bool) (*APPComment, error)
+	SetCommentPin(ctx context.Context, id int, pinned bool) (*APPComment, error)
+	SetThreadLock(ctx context.Context, key string, locked bool) (*APPThread, error)
+	SetThreadPremoderation(ctx context.Context, key string, on bool) (*APPThread, error)
+	GetModerationQueue(ctx context.Context, req *model.ModerationRequest) ([]APPComment, error)
+	ModerateComment(ctx context.Context, id int, status string, data *model.CommentModerationData) (*APPComment, error)
+	ReportComment(ctx context.Context, id int, data *model.CommentReportData) (*APPReport, error)
+	GetReports(ctx context.Context, req *model.ReportListRequest) ([]APPReportGroup, error)
+	ResolveReports(ctx context.Context, id int, data *model.ReportResolveData) (*APPReportResolution, error)
 	RunCommentSearchQuery(ctx context.Context, query string) ([]APPComment, error)
 }
 
 type CService struct {
-	repo repository.CommentRepository
+	repo            repository.CommentRepository
+	reactions       map[string]int // допустимые реакции и их порядок в выдаче
+	premoderation   bool           // премодерация во всех потоках, иначе - только в отмеченных
+	reportThreshold int            // после стольких открытых жалоб комментарий скрывается, 0 - не скрывать
+	filters         []ContentFilter
+	spam            SpamConfig
+	limits          RateLimitConfig
 }
 
-func NewCommentService(commentRep repository.CommentRepository) CommentService {
-	return &CService{repo: commentRep}
+// Config - настройки сервиса из конфига приложения; пустые поля заменяются значениями по умолчанию
+type Config struct {
+	Reactions       []string
+	Premoderation   bool
+	ReportThreshold int             // 0 - автоскрытие выключено
+	Filters         []ContentFilter // проверки текста перед созданием и правкой, см. NewContentFilters
+	Spam            SpamConfig
+	RateLimit       RateLimitConfig
+}
+
+func NewCommentService(commentRep repository.CommentRepository, cfg Config) CommentService {
+	return &CService{
+		repo:            commentRep,
+		reactions:       normalizeReactions(cfg.Reactions),
+		premoderation:   cfg.Premoderation,
+		reportThreshold: cfg.ReportThreshold,
+		filters:         cfg.Filters,
+		spam:            cfg.Spam,
+		limits:          cfg.RateLimit,
+	}
 }
 
 func (c CService) CreateComment(ctx context.Context, comment *model.CommentCreateData) (*APPComment, error) {
 	logger := mwlogger.LoggerFromContext(ctx)
+	// вошедший пользователь и ключ подписываются своим именем, имя из запроса не принимаем, чтобы нельзя было
+	// выдать себя за другого; гость подписывается как хочет - в выдаче такое имя помечается непроверенным
+	comment.Author, comment.AuthorID = strings.TrimSpace(comment.Author), nil
+	key, byKey := mwapikey.APIKeyFromContext(ctx)
+	if user, ok := mwauth.UserFromContext(ctx); ok {
+		comment.Author, comment.AuthorID = user.Name, &user.UserID
+	} else if byKey { // системный комментарий интеграции подписывается именем ключа
+		if !slices.Contains(key.Scopes, model.ScopeCommentsWrite) {
+			return nil, ErrForbidden
+		}
+		comment.Author = key.Name
+	}
+
+	text, flagged, err := c.filterText(comment.Text)
+	if err != nil {
+		return nil, err
+	}
+	comment.Text = text
+
+	score, suspicious := c.checkSpam(ctx, spamTokens(text, comment.AuthorID, byKey, comment.ParentID != nil))
+	comment.SpamScore = score
+	flagged = joinReasons(flagged, suspicious)
+
 	// если указан родитель, проверяем его в базе
 	if comment.ParentID != nil {
 		parent, err := c.repo.GetCommentByID(ctx, *comment.ParentID)
@@ -51,9 +146,69 @@ func (c CService) CreateComment(ctx context.Context, comment *model.CommentCreat
 			}
 		}
 
-		if parent.DeletedAt != nil { // оставлять коммент мягко удаленному родителю запрещено
+		if !isVisible(ctx, parent) { // непроверенный комментарий для остальных не существует
+			return nil, ErrParentNotFound
+		}
+		if isRemoved(parent) { // оставлять коммент мягко удаленному или скрытому родителю запрещено
 			return nil, ErrParentDeleted
 		}
+
+		// ответ всегда живёт в потоке родителя
+		if comment.ThreadKey != "" && strings.TrimSpace(comment.ThreadKey) != parent.ThreadKey {
+			return nil, ErrThreadMismatch
+		}
+		comment.ThreadKey = parent.ThreadKey
+
+		locked, err := c.repo.IsBranchLocked(ctx, parent.ID)
+		if err != nil {
+			logger.Error().Err(err).Msg("Failed to check branch lock before creating new comment in DB")
+			return nil, ErrCommon500
+		}
+		if locked {
+			return nil, ErrReplyLocked
+		}
+	} else {
+		thread, err := normalizeThread(comment.ThreadKey)
+		if err != nil {
+			return nil, err
+		}
+		comment.ThreadKey = thread
+
+		locked, err := c.repo.IsThreadLocked(ctx, thread)
+		if err != nil {
+			logger.Error().Err(err).Msg("Failed to check thread lock before creating new comment in DB")
+			return nil, ErrCommon500
+		}
+		if locked {
+			return nil, ErrThreadLocked
+		}
+	}
+
+	// лимит проверяется последним, чтобы заведомо негодный запрос не тратил жетоны
+	if err := c.checkRate(ctx, comment.ThreadKey); err != nil {
+		return nil, err
+	}
+
+	status, err := c.initialStatus(ctx, comment.ThreadKey)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to check thread premoderation before creating new comment in DB")
+		return nil, ErrCommon500
+	}
+	comment.Status = status
+	// фильтр или классификатор засомневались - в очередь, причину увидят модератор и автор; модератору проверять самого себя незачем
+	if _, err := requireModerator(ctx); err != nil && flagged != "" {
+		comment.Status, comment.ModReason = model.StatusPending, flagged
+	}
+
+	// гость получает секрет для правки и скрытия своего комментария; в базе остаётся только хеш.
+	// Комментарий ключа интеграции управляется правами ключа, секрет ему не нужен
+	secret := ""
+	if comment.AuthorID == nil && !byKey {
+		if secret, err = auth.NewSecret(); err != nil {
+			logger.Error().Err(err).Msg("Failed to generate edit token for new comment")
+			return nil, ErrCommon500
+		}
+		comment.EditHash = auth.HashSecret(secret)
 	}
 
 	res, err := c.repo.Create(ctx, comment)
@@ -62,30 +217,145 @@ func (c CService) CreateComment(ctx context.Context, comment *model.CommentCreat
 		return nil, ErrCommon500
 	}
 
-	return convertToAPPComment(res), nil
+	created := convertToAPPComment(res)
+	created.EditToken = secret // отдаётся один раз, повторно получить его нельзя
+	return created, nil
 }
 
-func (c CService) GetAllRootComments(ctx context.Context, req *model.RootRequest) ([]APPComment, error) {
+func (c CService) GetAllRootComments(ctx context.Context, req *model.RootRequest) (*CommentPage, error) {
 	logger := mwlogger.LoggerFromContext(ctx)
 	validateRequest(req)
-	offset := (req.Page - 1) * req.Limit
+	thread, err := normalizeThread(req.Thread)
+	if err != nil {
+		return nil, err
+	}
+	req.Thread = thread
+	q, err := buildPageQuery(req)
+	if err != nil {
+		return nil, err
+	}
+	q.Viewer = viewerID(ctx)
 
-	res, err := c.repo.GetAllRoot(ctx, req.Limit, offset, req.Sort, req.Order)
+	res, err := c.repo.GetAllRoot(ctx, q)
 	if err != nil {
 		logger.Error().Err(err).Msg("Failed to fetch all root comments from DB")
 		return nil, ErrCommon500
 	}
+	res, next := cutPage(res, req)
+
+	// на первой странице закреплённые корни идут первыми независимо от сортировки
+	if req.Page == 1 && req.Cursor == "" {
+		pinned, err := c.repo.GetPinnedRoots(ctx, req.Thread, q.Viewer)
+		if err != nil {
+			logger.Error().Err(err).Msg("Failed to fetch pinned root comments from DB")
+			return nil, ErrCommon500
+		}
+		res = append(pinned, res...)
+	}
+
+	total := 0
+	if req.Envelope {
+		if total, err = c.repo.CountRoot(ctx, req.Thread, q.Viewer); err != nil {
+			logger.Error().Err(err).Msg("Failed to count root comments in DB")
+			return nil, ErrCommon500
+		}
+	}
+	if len(res) == 0 {
+		return &CommentPage{Items: []APPComment{}, Total: total}, nil
+	}
 
-	return compileToAPPCommentTree(res, nil), nil
+	threadLocked, err := c.repo.IsThreadLocked(ctx, req.Thread)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to check thread lock for root comments")
+		return nil, ErrCommon500
+	}
+
+	// подгружаем превью ответов одним запросом для всей страницы
+	rootIDs := make([]int, 0, len(res))
+	for _, root := range res {
+		rootIDs = append(rootIDs, root.ID)
+	}
+	replies, err := c.repo.GetRepliesPreview(ctx, rootIDs, req.Replies, req.Depth, q.Viewer)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to fetch replies preview for root comments from DB")
+		return nil, ErrCommon500
+	}
+
+	items := compileToAPPCommentTree(append(res, replies...), nil, model.SortOldest)
+	propagateLock(items, threadLocked)
+	if err := c.attachReactions(ctx, items); err != nil {
+		logger.Error().Err(err).Msg("Failed to fetch reactions for root comments from DB")
+		return nil, ErrCommon500
+	}
+
+	return &CommentPage{Items: items, NextCursor: next, Total: total}, nil
 }
 
-func (c CService) GetCommentWithChildren(ctx context.Context, id int) ([]APPComment, error) {
+func (c CService) GetChildComments(ctx context.Context, id int, req *model.RootRequest) (*CommentPage, error) {
 	logger := mwlogger.LoggerFromContext(ctx)
 	if id <= 0 {
 		return nil, ErrIncorrectID
 	}
+	validateRequest(req)
+	q, err := buildPageQuery(req)
+	if err != nil {
+		return nil, err
+	}
+	q.Viewer = viewerID(ctx)
+
 	// проверяем существует ли такой родитель
-	_, err := c.repo.GetCommentByID(ctx, id)
+	parent, err := c.repo.GetCommentByID(ctx, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrCommentNotFound):
+			return nil, ErrParentNotFound
+		default:
+			logger.Error().Err(err).Msg("Failed to check parent before fetching its direct children")
+			return nil, ErrCommon500
+		}
+	}
+	if !isVisible(ctx, parent) {
+		return nil, ErrParentNotFound
+	}
+
+	res, err := c.repo.GetChildren(ctx, id, q)
+	if err != nil {
+		logger.Error().Err(err).Msg(fmt.Sprintf("Failed to fetch direct children for comment %d from DB", id))
+		return nil, ErrCommon500
+	}
+	res, next := cutPage(res, req)
+
+	total := 0
+	if req.Envelope {
+		if total, err = c.repo.CountChildren(ctx, id, q.Viewer); err != nil {
+			logger.Error().Err(err).Msg(fmt.Sprintf("Failed to count direct children for comment %d in DB", id))
+			return nil, ErrCommon500
+		}
+	}
+
+	branchLocked, err := c.repo.IsBranchLocked(ctx, id)
+	if err != nil {
+		logger.Error().Err(err).Msg(fmt.Sprintf("Failed to check branch lock for comment %d", id))
+		return nil, ErrCommon500
+	}
+	items := convertFlatList(res)
+	propagateLock(items, branchLocked)
+	if err := c.attachReactions(ctx, items); err != nil {
+		logger.Error().Err(err).Msg(fmt.Sprintf("Failed to fetch reactions for children of comment %d from DB", id))
+		return nil, ErrCommon500
+	}
+
+	return &CommentPage{Items: items, NextCursor: next, Total: total}, nil
+}
+
+func (c CService) GetCommentWithChildren(ctx context.Context, id int, req *model.TreeRequest) ([]APPComment, error) {
+	logger := mwlogger.LoggerFromContext(ctx)
+	if id <= 0 {
+		return nil, ErrIncorrectID
+	}
+	validateTreeRequest(req)
+	// проверяем существует ли такой родитель
+	current, err := c.repo.GetCommentByID(ctx, id)
 	if err != nil {
 		switch {
 		case errors.Is(err, repository.ErrCommentNotFound):
@@ -95,14 +365,212 @@ func (c CService) GetCommentWithChildren(ctx context.Context, id int) ([]APPComm
 			return nil, ErrCommon500
 		}
 	}
+	if !isVisible(ctx, current) {
+		return nil, ErrParentNotFound
+	}
 
-	res, err := c.repo.GetCommentWithChildrenByID(ctx, id)
+	res, err := c.repo.GetCommentWithChildrenByID(ctx, id, req.Depth, viewerID(ctx))
 	if err != nil {
 		logger.Error().Err(err).Msg(fmt.Sprintf("Failed to fetch children for comment %q from DB", id))
 		return nil, ErrCommon500
 	}
 
-	return compileToAPPCommentTree(res, &id), nil
+	tree := compileToAPPCommentTree(res, &id, req.Sort)
+	if req.Context == 0 || len(tree) == 0 {
+		locked, err := c.lockedAbove(ctx, current)
+		if err != nil {
+			logger.Error().Err(err).Msg(fmt.Sprintf("Failed to check lock above comment %d", id))
+			return nil, ErrCommon500
+		}
+		propagateLock(tree, locked)
+		if err := c.attachReactions(ctx, tree); err != nil {
+			logger.Error().Err(err).Msg(fmt.Sprintf("Failed to fetch reactions for branch of comment %d from DB", id))
+			return nil, ErrCommon500
+		}
+		return tree, nil
+	}
+
+	// permalink-вид: показываем ветку внутри цепочки из req.Context предков
+	ancestors, err := c.repo.GetAncestorsByID(ctx, id, req.Context, viewerID(ctx))
+	if err != nil {
+		logger.Error().Err(err).Msg(fmt.Sprintf("Failed to fetch ancestors for comment %d from DB", id))
+		return nil, ErrCommon500
+	}
+
+	top := current
+	if len(ancestors) > 0 {
+		top = &ancestors[0]
+	}
+	locked, err := c.lockedAbove(ctx, top)
+	if err != nil {
+		logger.Error().Err(err).Msg(fmt.Sprintf("Failed to check lock above comment %d", top.ID))
+		return nil, ErrCommon500
+	}
+	wrapped := wrapWithAncestors(tree[0], ancestors)
+	propagateLock(wrapped, locked)
+	if err := c.attachReactions(ctx, wrapped); err != nil {
+		logger.Error().Err(err).Msg(fmt.Sprintf("Failed to fetch reactions for branch of comment %d from DB", id))
+		return nil, ErrCommon500
+	}
+
+	return wrapped, nil
+}
+
+func (c CService) GetAncestors(ctx context.Context, id int) ([]APPComment, error) {
+	logger := mwlogger.LoggerFromContext(ctx)
+	if id <= 0 {
+		return nil, ErrIncorrectID
+	}
+
+	// проверяем существует ли такой коммент
+	current, err := c.repo.GetCommentByID(ctx, id)
+	if err != nil {
+		switch {
+		case !errors.Is(err, repository.ErrCommentNotFound):
+			logger.Error().Err(err).Msg("Failed to check comment existence before fetching its ancestors")
+			return nil, ErrCommon500
+		default:
+			return nil, err
+		}
+	}
+	if !isVisible(ctx, current) {
+		return nil, repository.ErrCommentNotFound
+	}
+
+	res, err := c.repo.GetAncestorsByID(ctx, id, 0, viewerID(ctx))
+	if err != nil {
+		logger.Error().Err(err).Msg(fmt.Sprintf("Failed to fetch ancestors for comment %d from DB", id))
+		return nil, ErrCommon500
+	}
+	if len(res) == 0 {
+		return []APPComment{}, nil
+	}
+
+	// цепочка начинается с корня, поэтому выше неё может быть заблокирован только поток
+	locked, err := c.lockedAbove(ctx, &res[0])
+	if err != nil {
+		logger.Error().Err(err).Msg(fmt.Sprintf("Failed to check lock above ancestors of comment %d", id))
+		return nil, ErrCommon500
+	}
+	chain := convertFlatList(res)
+	propagateChainLock(chain, locked)
+	if err := c.attachReactions(ctx, chain); err != nil {
+		logger.Error().Err(err).Msg(fmt.Sprintf("Failed to fetch reactions for ancestors of comment %d from DB", id))
+		return nil, ErrCommon500
+	}
+
+	return chain, nil
+}
+
+func (c CService) EditComment(ctx context.Context, id int, data *model.CommentEditData) (*APPComment, error) {
+	logger := mwlogger.LoggerFromContext(ctx)
+	if id <= 0 {
+		return nil, ErrIncorrectID
+	}
+	if strings.TrimSpace(data.Text) == "" {
+		return nil, ErrEmptyContent
+	}
+	text, flagged, err := c.filterText(data.Text)
+	if err != nil {
+		return nil, err
+	}
+	data.Text = text
+
+	// проверяем существует ли такой коммент
+	current, err := c.repo.GetCommentByID(ctx, id)
+	if err != nil {
+		switch {
+		case !errors.Is(err, repository.ErrCommentNotFound):
+			logger.Error().Err(err).Msg("Failed to check comment existence before editing")
+			return nil, ErrCommon500
+		default:
+			return nil, err
+		}
+	}
+	if err := canEdit(ctx, current); err != nil {
+		return nil, err
+	}
+
+	if isRemoved(current) { // править мягко удаленный или скрытый коммент запрещено
+		return nil, ErrCommentDeleted
+	}
+	if current.Text == data.Text { // текст не изменился - новую версию не заводим
+		return convertToAPPComment(current), nil
+	}
+	score, suspicious := c.checkSpam(ctx, spamTokens(data.Text, current.AuthorID, isKeyComment(current), current.ParentID != nil))
+	flagged = joinReasons(flagged, suspicious)
+
+	// дифф считается один раз при правке и хранится вместе с заменённой версией
+	diff, err := json.Marshal(diffWords(current.Text, data.Text))
+	if err != nil {
+		logger.Error().Err(err).Msg(fmt.Sprintf("Failed to encode diff of comment %d, it will be computed on read", id))
+		diff = nil
+	}
+
+	res, err := c.repo.UpdateText(ctx, id, current.Text, data.Text, diff)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrCommentNotFound): // удалили между проверкой и правкой
+			return nil, err
+		default:
+			logger.Error().Err(err).Msg(fmt.Sprintf("Failed to update text of comment %d", id))
+			return nil, ErrCommon500
+		}
+	}
+
+	if score != nil {
+		if err := c.repo.SetSpamScore(ctx, id, *score); err != nil {
+			logger.Error().Err(err).Msg(fmt.Sprintf("Failed to save spam score of edited comment %d", id))
+			return nil, ErrCommon500
+		}
+		res.SpamScore = score
+	}
+
+	// одобренный комментарий после сомнительной правки снова ждёт проверки
+	if _, err := requireModerator(ctx); err != nil && flagged != "" && res.Status == model.StatusApproved {
+		if res, err = c.repo.SetModeration(ctx, id, model.StatusPending, flagged, ""); err != nil {
+			logger.Error().Err(err).Msg(fmt.Sprintf("Failed to send edited comment %d to moderation", id))
+			return nil, ErrCommon500
+		}
+	}
+
+	return convertToAPPComment(res), nil
+}
+
+func (c CService) GetCommentRevisions(ctx context.Context, id int) ([]APPRevision, error) {
+	logger := mwlogger.LoggerFromContext(ctx)
+	if id <= 0 {
+		return nil, ErrIncorrectID
+	}
+
+	// текущий текст - последняя версия в истории
+	current, err := c.repo.GetCommentByID(ctx, id)
+	if err != nil {
+		switch {
+		case !errors.Is(err, repository.ErrCommentNotFound):
+			logger.Error().Err(err).Msg("Failed to check comment existence before fetching its revisions")
+			return nil, ErrCommon500
+		default:
+			return nil, err
+		}
+	}
+	if !isVisible(ctx, current) {
+		return nil, repository.ErrCommentNotFound
+	}
+	// история удалённого или скрытого комментария раскрыла бы его текст - она видна только модераторам
+	if isRemoved(current) {
+		if _, err := requireModerator(ctx); err != nil {
+			return nil, repository.ErrCommentNotFound
+		}
+	}
+
+	revisions, err := c.repo.GetRevisions(ctx, id)
+	if err != nil {
+		logger.Error().Err(err).Msg(fmt.Sprintf("Failed to fetch revisions for comment %d from DB", id))
+		return nil, ErrCommon500
+	}
+
+	return compileRevisions(revisions, current), nil
 }
 
 func (c CService) DeleteCommentByID(ctx context.Context, id int, isSoftDelete bool) error {
@@ -112,7 +580,7 @@ func (c CService) DeleteCommentByID(ctx context.Context, id int, isSoftDelete bo
 	}
 
 	// проверяем существует ли такой коммент
-	_, err := c.repo.GetCommentByID(ctx, id)
+	current, err := c.repo.GetCommentByID(ctx, id)
 	if err != nil {
 		switch {
 		case !errors.Is(err, repository.ErrCommentNotFound):
@@ -122,6 +590,13 @@ func (c CService) DeleteCommentByID(ctx context.Context, id int, isSoftDelete bo
 			return err
 		}
 	}
+	if err := canDelete(ctx, current, isSoftDelete); err != nil {
+		return err
+	}
+	// чужой комментарий удаляет модерация - это сигнал спама; автор, убравший свой комментарий, ничего не сообщает
+	if !isAuthor(ctx, current) {
+		c.trainSpam(ctx, current, model.SpamLabel)
+	}
 
 	// определяем режим удаления
 	switch isSoftDelete {
@@ -139,13 +614,301 @@ func (c CService) DeleteCommentByID(ctx context.Context, id int, isSoftDelete bo
 	return nil
 }
 
+func (c CService) RestoreComment(ctx context.Context, id int) (*APPComment, error) {
+	logger := mwlogger.LoggerFromContext(ctx)
+	if id <= 0 {
+		return nil, ErrIncorrectID
+	}
+	// восстановившим всегда записывается сам модератор или ключ - по токену, а не со слов клиента
+	moderator, err := requireModerator(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// проверяем существует ли такой коммент
+	current, err := c.repo.GetCommentByID(ctx, id)
+	if err != nil {
+		switch {
+		case !errors.Is(err, repository.ErrCommentNotFound):
+			logger.Error().Err(err).Msg("Failed to check comment existence before restoring")
+			return nil, ErrCommon500
+		default:
+			return nil, err
+		}
+	}
+	if current.DeletedAt == nil { // восстанавливать можно только мягко удаленный коммент
+		return nil, ErrNotDeleted
+	}
+
+	// под удаленным родителем восстановленный коммент всё равно остался бы в скрытой ветке
+	if current.ParentID != nil {
+		parent, err := c.repo.GetCommentByID(ctx, *current.ParentID)
+		if err != nil {
+			logger.Error().Err(err).Msg("Failed to check parent before restoring comment")
+			return nil, ErrCommon500
+		}
+		if parent.DeletedAt != nil {
+			return nil, ErrParentDeleted
+		}
+	}
+
+	res, err := c.repo.RestoreByID(ctx, id, moderator)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrCommentNotFound): // восстановили параллельным запросом
+			return nil, ErrNotDeleted
+		default:
+			logger.Error().Err(err).Msg(fmt.Sprintf("Failed to restore comment %d", id))
+			return nil, ErrCommon500
+		}
+	}
+
+	logger.Info().Msg(fmt.Sprintf("Comment %d restored by %q", id, res.RestoredBy))
+	return convertToAPPComment(res), nil
+}
+
+func (c CService) MoveComment(ctx context.Context, id int, data *model.CommentMoveData) (*APPComment, error) {
+	logger := mwlogger.LoggerFromContext(ctx)
+	if id <= 0 || (data.ParentID != nil && *data.ParentID <= 0) {
+		return nil, ErrIncorrectID
+	}
+	if data.ParentID != nil && *data.ParentID == id {
+		return nil, ErrMoveIntoItself
+	}
+	if _, err := requireModerator(ctx); err != nil {
+		return nil, err
+	}
+
+	// проверяем существует ли такой коммент
+	current, err := c.repo.GetCommentByID(ctx, id)
+	if err != nil {
+		switch {
+		case !errors.Is(err, repository.ErrCommentNotFound):
+			logger.Error().Err(err).Msg("Failed to check comment existence before moving")
+			return nil, ErrCommon500
+		default:
+			return nil, err
+		}
+	}
+	if equalParents(current.ParentID, data.ParentID) { // ветка уже на месте
+		return convertToAPPComment(current), nil
+	}
+
+	// окончательные проверки цели и защита от циклов - в транзакции repository-слоя
+	res, err := c.repo.MoveSubtree(ctx, id, data.ParentID)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrCommentNotFound):
+			return nil, err
+		case errors.Is(err, repository.ErrMoveTargetNotFound):
+			return nil, ErrParentNotFound
+		case errors.Is(err, repository.ErrMoveTargetDeleted):
+			return nil, ErrParentDeleted
+		case errors.Is(err, repository.ErrMoveIntoSubtree):
+			return nil, ErrMoveIntoItself
+		case errors.Is(err, repository.ErrMoveAcrossThreads):
+			return nil, ErrThreadMismatch
+		default:
+			logger.Error().Err(err).Msg(fmt.Sprintf("Failed to move comment %d", id))
+			return nil, ErrCommon500
+		}
+	}
+
+	logger.Info().Msg(fmt.Sprintf("Comment %d moved under %v", id, describeParent(data.ParentID)))
+	return convertToAPPComment(res), nil
+}
+
+func (c CService) SetCommentLock(ctx context.Context, id int, locked bool) (*APPComment, error) {
+	logger := mwlogger.LoggerFromContext(ctx)
+	if id <= 0 {
+		return nil, ErrIncorrectID
+	}
+	if _, err := requireModerator(ctx); err != nil {
+		return nil, err
+	}
+
+	res, err := c.repo.SetCommentLock(ctx, id, locked)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrCommentNotFound):
+			return nil, err
+		default:
+			logger.Error().Err(err).Msg(fmt.Sprintf("Failed to set lock=%v on comment %d", locked, id))
+			return nil, ErrCommon500
+		}
+	}
+
+	logger.Info().Msg(fmt.Sprintf("Comment %d lock set to %v", id, locked))
+	return convertToAPPComment(res), nil
+}
+
+func (c CService) SetCommentPin(ctx context.Context, id int, pinned bool) (*APPComment, error) {
+	logger := mwlogger.LoggerFromContext(ctx)
+	if id <= 0 {
+		return nil, ErrIncorrectID
+	}
+	if _, err := requireModerator(ctx); err != nil {
+		return nil, err
+	}
+
+	// проверяем существует ли такой коммент
+	current, err := c.repo.GetCommentByID(ctx, id)
+	if err != nil {
+		switch {
+		case !errors.Is(err, repository.ErrCommentNotFound):
+			logger.Error().Err(err).Msg("Failed to check comment existence before pinning")
+			return nil, ErrCommon500
+		default:
+			return nil, err
+		}
+	}
+	if pinned && current.ParentID != nil {
+		return nil, ErrPinNotRoot
+	}
+	if pinned && isRemoved(current) {
+		return nil, ErrCommentDeleted
+	}
+
+	res, err := c.repo.SetPinned(ctx, id, pinned)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrCommentNotFound):
+			return nil, err
+		default:
+			logger.Error().Err(err).Msg(fmt.Sprintf("Failed to set pinned=%v on comment %d", pinned, id))
+			return nil, ErrCommon500
+		}
+	}
+
+	logger.Info().Msg(fmt.Sprintf("Comment %d pinned set to %v", id, pinned))
+	return convertToAPPComment(res), nil
+}
+
+func (c CService) VoteComment(ctx context.Context, id int, data *model.CommentVoteData) (*APPComment, error) {
+	logger := mwlogger.LoggerFromContext(ctx)
+	if id <= 0 {
+		return nil, ErrIncorrectID
+	}
+	if data.Value < -1 || data.Value > 1 {
+		return nil, ErrInvalidVote
+	}
+	// голос учитывается по клиенту - так же, как реакции: вошедший голосует от своего id, а не от имени из тела
+	voter := mwclient.ClientFromContext(ctx)
+	if voter == "" {
+		return nil, ErrNoClientID
+	}
+
+	// проверяем существует ли такой коммент; отозвать голос можно и у удалённого
+	current, err := c.repo.GetCommentByID(ctx, id)
+	if err != nil {
+		switch {
+		case !errors.Is(err, repository.ErrCommentNotFound):
+			logger.Error().Err(err).Msg("Failed to check comment existence before voting")
+			return nil, ErrCommon500
+		default:
+			return nil, err
+		}
+	}
+	if !isVisible(ctx, current) {
+		return nil, repository.ErrCommentNotFound
+	}
+	if data.Value != 0 && isRemoved(current) {
+		return nil, ErrCommentDeleted
+	}
+
+	res, err := c.repo.Vote(ctx, id, voter, data.Value)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrCommentNotFound):
+			return nil, err
+		default:
+			logger.Error().Err(err).Msg(fmt.Sprintf("Failed to save vote %d on comment %d", data.Value, id))
+			return nil, ErrCommon500
+		}
+	}
+
+	logger.Info().Msg(fmt.Sprintf("Vote %d on comment %d saved", data.Value, id))
+	return convertToAPPComment(res), nil
+}
+
+func (c CService) SetReaction(ctx context.Context, id int, emoji string, on bool) (*APPComment, error) {
+	logger := mwlogger.LoggerFromContext(ctx)
+	if id <= 0 {
+		return nil, ErrIncorrectID
+	}
+	if _, ok := c.reactions[emoji]; !ok {
+		return nil, ErrBadReaction
+	}
+	reactor := mwclient.ClientFromContext(ctx)
+	if reactor == "" {
+		return nil, ErrNoClientID
+	}
+
+	// проверяем существует ли такой коммент; снять реакцию можно и с удалённого
+	current, err := c.repo.GetCommentByID(ctx, id)
+	if err != nil {
+		switch {
+		case !errors.Is(err, repository.ErrCommentNotFound):
+			logger.Error().Err(err).Msg("Failed to check comment existence before reacting")
+			return nil, ErrCommon500
+		default:
+			return nil, err
+		}
+	}
+	if !isVisible(ctx, current) {
+		return nil, repository.ErrCommentNotFound
+	}
+	if on && isRemoved(current) {
+		return nil, ErrCommentDeleted
+	}
+
+	if err := c.repo.SetReaction(ctx, id, emoji, reactor, on); err != nil {
+		switch {
+		case errors.Is(err, repository.ErrCommentNotFound):
+			return nil, err
+		default:
+			logger.Error().Err(err).Msg(fmt.Sprintf("Failed to set reaction %q=%v on comment %d", emoji, on, id))
+			return nil, ErrCommon500
+		}
+	}
+
+	res := []APPComment{*convertToAPPComment(current)}
+	if err := c.attachReactions(ctx, res); err != nil {
+		logger.Error().Err(err).Msg(fmt.Sprintf("Failed to fetch reactions for comment %d from DB", id))
+		return nil, ErrCommon500
+	}
+
+	logger.Info().Msg(fmt.Sprintf("Reaction %q on comment %d set to %v", emoji, id, on))
+	return &res[0], nil
+}
+
+func (c CService) SetThreadLock(ctx context.Context, key string, locked bool) (*APPThread, error) {
+	logger := mwlogger.LoggerFromContext(ctx)
+	key, err := normalizeThread(key)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := requireModerator(ctx); err != nil {
+		return nil, err
+	}
+
+	res, err := c.repo.SetThreadLock(ctx, key, locked)
+	if err != nil {
+		logger.Error().Err(err).Msg(fmt.Sprintf("Failed to set lock=%v on thread %q", locked, key))
+		return nil, ErrCommon500
+	}
+
+	logger.Info().Msg(fmt.Sprintf("Thread %q lock set to %v", key, locked))
+	return convertToAPPThread(res), nil
+}
+
 func (c CService) RunCommentSearchQuery(ctx context.Context, query string) ([]APPComment, error) {
 	if query == "" {
 		return nil, nil
 	}
 	logger := mwlogger.LoggerFromContext(ctx)
 
-	res, err := c.repo.RunSearchQuery(ctx, query)
+	res, err := c.repo.RunSearchQuery(ctx, query, viewerID(ctx))
 	if err != nil {
 		logger.Error().Err(err).Msg("Failed to run search query in DB")
 		return nil, ErrCommon500
@@ -153,6 +916,40 @@ func (c CService) RunCommentSearchQuery(ctx context.Context, query string) ([]AP
 	return convertSearchResults(res), nil
 }
 
+// lockedAbove - закрыты ли ответы выше комментария: у кого-то из предков или во всём потоке
+func (c CService) lockedAbove(ctx context.Context, comment *model.DBComment) (bool, error) {
+	if comment.ParentID != nil {
+		return c.repo.IsBranchLocked(ctx, *comment.ParentID)
+	}
+	return c.repo.IsThreadLocked(ctx, comment.ThreadKey)
+}
+
+// normalizeThread проверяет ключ потока; пустой ключ означает общий поток
+func normalizeThread(key string) (string, error) {
+	key = strings.TrimSpace(key)
+	switch {
+	case key == "":
+		return model.DefaultThread, nil
+	case len(key) > 512 || strings.ContainsFunc(key, unicode.IsControl):
+		return "", ErrInvalidThread
+	}
+	return key, nil
+}
+
+func equalParents(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func describeParent(parentID *int) string {
+	if parentID == nil {
+		return "root"
+	}
+	return fmt.Sprintf("comment %d", *parentID)
+}
+
 func validateRequest(req *model.RootRequest) {
 	// Обрабатываем пустые значения, присваиваем дефолты если надо
 	if req.Page <= 0 {
@@ -161,6 +958,12 @@ func validateRequest(req *model.RootRequest) {
 	if req.Limit <= 0 || req.Limit > 100 {
 		req.Limit = 30
 	}
+	if req.Replies <= 0 || req.Replies > 20 {
+		req.Replies = 3
+	}
+	if req.Depth <= 0 || req.Depth > 5 {
+		req.Depth = 2
+	}
 	if req.Sort == "" {
 		req.Sort = model.ByCreated
 	}
@@ -172,6 +975,8 @@ func validateRequest(req *model.RootRequest) {
 	req.Sort = strings.ToLower(req.Sort)
 	req.Sort = strings.TrimSpace(req.Sort)
 	switch {
+	case req.Sort == model.SortTop, req.Sort == model.SortControversial, req.Sort == model.SortHot:
+		// ключи по голосам сверяем точно, иначе короткие префиксы совпадут с author/created
 	case strings.Contains(model.ByAuthor, req.Sort):
 		req.Sort = "author"
 	case strings.Contains(model.ByContent, req.Sort):
@@ -194,3 +999,20 @@ func validateRequest(req *model.RootRequest) {
 		req.Order = "ASC"
 	}
 }
+
+func validateTreeRequest(req *model.TreeRequest) {
+	// Валидируем ключ сортировки братьев, по дефолту - сначала новые
+	req.Sort = strings.ToLower(strings.TrimSpace(req.Sort))
+	switch req.Sort {
+	case model.SortOldest, model.SortNewest, model.SortAuthor,
+		model.SortTop, model.SortControversial, model.SortHot:
+	default:
+		req.Sort = model.SortNewest
+	}
+	if req.Depth < 0 {
+		req.Depth = 0
+	}
+	if req.Context < 0 {
+		req.Context = 0
+	}
+}
@@ -2,22 +2,71 @@ package service
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"slices"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/UnendingLoop/CommentTree/internal/auth"
 	"github.com/UnendingLoop/CommentTree/internal/model"
+	"github.com/UnendingLoop/CommentTree/internal/mwapikey"
+	"github.com/UnendingLoop/CommentTree/internal/mwauth"
+	"github.com/UnendingLoop/CommentTree/internal/mwclient"
+	"github.com/UnendingLoop/CommentTree/internal/ratelimit"
 	"github.com/UnendingLoop/CommentTree/internal/repository"
 )
 
 type mockRepo struct {
 	getByIDFn         func(ctx context.Context, id int) (*model.DBComment, error)
 	createFn          func(ctx context.Context, c *model.CommentCreateData) (*model.DBComment, error)
-	getAllRootFn      func(ctx context.Context, limit, offset int, sort, order string) ([]model.DBComment, error)
-	getWithChildrenFn func(ctx context.Context, id int) ([]model.DBComment, error)
+	getAllRootFn      func(ctx context.Context, q *model.PageQuery) ([]model.DBComment, error)
+	getChildrenFn     func(ctx context.Context, parentID int, q *model.PageQuery) ([]model.DBComment, error)
+	countRootFn       func(ctx context.Context, thread string, viewer int) (int, error)
+	countChildrenFn   func(ctx context.Context, parentID, viewer int) (int, error)
+	getPreviewFn      func(ctx context.Context, rootIDs []int, perParent, depth, viewer int) ([]model.DBComment, error)
+	getWithChildrenFn func(ctx context.Context, id, depth, viewer int) ([]model.DBComment, error)
+	getAncestorsFn    func(ctx context.Context, id, limit, viewer int) ([]model.DBComment, error)
 	markDeletedFn     func(ctx context.Context, id int) error
+	restoreFn         func(ctx context.Context, id int, restoredBy string) (*model.DBComment, error)
+	moveFn            func(ctx context.Context, id int, parentID *int) (*model.DBComment, error)
+	voteFn            func(ctx context.Context, id int, voter string, value int) (*model.DBComment, error)
+	setReactionFn     func(ctx context.Context, id int, emoji, reactor string, on bool) error
+	getReactionsFn    func(ctx context.Context, ids []int, reactor string) ([]model.DBReaction, error)
+	setPinnedFn       func(ctx context.Context, id int, pinned bool) (*model.DBComment, error)
+	getPinnedFn       func(ctx context.Context, thread string, viewer int) ([]model.DBComment, error)
+	setLockFn         func(ctx context.Context, id int, locked bool) (*model.DBComment, error)
+	setThreadLockFn   func(ctx context.Context, key string, locked bool) (*model.DBThread, error)
+	branchLockedFn    func(ctx context.Context, id int) (bool, error)
+	threadLockedFn    func(ctx context.Context, key string) (bool, error)
+	setPremodFn       func(ctx context.Context, key string, on bool) (*model.DBThread, error)
+	threadPremodFn    func(ctx context.Context, key string) (bool, error)
+	queueFn           func(ctx context.Context, thread string, limit, offset int) ([]model.DBComment, error)
+	moderateFn        func(ctx context.Context, id int, status, reason, by string) (*model.DBComment, error)
+	addReportFn       func(ctx context.Context, id int, reporter string, data *model.CommentReportData) (int, error)
+	reportGroupsFn    func(ctx context.Context, limit, offset int) ([]model.DBReportGroup, error)
+	resolveFn         func(ctx context.Context, id int, resolution, by string) (int, error)
+	setHiddenFn       func(ctx context.Context, id int, hidden bool) error
+	spamStatsFn       func(ctx context.Context, tokens []string) (*model.DBSpamStats, error)
+	trainSpamFn       func(ctx context.Context, id int, label string, tokens []string) error
+	spamScoreFn       func(ctx context.Context, id int, score float64) error
+	updateTextFn      func(ctx context.Context, id int, prevText, text string, diff []byte) (*model.DBComment, error)
+	getRevisionsFn    func(ctx context.Context, id int) ([]model.DBRevision, error)
 	deleteFn          func(ctx context.Context, id int) error
-	runSearchFn       func(ctx context.Context, query string) ([]model.DBComment, error)
+	purgeFn           func(ctx context.Context, cutoff time.Time) (int, int, error)
+	runSearchFn       func(ctx context.Context, query string, viewer int) ([]model.DBComment, error)
+}
+
+// asUser - контекст запроса вошедшего пользователя с ролью
+func asUser(id int, role string) context.Context {
+	return mwauth.WithUser(context.Background(), &auth.Claims{UserID: id, Name: "user" + strconv.Itoa(id), Role: role})
+}
+
+// asKey - контекст запроса интеграции с ключом и правами
+func asKey(scopes ...string) context.Context {
+	return mwapikey.WithAPIKey(context.Background(), &model.DBAPIKey{ID: 3, Name: "billing", Scopes: scopes})
 }
 
 func (m *mockRepo) GetCommentByID(ctx context.Context, id int) (*model.DBComment, error) {
@@ -28,24 +77,162 @@ func (m *mockRepo) Create(ctx context.Context, c *model.CommentCreateData) (*mod
 	return m.createFn(ctx, c)
 }
 
-func (m *mockRepo) GetAllRoot(ctx context.Context, limit, offset int, sort, order string) ([]model.DBComment, error) {
-	return m.getAllRootFn(ctx, limit, offset, sort, order)
+func (m *mockRepo) GetAllRoot(ctx context.Context, q *model.PageQuery) ([]model.DBComment, error) {
+	return m.getAllRootFn(ctx, q)
+}
+
+func (m *mockRepo) GetChildren(ctx context.Context, parentID int, q *model.PageQuery) ([]model.DBComment, error) {
+	return m.getChildrenFn(ctx, parentID, q)
+}
+
+func (m *mockRepo) CountRoot(ctx context.Context, thread string, viewer int) (int, error) {
+	return m.countRootFn(ctx, thread, viewer)
 }
 
-func (m *mockRepo) GetCommentWithChildrenByID(ctx context.Context, id int) ([]model.DBComment, error) {
-	return m.getWithChildrenFn(ctx, id)
+func (m *mockRepo) CountChildren(ctx context.Context, parentID, viewer int) (int, error) {
+	return m.countChildrenFn(ctx, parentID, viewer)
+}
+
+func (m *mockRepo) GetRepliesPreview(ctx context.Context, rootIDs []int, perParent, depth, viewer int) ([]model.DBComment, error) {
+	return m.getPreviewFn(ctx, rootIDs, perParent, depth, viewer)
+}
+
+func (m *mockRepo) GetCommentWithChildrenByID(ctx context.Context, id, depth, viewer int) ([]model.DBComment, error) {
+	return m.getWithChildrenFn(ctx, id, depth, viewer)
+}
+
+func (m *mockRepo) GetAncestorsByID(ctx context.Context, id, limit, viewer int) ([]model.DBComment, error) {
+	return m.getAncestorsFn(ctx, id, limit, viewer)
 }
 
 func (m *mockRepo) MarkAsDeletedByID(ctx context.Context, id int) error {
 	return m.markDeletedFn(ctx, id)
 }
 
+func (m *mockRepo) RestoreByID(ctx context.Context, id int, restoredBy string) (*model.DBComment, error) {
+	return m.restoreFn(ctx, id, restoredBy)
+}
+
+func (m *mockRepo) MoveSubtree(ctx context.Context, id int, parentID *int) (*model.DBComment, error) {
+	return m.moveFn(ctx, id, parentID)
+}
+
+func (m *mockRepo) Vote(ctx context.Context, id int, voter string, value int) (*model.DBComment, error) {
+	return m.voteFn(ctx, id, voter, value)
+}
+
+func (m *mockRepo) SetReaction(ctx context.Context, id int, emoji, reactor string, on bool) error {
+	return m.setReactionFn(ctx, id, emoji, reactor, on)
+}
+
+// по умолчанию реакций нет
+func (m *mockRepo) GetReactions(ctx context.Context, ids []int, reactor string) ([]model.DBReaction, error) {
+	if m.getReactionsFn == nil {
+		return nil, nil
+	}
+	return m.getReactionsFn(ctx, ids, reactor)
+}
+
+func (m *mockRepo) SetPinned(ctx context.Context, id int, pinned bool) (*model.DBComment, error) {
+	return m.setPinnedFn(ctx, id, pinned)
+}
+
+// по умолчанию закреплённых нет, чтобы не описывать их в каждом сценарии выдачи корней
+func (m *mockRepo) GetPinnedRoots(ctx context.Context, thread string, viewer int) ([]model.DBComment, error) {
+	if m.getPinnedFn == nil {
+		return nil, nil
+	}
+	return m.getPinnedFn(ctx, thread, viewer)
+}
+
+func (m *mockRepo) SetCommentLock(ctx context.Context, id int, locked bool) (*model.DBComment, error) {
+	return m.setLockFn(ctx, id, locked)
+}
+
+func (m *mockRepo) SetThreadLock(ctx context.Context, key string, locked bool) (*model.DBThread, error) {
+	return m.setThreadLockFn(ctx, key, locked)
+}
+
+// проверки блокировок дёргаются почти в каждом сценарии, поэтому по умолчанию всё открыто
+func (m *mockRepo) IsBranchLocked(ctx context.Context, id int) (bool, error) {
+	if m.branchLockedFn == nil {
+		return false, nil
+	}
+	return m.branchLockedFn(ctx, id)
+}
+
+func (m *mockRepo) IsThreadLocked(ctx context.Context, key string) (bool, error) {
+	if m.threadLockedFn == nil {
+		return false, nil
+	}
+	return m.threadLockedFn(ctx, key)
+}
+
+func (m *mockRepo) SetThreadPremoderation(ctx context.Context, key string, on bool) (*model.DBThread, error) {
+	return m.setPremodFn(ctx, key, on)
+}
+
+func (m *mockRepo) IsThreadPremoderated(ctx context.Context, key string) (bool, error) {
+	if m.threadPremodFn == nil {
+		return false, nil
+	}
+	return m.threadPremodFn(ctx, key)
+}
+
+func (m *mockRepo) GetModerationQueue(ctx context.Context, thread string, limit, offset int) ([]model.DBComment, error) {
+	return m.queueFn(ctx, thread, limit, offset)
+}
+
+func (m *mockRepo) SetModeration(ctx context.Context, id int, status, reason, by string) (*model.DBComment, error) {
+	return m.moderateFn(ctx, id, status, reason, by)
+}
+
+func (m *mockRepo) UpdateText(ctx context.Context, id int, prevText, text string, diff []byte) (*model.DBComment, error) {
+	return m.updateTextFn(ctx, id, prevText, text, diff)
+}
+
+func (m *mockRepo) GetRevisions(ctx context.Context, id int) ([]model.DBRevision, error) {
+	return m.getRevisionsFn(ctx, id)
+}
+
 func (m *mockRepo) DeleteByID(ctx context.Context, id int) error {
 	return m.deleteFn(ctx, id)
 }
 
-func (m *mockRepo) RunSearchQuery(ctx context.Context, query string) ([]model.DBComment, error) {
-	return m.runSearchFn(ctx, query)
+func (m *mockRepo) PurgeDeleted(ctx context.Context, cutoff time.Time) (int, int, error) {
+	return m.purgeFn(ctx, cutoff)
+}
+
+func (m *mockRepo) RunSearchQuery(ctx context.Context, query string, viewer int) ([]model.DBComment, error) {
+	return m.runSearchFn(ctx, query, viewer)
+}
+
+func (m *mockRepo) AddReport(ctx context.Context, id int, reporter string, data *model.CommentReportData) (int, error) {
+	return m.addReportFn(ctx, id, reporter, data)
+}
+
+func (m *mockRepo) GetReportGroups(ctx context.Context, limit, offset int) ([]model.DBReportGroup, error) {
+	return m.reportGroupsFn(ctx, limit, offset)
+}
+
+func (m *mockRepo) ResolveReports(ctx context.Context, id int, resolution, by string) (int, error) {
+	return m.resolveFn(ctx, id, resolution, by)
+}
+
+func (m *mockRepo) SetHidden(ctx context.Context, id int, hidden bool) error {
+	return m.setHiddenFn(ctx, id, hidden)
+}
+
+func (m *mockRepo) GetSpamStats(ctx context.Context, tokens []string) (*model.DBSpamStats, error) {
+	return m.spamStatsFn(ctx, tokens)
+}
+
+func (m *mockRepo) TrainSpam(ctx context.Context, id int, label string, tokens []string) error {
+	return m.trainSpamFn(ctx, id, label, tokens)
+}
+
+func (m *mockRepo) SetSpamScore(ctx context.Context, id int, score float64) error {
+	return m.spamScoreFn(ctx, id, score)
 }
 
 /*
@@ -59,7 +246,7 @@ func TestCreateComment_OK(t *testing.T) {
 		},
 	}
 
-	svc := NewCommentService(repo)
+	svc := NewCommentService(repo, Config{})
 
 	res, err := svc.CreateComment(context.Background(), &model.CommentCreateData{
 		Text: "hello",
@@ -72,6 +259,64 @@ func TestCreateComment_OK(t *testing.T) {
 	}
 }
 
+func TestCreateComment_AuthorFromToken(t *testing.T) {
+	var saved model.CommentCreateData
+	repo := &mockRepo{
+		createFn: func(ctx context.Context, c *model.CommentCreateData) (*model.DBComment, error) {
+			saved = *c
+			return &model.DBComment{ID: 1, Text: c.Text, Author: c.Author, AuthorID: c.AuthorID, EditHash: c.EditHash}, nil
+		},
+	}
+
+	svc := NewCommentService(repo, Config{})
+
+	// гость подписывается как хочет, но имя помечается непроверенным и к пользователю не привязывается
+	guest, err := svc.CreateComment(context.Background(), &model.CommentCreateData{Text: "hi", Author: " Вася "})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if saved.Author != "Вася" || saved.AuthorID != nil || !guest.Guest {
+		t.Fatalf("expected unverified guest name, got author %q, guest %v", saved.Author, guest.Guest)
+	}
+
+	ctx := mwauth.WithUser(context.Background(), &auth.Claims{UserID: 7, Name: "alice"})
+	res, err := svc.CreateComment(ctx, &model.CommentCreateData{Text: "hi", Author: "admin"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Author != "alice" || res.AuthorID == nil || *res.AuthorID != 7 || res.Guest {
+		t.Fatalf("expected author bound to token user, got %q", res.Author)
+	}
+}
+
+func TestCreateComment_EditTokenForAnonymous(t *testing.T) {
+	var saved model.CommentCreateData
+	repo := &mockRepo{
+		createFn: func(ctx context.Context, c *model.CommentCreateData) (*model.DBComment, error) {
+			saved = *c
+			return &model.DBComment{ID: 1, Text: c.Text, AuthorID: c.AuthorID, EditHash: c.EditHash}, nil
+		},
+	}
+
+	svc := NewCommentService(repo, Config{})
+
+	res, err := svc.CreateComment(context.Background(), &model.CommentCreateData{Text: "hi"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.EditToken == "" || saved.EditHash != auth.HashSecret(res.EditToken) {
+		t.Fatalf("expected edit token in response and only its hash in DB")
+	}
+
+	res, err = svc.CreateComment(asUser(7, model.RoleUser), &model.CommentCreateData{Text: "hi"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.EditToken != "" || saved.EditHash != "" {
+		t.Fatalf("expected no edit token for authenticated author")
+	}
+}
+
 func TestCreateComment_ParentNotFound(t *testing.T) {
 	repo := &mockRepo{
 		getByIDFn: func(ctx context.Context, id int) (*model.DBComment, error) {
@@ -79,7 +324,7 @@ func TestCreateComment_ParentNotFound(t *testing.T) {
 		},
 	}
 
-	svc := NewCommentService(repo)
+	svc := NewCommentService(repo, Config{})
 	parentID := 10
 
 	_, err := svc.CreateComment(context.Background(), &model.CommentCreateData{
@@ -100,7 +345,7 @@ func TestCreateComment_ParentDeleted(t *testing.T) {
 		},
 	}
 
-	svc := NewCommentService(repo)
+	svc := NewCommentService(repo, Config{})
 	parentID := 5
 
 	_, err := svc.CreateComment(context.Background(), &model.CommentCreateData{
@@ -112,118 +357,2216 @@ func TestCreateComment_ParentDeleted(t *testing.T) {
 	}
 }
 
+func TestCreateComment_ReplyInheritsThread(t *testing.T) {
+	repo := &mockRepo{
+		getByIDFn: func(ctx context.Context, id int) (*model.DBComment, error) {
+			return &model.DBComment{ID: id, ThreadKey: "article-1"}, nil
+		},
+		createFn: func(ctx context.Context, c *model.CommentCreateData) (*model.DBComment, error) {
+			return &model.DBComment{ID: 2, ParentID: c.ParentID, ThreadKey: c.ThreadKey}, nil
+		},
+	}
+
+	svc := NewCommentService(repo, Config{})
+
+	res, err := svc.CreateComment(context.Background(), &model.CommentCreateData{ParentID: ptr(1), Text: "reply"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.ThreadKey != "article-1" {
+		t.Fatalf("expected reply in parent's thread, got %q", res.ThreadKey)
+	}
+}
+
+func TestCreateComment_ThreadMismatch(t *testing.T) {
+	repo := &mockRepo{
+		getByIDFn: func(ctx context.Context, id int) (*model.DBComment, error) {
+			return &model.DBComment{ID: id, ThreadKey: "article-1"}, nil
+		},
+	}
+
+	svc := NewCommentService(repo, Config{})
+
+	_, err := svc.CreateComment(context.Background(), &model.CommentCreateData{ParentID: ptr(1), ThreadKey: "article-2"})
+	if !errors.Is(err, ErrThreadMismatch) {
+		t.Fatalf("expected ErrThreadMismatch")
+	}
+}
+
+func TestCreateComment_DefaultThread(t *testing.T) {
+	repo := &mockRepo{
+		createFn: func(ctx context.Context, c *model.CommentCreateData) (*model.DBComment, error) {
+			if c.ThreadKey != model.DefaultThread {
+				t.Fatalf("expected default thread for root without key, got %q", c.ThreadKey)
+			}
+			return &model.DBComment{ID: 1}, nil
+		},
+	}
+
+	svc := NewCommentService(repo, Config{})
+
+	if _, err := svc.CreateComment(context.Background(), &model.CommentCreateData{Text: "root"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestCreateComment_BranchLocked(t *testing.T) {
+	repo := &mockRepo{
+		getByIDFn: func(ctx context.Context, id int) (*model.DBComment, error) {
+			return &model.DBComment{ID: id, ThreadKey: model.DefaultThread}, nil
+		},
+		branchLockedFn: func(ctx context.Context, id int) (bool, error) {
+			return true, nil
+		},
+	}
+
+	svc := NewCommentService(repo, Config{})
+
+	_, err := svc.CreateComment(context.Background(), &model.CommentCreateData{ParentID: ptr(5), Text: "reply"})
+	if !errors.Is(err, ErrReplyLocked) {
+		t.Fatalf("expected ErrReplyLocked")
+	}
+}
+
+func TestCreateComment_ThreadLocked(t *testing.T) {
+	repo := &mockRepo{
+		threadLockedFn: func(ctx context.Context, key string) (bool, error) {
+			return key == "article-1", nil
+		},
+	}
+
+	svc := NewCommentService(repo, Config{})
+
+	_, err := svc.CreateComment(context.Background(), &model.CommentCreateData{Text: "root", ThreadKey: "article-1"})
+	if !errors.Is(err, ErrThreadLocked) {
+		t.Fatalf("expected ErrThreadLocked")
+	}
+}
+
 /*
 	GET ALL ROOT COMMENTS
 */
 
 func TestGetAllRootComments_OK(t *testing.T) {
 	repo := &mockRepo{
-		getAllRootFn: func(ctx context.Context, limit, offset int, sort, order string) ([]model.DBComment, error) {
+		getAllRootFn: func(ctx context.Context, q *model.PageQuery) ([]model.DBComment, error) {
+			return []model.DBComment{
+				{ID: 1, Text: "root", ReplyCount: 5},
+			}, nil
+		},
+		getPreviewFn: func(ctx context.Context, rootIDs []int, perParent, depth, viewer int) ([]model.DBComment, error) {
+			if perParent != 3 || depth != 2 {
+				t.Fatalf("expected default preview 3x2, got %dx%d", perParent, depth)
+			}
 			return []model.DBComment{
-				{ID: 1, Text: "root"},
+				{ID: 2, ParentID: ptr(1), Text: "reply"},
 			}, nil
 		},
 	}
 
-	svc := NewCommentService(repo)
+	svc := NewCommentService(repo, Config{})
 
 	res, err := svc.GetAllRootComments(context.Background(), &model.RootRequest{})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	if len(res) != 1 {
+	if len(res.Items) != 1 {
 		t.Fatalf("expected 1 comment")
 	}
+	if len(res.Items[0].Children) != 1 || !res.Items[0].HasMore || res.Items[0].ReplyCount != 5 {
+		t.Fatalf("expected root with 1 embedded reply out of 5 and has_more marker, got %+v", res.Items[0])
+	}
+	if res.NextCursor != "" {
+		t.Fatalf("expected no next cursor for the last page")
+	}
 }
 
-/*
-	GET COMMENT WITH CHILDREN
-*/
+func TestGetAllRootComments_Thread(t *testing.T) {
+	repo := &mockRepo{
+		getAllRootFn: func(ctx context.Context, q *model.PageQuery) ([]model.DBComment, error) {
+			if q.Thread != "https://example.com/post/1" {
+				t.Fatalf("expected thread key in page query, got %q", q.Thread)
+			}
+			return nil, nil
+		},
+	}
 
-func TestGetCommentWithChildren_InvalidID(t *testing.T) {
-	svc := NewCommentService(&mockRepo{})
+	svc := NewCommentService(repo, Config{})
 
-	_, err := svc.GetCommentWithChildren(context.Background(), 0)
-	if !errors.Is(err, ErrIncorrectID) {
-		t.Fatalf("expected ErrIncorrectID")
+	if _, err := svc.GetAllRootComments(context.Background(), &model.RootRequest{Thread: " https://example.com/post/1 "}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
 	}
 }
 
-func TestGetCommentWithChildren_ParentNotFound(t *testing.T) {
+func TestGetAllRootComments_PinnedFirstOnPageOne(t *testing.T) {
+	now := time.Now()
+	pinnedCalls := 0
+	var previewIDs []int
 	repo := &mockRepo{
-		getByIDFn: func(ctx context.Context, id int) (*model.DBComment, error) {
-			return nil, repository.ErrCommentNotFound
+		getAllRootFn: func(ctx context.Context, q *model.PageQuery) ([]model.DBComment, error) {
+			return []model.DBComment{{ID: 1}, {ID: 2}}, nil
+		},
+		getPinnedFn: func(ctx context.Context, thread string, viewer int) ([]model.DBComment, error) {
+			pinnedCalls++
+			return []model.DBComment{{ID: 9, PinnedAt: &now}}, nil
+		},
+		getPreviewFn: func(ctx context.Context, rootIDs []int, perParent, depth, viewer int) ([]model.DBComment, error) {
+			previewIDs = rootIDs
+			return nil, nil
 		},
 	}
 
-	svc := NewCommentService(repo)
+	svc := NewCommentService(repo, Config{})
 
-	_, err := svc.GetCommentWithChildren(context.Background(), 1)
-	if !errors.Is(err, ErrParentNotFound) {
-		t.Fatalf("expected ErrParentNotFound")
+	res, err := svc.GetAllRootComments(context.Background(), &model.RootRequest{Sort: "author", Order: "ascending"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(res.Items) != 3 || res.Items[0].ID != 9 || !res.Items[0].Pinned {
+		t.Fatalf("expected pinned root first, got %+v", res.Items)
+	}
+	if len(previewIDs) != 3 || previewIDs[0] != 9 {
+		t.Fatalf("expected preview for pinned root too, got %v", previewIDs)
+	}
+
+	if _, err := svc.GetAllRootComments(context.Background(), &model.RootRequest{Page: 2}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pinnedCalls != 1 {
+		t.Fatalf("expected pinned roots only on page one, fetched %d times", pinnedCalls)
 	}
 }
 
-func TestGetCommentWithChildren_OK(t *testing.T) {
+func TestGetAllRootComments_EmptyPageSkipsPreview(t *testing.T) {
 	repo := &mockRepo{
-		getByIDFn: func(ctx context.Context, id int) (*model.DBComment, error) {
-			return &model.DBComment{ID: id}, nil
+		getAllRootFn: func(ctx context.Context, q *model.PageQuery) ([]model.DBComment, error) {
+			return nil, nil
+		},
+	}
+
+	svc := NewCommentService(repo, Config{})
+
+	res, err := svc.GetAllRootComments(context.Background(), &model.RootRequest{Page: 100})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(res.Items) != 0 {
+		t.Fatalf("expected empty page")
+	}
+}
+
+func TestGetAllRootComments_EnvelopeCountsTotal(t *testing.T) {
+	repo := &mockRepo{
+		getAllRootFn: func(ctx context.Context, q *model.PageQuery) ([]model.DBComment, error) {
+			return []model.DBComment{{ID: 1}}, nil
 		},
-		getWithChildrenFn: func(ctx context.Context, id int) ([]model.DBComment, error) {
+		getPreviewFn: func(ctx context.Context, rootIDs []int, perParent, depth, viewer int) ([]model.DBComment, error) {
+			return nil, nil
+		},
+		countRootFn: func(ctx context.Context, thread string, viewer int) (int, error) {
+			return 42, nil
+		},
+	}
+
+	svc := NewCommentService(repo, Config{})
+
+	res, err := svc.GetAllRootComments(context.Background(), &model.RootRequest{Envelope: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Total != 42 {
+		t.Fatalf("expected total 42, got %d", res.Total)
+	}
+}
+
+func TestGetAllRootComments_CursorRoundTrip(t *testing.T) {
+	var calls []*model.PageQuery
+	repo := &mockRepo{
+		getAllRootFn: func(ctx context.Context, q *model.PageQuery) ([]model.DBComment, error) {
+			calls = append(calls, q)
+			// отдаём на один элемент больше лимита - значит, есть следующая страница
 			return []model.DBComment{
-				{ID: id},
-				{ID: 2, ParentID: &id},
+				{ID: 9, SortKey: "c"},
+				{ID: 8, SortKey: "b"},
+				{ID: 7, SortKey: "a"},
 			}, nil
 		},
+		getPreviewFn: func(ctx context.Context, rootIDs []int, perParent, depth, viewer int) ([]model.DBComment, error) {
+			return nil, nil
+		},
 	}
 
-	svc := NewCommentService(repo)
+	svc := NewCommentService(repo, Config{})
 
-	res, err := svc.GetCommentWithChildren(context.Background(), 1)
+	first, err := svc.GetAllRootComments(context.Background(), &model.RootRequest{Limit: 2, Sort: "author", Order: "ascending"})
 	if err != nil {
-		t.Fatalf("unexpected error")
+		t.Fatalf("unexpected error: %v", err)
 	}
-	if len(res) != 1 {
-		t.Fatalf("expected root node")
+	if len(first.Items) != 2 || first.NextCursor == "" {
+		t.Fatalf("expected 2 items and next cursor, got %d items and %q", len(first.Items), first.NextCursor)
+	}
+
+	// курсор сам задаёт сортировку и позицию, page игнорируется
+	if _, err := svc.GetAllRootComments(context.Background(), &model.RootRequest{Limit: 2, Page: 5, Cursor: first.NextCursor}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	second := calls[1]
+	if second.After == nil || second.After.ID != 8 || second.After.Value != "b" {
+		t.Fatalf("expected keyset position after (b, 8), got %+v", second.After)
+	}
+	if second.Offset != 0 || second.Sort != "author" || second.Order != "ASC" {
+		t.Fatalf("expected cursor to define sort and drop offset, got %+v", second)
+	}
+}
+
+func TestGetAllRootComments_InvalidCursor(t *testing.T) {
+	svc := NewCommentService(&mockRepo{}, Config{})
+
+	for _, cursor := range []string{"!!!", "bm90LWpzb24", "eyJzIjoiY2lkOyBEUk9QIiwibyI6IkFTQyIsInYiOiIxIiwiaWQiOjF9"} {
+		_, err := svc.GetAllRootComments(context.Background(), &model.RootRequest{Cursor: cursor})
+		if !errors.Is(err, ErrInvalidCursor) {
+			t.Fatalf("cursor %q: expected ErrInvalidCursor, got %v", cursor, err)
+		}
 	}
 }
 
 /*
-	DELETE COMMENT
+	GET CHILD COMMENTS
 */
 
-func TestDeleteComment_SoftDelete(t *testing.T) {
+func TestGetChildComments_OK(t *testing.T) {
 	repo := &mockRepo{
 		getByIDFn: func(ctx context.Context, id int) (*model.DBComment, error) {
-			return &model.DBComment{ID: id}, nil // <- комментарий существует
+			return &model.DBComment{ID: id}, nil
 		},
-		markDeletedFn: func(ctx context.Context, id int) error {
-			return nil
+		getChildrenFn: func(ctx context.Context, parentID int, q *model.PageQuery) ([]model.DBComment, error) {
+			return []model.DBComment{
+				{ID: 2, ParentID: &parentID, ReplyCount: 3, DescendantCount: 7},
+				{ID: 3, ParentID: &parentID},
+			}, nil
 		},
 	}
 
-	svc := NewCommentService(repo)
+	svc := NewCommentService(repo, Config{})
 
-	if err := svc.DeleteCommentByID(context.Background(), 1, true); err != nil {
+	res, err := svc.GetChildComments(context.Background(), 1, &model.RootRequest{})
+	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
+	if len(res.Items) != 2 || !res.Items[0].HasMore || res.Items[1].HasMore {
+		t.Fatalf("expected 2 children with has_more only on the one with replies, got %+v", res.Items)
+	}
+	if res.Items[0].DescendantCount != 7 || res.Items[0].Children != nil {
+		t.Fatalf("expected flat child carrying descendant count 7, got %+v", res.Items[0])
+	}
 }
 
-func TestDeleteComment_HardDelete(t *testing.T) {
+func TestGetChildComments_InheritLock(t *testing.T) {
 	repo := &mockRepo{
 		getByIDFn: func(ctx context.Context, id int) (*model.DBComment, error) {
 			return &model.DBComment{ID: id}, nil
 		},
-		deleteFn: func(ctx context.Context, id int) error {
-			return nil
+		getChildrenFn: func(ctx context.Context, parentID int, q *model.PageQuery) ([]model.DBComment, error) {
+			return []model.DBComment{{ID: 2, ParentID: &parentID}}, nil
+		},
+		branchLockedFn: func(ctx context.Context, id int) (bool, error) {
+			return true, nil
 		},
 	}
 
-	svc := NewCommentService(repo)
+	svc := NewCommentService(repo, Config{})
 
-	if err := svc.DeleteCommentByID(context.Background(), 1, false); err != nil {
+	res, err := svc.GetChildComments(context.Background(), 1, &model.RootRequest{})
+	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
+	if res.Items[0].CanReply || !res.Items[0].Locked {
+		t.Fatalf("expected child of locked branch to be non-replyable, got %+v", res.Items[0])
+	}
+}
+
+func TestGetChildComments_ParentNotFound(t *testing.T) {
+	repo := &mockRepo{
+		getByIDFn: func(ctx context.Context, id int) (*model.DBComment, error) {
+			return nil, repository.ErrCommentNotFound
+		},
+	}
+
+	svc := NewCommentService(repo, Config{})
+
+	_, err := svc.GetChildComments(context.Background(), 1, &model.RootRequest{})
+	if !errors.Is(err, ErrParentNotFound) {
+		t.Fatalf("expected ErrParentNotFound")
+	}
+}
+
+/*
+	GET COMMENT WITH CHILDREN
+*/
+
+func TestGetCommentWithChildren_InvalidID(t *testing.T) {
+	svc := NewCommentService(&mockRepo{}, Config{})
+
+	_, err := svc.GetCommentWithChildren(context.Background(), 0, &model.TreeRequest{})
+	if !errors.Is(err, ErrIncorrectID) {
+		t.Fatalf("expected ErrIncorrectID")
+	}
+}
+
+func TestGetCommentWithChildren_ParentNotFound(t *testing.T) {
+	repo := &mockRepo{
+		getByIDFn: func(ctx context.Context, id int) (*model.DBComment, error) {
+			return nil, repository.ErrCommentNotFound
+		},
+	}
+
+	svc := NewCommentService(repo, Config{})
+
+	_, err := svc.GetCommentWithChildren(context.Background(), 1, &model.TreeRequest{})
+	if !errors.Is(err, ErrParentNotFound) {
+		t.Fatalf("expected ErrParentNotFound")
+	}
+}
+
+func TestGetCommentWithChildren_OK(t *testing.T) {
+	repo := &mockRepo{
+		getByIDFn: func(ctx context.Context, id int) (*model.DBComment, error) {
+			return &model.DBComment{ID: id}, nil
+		},
+		getWithChildrenFn: func(ctx context.Context, id, depth, viewer int) ([]model.DBComment, error) {
+			return []model.DBComment{
+				{ID: id},
+				{ID: 2, ParentID: &id},
+			}, nil
+		},
+	}
+
+	svc := NewCommentService(repo, Config{})
+
+	res, err := svc.GetCommentWithChildren(context.Background(), 1, &model.TreeRequest{})
+	if err != nil {
+		t.Fatalf("unexpected error")
+	}
+	if len(res) != 1 {
+		t.Fatalf("expected root node")
+	}
+}
+
+func TestGetCommentWithChildren_DepthCutoff(t *testing.T) {
+	repo := &mockRepo{
+		getByIDFn: func(ctx context.Context, id int) (*model.DBComment, error) {
+			return &model.DBComment{ID: id}, nil
+		},
+		getWithChildrenFn: func(ctx context.Context, id, depth, viewer int) ([]model.DBComment, error) {
+			if depth != 1 {
+				t.Fatalf("expected depth 1 to reach repository, got %d", depth)
+			}
+			// узел на срезе глубины несёт количество незагруженных ответов
+			return []model.DBComment{
+				{ID: id},
+				{ID: 2, ParentID: &id, ReplyCount: 4},
+				{ID: 3, ParentID: &id},
+			}, nil
+		},
+	}
+
+	svc := NewCommentService(repo, Config{})
+
+	res, err := svc.GetCommentWithChildren(context.Background(), 1, &model.TreeRequest{Depth: 1, Sort: model.SortOldest})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cut := res[0].Children[0]
+	if !cut.HasMoreChildren || cut.ReplyCount != 4 {
+		t.Fatalf("expected node at cutoff to report has_more_children and 4 replies, got %+v", cut)
+	}
+	if res[0].HasMoreChildren || res[0].Children[1].HasMoreChildren {
+		t.Fatalf("expected fully loaded nodes without continuation marker")
+	}
+}
+
+func TestGetCommentWithChildren_Context(t *testing.T) {
+	repo := &mockRepo{
+		getByIDFn: func(ctx context.Context, id int) (*model.DBComment, error) {
+			return &model.DBComment{ID: id, ParentID: ptr(2)}, nil
+		},
+		getWithChildrenFn: func(ctx context.Context, id, depth, viewer int) ([]model.DBComment, error) {
+			return []model.DBComment{
+				{ID: id, ParentID: ptr(2)},
+				{ID: 4, ParentID: &id},
+			}, nil
+		},
+		getAncestorsFn: func(ctx context.Context, id, limit, viewer int) ([]model.DBComment, error) {
+			if limit != 2 {
+				t.Fatalf("expected 2 ancestors to be requested, got %d", limit)
+			}
+			return []model.DBComment{
+				{ID: 1, ReplyCount: 3},
+				{ID: 2, ParentID: ptr(1), ReplyCount: 1},
+			}, nil
+		},
+	}
+
+	svc := NewCommentService(repo, Config{})
+
+	res, err := svc.GetCommentWithChildren(context.Background(), 3, &model.TreeRequest{Context: 2})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// 1 -> 2 -> 3(focused) -> 4
+	top := res[0]
+	if len(res) != 1 || top.ID != 1 || !top.HasMore {
+		t.Fatalf("expected topmost ancestor with omitted siblings marker, got %+v", top)
+	}
+	parent := top.Children[0]
+	if parent.ID != 2 || parent.HasMore || len(parent.Children) != 1 {
+		t.Fatalf("expected nearest ancestor with the only child, got %+v", parent)
+	}
+	target := parent.Children[0]
+	if target.ID != 3 || !target.Focused || len(target.Children) != 1 {
+		t.Fatalf("expected focused target with its subtree, got %+v", target)
+	}
+}
+
+/*
+	GET ANCESTORS
+*/
+
+func TestGetAncestors_OK(t *testing.T) {
+	repo := &mockRepo{
+		getByIDFn: func(ctx context.Context, id int) (*model.DBComment, error) {
+			return &model.DBComment{ID: id}, nil
+		},
+		getAncestorsFn: func(ctx context.Context, id, limit, viewer int) ([]model.DBComment, error) {
+			if limit != 0 {
+				t.Fatalf("expected the whole chain to be requested, got limit %d", limit)
+			}
+			return []model.DBComment{{ID: 1}, {ID: 2, ParentID: ptr(1)}}, nil
+		},
+	}
+
+	svc := NewCommentService(repo, Config{})
+
+	res, err := svc.GetAncestors(context.Background(), 3)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(res) != 2 || res[0].ID != 1 || res[1].ID != 2 {
+		t.Fatalf("expected chain from root, got %+v", res)
+	}
+}
+
+func TestGetAncestors_PendingAnonymous(t *testing.T) {
+	var gotViewer int
+	repo := &mockRepo{
+		getByIDFn: func(ctx context.Context, id int) (*model.DBComment, error) {
+			return &model.DBComment{ID: id, ParentID: ptr(1), Status: model.StatusPending, EditHash: auth.HashSecret("secret")}, nil
+		},
+		getAncestorsFn: func(ctx context.Context, id, limit, viewer int) ([]model.DBComment, error) {
+			gotViewer = viewer
+			return []model.DBComment{{ID: 1}}, nil
+		},
+	}
+
+	svc := NewCommentService(repo, Config{})
+
+	// непроверенный анонимный комментарий виден только держателю секрета правки
+	if _, err := svc.GetAncestors(asUser(7, model.RoleUser), 3); !errors.Is(err, repository.ErrCommentNotFound) {
+		t.Fatalf("expected ErrCommentNotFound for other user, got %v", err)
+	}
+	if _, err := svc.GetAncestors(mwauth.WithEditToken(asUser(7, model.RoleUser), "secret"), 3); err != nil {
+		t.Fatalf("unexpected error for author with edit token: %v", err)
+	}
+	if gotViewer != 7 {
+		t.Fatalf("expected viewer to be passed to ancestors query, got %d", gotViewer)
+	}
+}
+
+func TestGetAncestors_NotFound(t *testing.T) {
+	repo := &mockRepo{
+		getByIDFn: func(ctx context.Context, id int) (*model.DBComment, error) {
+			return nil, repository.ErrCommentNotFound
+		},
+	}
+
+	svc := NewCommentService(repo, Config{})
+
+	_, err := svc.GetAncestors(context.Background(), 3)
+	if !errors.Is(err, repository.ErrCommentNotFound) {
+		t.Fatalf("expected ErrCommentNotFound")
+	}
+}
+
+/*
+	EDIT COMMENT
+*/
+
+func TestEditComment_OK(t *testing.T) {
+	now := time.Now()
+	repo := &mockRepo{
+		getByIDFn: func(ctx context.Context, id int) (*model.DBComment, error) {
+			return &model.DBComment{ID: id, Text: "old", AuthorID: ptr(7)}, nil
+		},
+		updateTextFn: func(ctx context.Context, id int, prevText, text string, diff []byte) (*model.DBComment, error) {
+			if prevText != "old" || string(diff) != `[{"op":"delete","text":"old"},{"op":"insert","text":"new"}]` {
+				t.Fatalf("expected diff computed at edit time, got %q from %q", diff, prevText)
+			}
+			return &model.DBComment{ID: id, Text: text, EditedAt: &now, EditCount: 1}, nil
+		},
+	}
+
+	svc := NewCommentService(repo, Config{})
+
+	res, err := svc.EditComment(asUser(7, model.RoleUser), 1, &model.CommentEditData{Text: "new"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Text != "new" || res.EditCount != 1 || res.EditedAt == nil {
+		t.Fatalf("expected edited comment, got %+v", res)
+	}
+}
+
+func TestEditComment_Unchanged(t *testing.T) {
+	repo := &mockRepo{
+		getByIDFn: func(ctx context.Context, id int) (*model.DBComment, error) {
+			return &model.DBComment{ID: id, Text: "same", AuthorID: ptr(7)}, nil
+		},
+		updateTextFn: func(ctx context.Context, id int, prevText, text string, diff []byte) (*model.DBComment, error) {
+			t.Fatalf("revision must not be created for unchanged text")
+			return nil, nil
+		},
+	}
+
+	svc := NewCommentService(repo, Config{})
+
+	if _, err := svc.EditComment(asUser(7, model.RoleUser), 1, &model.CommentEditData{Text: "same"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestEditComment_Empty(t *testing.T) {
+	svc := NewCommentService(&mockRepo{}, Config{})
+
+	_, err := svc.EditComment(asUser(7, model.RoleUser), 1, &model.CommentEditData{Text: "  "})
+	if !errors.Is(err, ErrEmptyContent) {
+		t.Fatalf("expected ErrEmptyContent")
+	}
+}
+
+func TestEditComment_NotOwner(t *testing.T) {
+	repo := &mockRepo{
+		getByIDFn: func(ctx context.Context, id int) (*model.DBComment, error) {
+			return &model.DBComment{ID: id, Text: "old", AuthorID: ptr(7)}, nil
+		},
+	}
+
+	svc := NewCommentService(repo, Config{})
+
+	_, err := svc.EditComment(asUser(8, model.RoleAdmin), 1, &model.CommentEditData{Text: "new"})
+	if !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected ErrForbidden, got %v", err)
+	}
+}
+
+func TestEditComment_EditToken(t *testing.T) {
+	repo := &mockRepo{
+		getByIDFn: func(ctx context.Context, id int) (*model.DBComment, error) {
+			return &model.DBComment{ID: id, Text: "old", EditHash: auth.HashSecret("secret")}, nil
+		},
+		updateTextFn: func(ctx context.Context, id int, prevText, text string, diff []byte) (*model.DBComment, error) {
+			return &model.DBComment{ID: id, Text: text}, nil
+		},
+		markDeletedFn: func(ctx context.Context, id int) error { return nil },
+	}
+
+	svc := NewCommentService(repo, Config{})
+
+	ctx := mwauth.WithEditToken(context.Background(), "secret")
+	if _, err := svc.EditComment(ctx, 1, &model.CommentEditData{Text: "new"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := svc.DeleteCommentByID(ctx, 1, true); err != nil {
+		t.Fatalf("unexpected error on soft delete: %v", err)
+	}
+	if err := svc.DeleteCommentByID(ctx, 1, false); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected ErrForbidden for hard delete by token, got %v", err)
+	}
+
+	wrong := mwauth.WithEditToken(context.Background(), "guess")
+	if _, err := svc.EditComment(wrong, 1, &model.CommentEditData{Text: "new"}); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected ErrForbidden for wrong token, got %v", err)
+	}
+}
+
+func TestEditComment_Deleted(t *testing.T) {
+	now := time.Now()
+	repo := &mockRepo{
+		getByIDFn: func(ctx context.Context, id int) (*model.DBComment, error) {
+			return &model.DBComment{ID: id, Text: "old", AuthorID: ptr(7), DeletedAt: &now}, nil
+		},
+	}
+
+	svc := NewCommentService(repo, Config{})
+
+	_, err := svc.EditComment(asUser(7, model.RoleUser), 1, &model.CommentEditData{Text: "new"})
+	if !errors.Is(err, ErrCommentDeleted) {
+		t.Fatalf("expected ErrCommentDeleted")
+	}
+}
+
+func TestGetCommentRevisions_OK(t *testing.T) {
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	edited := created.Add(time.Hour)
+	repo := &mockRepo{
+		getByIDFn: func(ctx context.Context, id int) (*model.DBComment, error) {
+			return &model.DBComment{ID: id, Text: "hello big world", CreatedAt: created, EditedAt: &edited, EditCount: 1}, nil
+		},
+		getRevisionsFn: func(ctx context.Context, id int) ([]model.DBRevision, error) {
+			return []model.DBRevision{{ID: 1, CommentID: id, Text: "hello world", CreatedAt: created, ReplacedAt: edited}}, nil
+		},
+	}
+
+	svc := NewCommentService(repo, Config{})
+
+	res, err := svc.GetCommentRevisions(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(res) != 2 || res[0].Version != 1 || res[1].Version != 2 {
+		t.Fatalf("expected two versions, got %+v", res)
+	}
+	if res[0].Diff != nil || res[1].ReplacedAt != nil || !res[1].CreatedAt.Equal(edited) {
+		t.Fatalf("unexpected version metadata: %+v", res)
+	}
+	if len(res[1].Diff) != 3 || res[1].Diff[1] != (DiffOp{Op: DiffInsert, Text: "big "}) {
+		t.Fatalf("unexpected diff: %+v", res[1].Diff)
+	}
+}
+
+func TestGetCommentRevisions_CachedDiff(t *testing.T) {
+	repo := &mockRepo{
+		getByIDFn: func(ctx context.Context, id int) (*model.DBComment, error) {
+			return &model.DBComment{ID: id, Text: "new", EditCount: 1}, nil
+		},
+		getRevisionsFn: func(ctx context.Context, id int) ([]model.DBRevision, error) {
+			return []model.DBRevision{{ID: 1, CommentID: id, Text: "old", NextDiff: []byte(`[{"op":"insert","text":"cached"}]`)}}, nil
+		},
+	}
+
+	svc := NewCommentService(repo, Config{})
+
+	res, err := svc.GetCommentRevisions(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(res[1].Diff) != 1 || res[1].Diff[0].Text != "cached" {
+		t.Fatalf("expected diff saved at edit time, got %+v", res[1].Diff)
+	}
+}
+
+func TestGetCommentRevisions_Removed(t *testing.T) {
+	now := time.Now()
+	repo := &mockRepo{
+		getByIDFn: func(ctx context.Context, id int) (*model.DBComment, error) {
+			if id == 1 {
+				return &model.DBComment{ID: id, Text: "secret", DeletedAt: &now}, nil
+			}
+			return &model.DBComment{ID: id, Text: "spam", HiddenAt: &now}, nil
+		},
+		getRevisionsFn: func(ctx context.Context, id int) ([]model.DBRevision, error) {
+			return nil, nil
+		},
+	}
+
+	svc := NewCommentService(repo, Config{})
+
+	for _, id := range []int{1, 2} {
+		if _, err := svc.GetCommentRevisions(asUser(7, model.RoleUser), id); !errors.Is(err, repository.ErrCommentNotFound) {
+			t.Fatalf("expected ErrCommentNotFound for removed comment %d, got %v", id, err)
+		}
+	}
+	if _, err := svc.GetCommentRevisions(asUser(8, model.RoleModerator), 1); err != nil {
+		t.Fatalf("expected moderator to see history, got %v", err)
+	}
+}
+
+/*
+	DELETE COMMENT
+*/
+
+func TestDeleteComment_SoftDelete(t *testing.T) {
+	repo := &mockRepo{
+		getByIDFn: func(ctx context.Context, id int) (*model.DBComment, error) {
+			return &model.DBComment{ID: id}, nil // <- комментарий существует
+		},
+		markDeletedFn: func(ctx context.Context, id int) error {
+			return nil
+		},
+	}
+
+	svc := NewCommentService(repo, Config{})
+
+	if err := svc.DeleteCommentByID(asUser(1, model.RoleModerator), 1, true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestDeleteComment_HardDelete(t *testing.T) {
+	repo := &mockRepo{
+		getByIDFn: func(ctx context.Context, id int) (*model.DBComment, error) {
+			return &model.DBComment{ID: id}, nil
+		},
+		deleteFn: func(ctx context.Context, id int) error {
+			return nil
+		},
+	}
+
+	svc := NewCommentService(repo, Config{})
+
+	if err := svc.DeleteCommentByID(asUser(1, model.RoleAdmin), 1, false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestDeleteComment_Policy(t *testing.T) {
+	repo := &mockRepo{
+		getByIDFn: func(ctx context.Context, id int) (*model.DBComment, error) {
+			return &model.DBComment{ID: id, AuthorID: ptr(7)}, nil
+		},
+		markDeletedFn: func(ctx context.Context, id int) error { return nil },
+		deleteFn:      func(ctx context.Context, id int) error { return nil },
+	}
+
+	svc := NewCommentService(repo, Config{})
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		soft bool
+		want error
+	}{
+		{"anonymous", context.Background(), true, ErrUnauthorized},
+		{"author soft", asUser(7, model.RoleUser), true, nil},
+		{"author hard", asUser(7, model.RoleUser), false, ErrForbidden},
+		{"stranger soft", asUser(8, model.RoleUser), true, ErrForbidden},
+		{"moderator soft", asUser(8, model.RoleModerator), true, nil},
+		{"moderator hard", asUser(8, model.RoleModerator), false, ErrForbidden},
+		{"admin hard", asUser(8, model.RoleAdmin), false, nil},
+		{"key with delete scope hard", asKey(model.ScopeCommentsDelete), false, nil},
+		{"key without delete scope", asKey(model.ScopeCommentsWrite), true, ErrForbidden},
+	}
+
+	for _, tt := range tests {
+		if err := svc.DeleteCommentByID(tt.ctx, 1, tt.soft); !errors.Is(err, tt.want) {
+			t.Fatalf("%s: expected %v, got %v", tt.name, tt.want, err)
+		}
+	}
+}
+
+/*
+	RESTORE COMMENT
+*/
+
+func TestRestoreComment_OK(t *testing.T) {
+	now := time.Now()
+	repo := &mockRepo{
+		getByIDFn: func(ctx context.Context, id int) (*model.DBComment, error) {
+			if id == 1 {
+				return &model.DBComment{ID: 1}, nil // живой родитель
+			}
+			return &model.DBComment{ID: id, ParentID: ptr(1), Text: "original", DeletedAt: &now}, nil
+		},
+		restoreFn: func(ctx context.Context, id int, restoredBy string) (*model.DBComment, error) {
+			if restoredBy != "user1" {
+				t.Fatalf("expected actor from token, got %q", restoredBy)
+			}
+			return &model.DBComment{ID: id, ParentID: ptr(1), Text: "original", RestoredAt: &now, RestoredBy: restoredBy}, nil
+		},
+	}
+
+	svc := NewCommentService(repo, Config{})
+
+	res, err := svc.RestoreComment(asUser(1, model.RoleModerator), 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.IsDeleted || res.Text != "original" || res.RestoredBy != "user1" || res.RestoredAt == nil {
+		t.Fatalf("expected restored comment, got %+v", res)
+	}
+}
+
+func TestRestoreComment_NotDeleted(t *testing.T) {
+	repo := &mockRepo{
+		getByIDFn: func(ctx context.Context, id int) (*model.DBComment, error) {
+			return &model.DBComment{ID: id}, nil
+		},
+	}
+
+	svc := NewCommentService(repo, Config{})
+
+	_, err := svc.RestoreComment(asUser(1, model.RoleModerator), 2)
+	if !errors.Is(err, ErrNotDeleted) {
+		t.Fatalf("expected ErrNotDeleted")
+	}
+}
+
+func TestRestoreComment_ParentDeleted(t *testing.T) {
+	now := time.Now()
+	repo := &mockRepo{
+		getByIDFn: func(ctx context.Context, id int) (*model.DBComment, error) {
+			if id == 1 {
+				return &model.DBComment{ID: 1, DeletedAt: &now}, nil
+			}
+			return &model.DBComment{ID: id, ParentID: ptr(1), DeletedAt: &now}, nil
+		},
+	}
+
+	svc := NewCommentService(repo, Config{})
+
+	_, err := svc.RestoreComment(asUser(1, model.RoleModerator), 2)
+	if !errors.Is(err, ErrParentDeleted) {
+		t.Fatalf("expected ErrParentDeleted")
+	}
+}
+
+/*
+	MOVE COMMENT
+*/
+
+func TestMoveComment_OK(t *testing.T) {
+	repo := &mockRepo{
+		getByIDFn: func(ctx context.Context, id int) (*model.DBComment, error) {
+			return &model.DBComment{ID: id, ParentID: ptr(1)}, nil
+		},
+		moveFn: func(ctx context.Context, id int, parentID *int) (*model.DBComment, error) {
+			if parentID == nil || *parentID != 7 {
+				t.Fatalf("expected move under 7, got %v", parentID)
+			}
+			return &model.DBComment{ID: id, ParentID: parentID}, nil
+		},
+	}
+
+	svc := NewCommentService(repo, Config{})
+
+	res, err := svc.MoveComment(asUser(1, model.RoleModerator), 3, &model.CommentMoveData{ParentID: ptr(7)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.ParentID == nil || *res.ParentID != 7 {
+		t.Fatalf("expected new parent, got %+v", res)
+	}
+}
+
+func TestMoveComment_PromoteToRoot(t *testing.T) {
+	repo := &mockRepo{
+		getByIDFn: func(ctx context.Context, id int) (*model.DBComment, error) {
+			return &model.DBComment{ID: id, ParentID: ptr(1)}, nil
+		},
+		moveFn: func(ctx context.Context, id int, parentID *int) (*model.DBComment, error) {
+			if parentID != nil {
+				t.Fatalf("expected promotion to root, got %v", *parentID)
+			}
+			return &model.DBComment{ID: id}, nil
+		},
+	}
+
+	svc := NewCommentService(repo, Config{})
+
+	res, err := svc.MoveComment(asUser(1, model.RoleModerator), 3, &model.CommentMoveData{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.ParentID != nil {
+		t.Fatalf("expected root comment, got %+v", res)
+	}
+}
+
+func TestMoveComment_RepoErrors(t *testing.T) {
+	tests := []struct {
+		repoErr error
+		want    error
+	}{
+		{repository.ErrMoveTargetNotFound, ErrParentNotFound},
+		{repository.ErrMoveTargetDeleted, ErrParentDeleted},
+		{repository.ErrMoveIntoSubtree, ErrMoveIntoItself},
+		{repository.ErrMoveAcrossThreads, ErrThreadMismatch},
+	}
+
+	for _, tt := range tests {
+		repo := &mockRepo{
+			getByIDFn: func(ctx context.Context, id int) (*model.DBComment, error) {
+				return &model.DBComment{ID: id}, nil
+			},
+			moveFn: func(ctx context.Context, id int, parentID *int) (*model.DBComment, error) {
+				return nil, tt.repoErr
+			},
+		}
+
+		svc := NewCommentService(repo, Config{})
+
+		_, err := svc.MoveComment(asUser(1, model.RoleModerator), 3, &model.CommentMoveData{ParentID: ptr(9)})
+		if !errors.Is(err, tt.want) {
+			t.Fatalf("expected %v for %v, got %v", tt.want, tt.repoErr, err)
+		}
+	}
+}
+
+func TestMoveComment_RequiresModerator(t *testing.T) {
+	svc := NewCommentService(&mockRepo{}, Config{})
+
+	_, err := svc.MoveComment(asUser(7, model.RoleUser), 3, &model.CommentMoveData{})
+	if !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected ErrForbidden, got %v", err)
+	}
+}
+
+func TestMoveComment_IntoItself(t *testing.T) {
+	svc := NewCommentService(&mockRepo{}, Config{})
+
+	_, err := svc.MoveComment(asUser(1, model.RoleModerator), 3, &model.CommentMoveData{ParentID: ptr(3)})
+	if !errors.Is(err, ErrMoveIntoItself) {
+		t.Fatalf("expected ErrMoveIntoItself")
+	}
+}
+
+/*
+	REACTIONS
+*/
+
+func TestGetAllRootComments_ReactionsInOneQuery(t *testing.T) {
+	calls := 0
+	repo := &mockRepo{
+		getAllRootFn: func(ctx context.Context, q *model.PageQuery) ([]model.DBComment, error) {
+			return []model.DBComment{{ID: 1}, {ID: 3}}, nil
+		},
+		getPreviewFn: func(ctx context.Context, rootIDs []int, perParent, depth, viewer int) ([]model.DBComment, error) {
+			return []model.DBComment{{ID: 2, ParentID: ptr(1)}}, nil
+		},
+		getReactionsFn: func(ctx context.Context, ids []int, reactor string) ([]model.DBReaction, error) {
+			calls++
+			if len(ids) != 3 || reactor != "me" {
+				t.Fatalf("expected all 3 nodes for reactor me, got %v for %q", ids, reactor)
+			}
+			return []model.DBReaction{
+				{CommentID: 2, Emoji: "🎉", Count: 1, Mine: true},
+				{CommentID: 2, Emoji: "👍", Count: 4},
+				{CommentID: 3, Emoji: "🤡", Count: 9}, // убрана из набора
+			}, nil
+		},
+	}
+
+	svc := NewCommentService(repo, Config{Reactions: []string{"👍", " 🎉 "}})
+
+	ctx := mwclient.WithClient(context.Background(), "me")
+	res, err := svc.GetAllRootComments(ctx, &model.RootRequest{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("expected 1 reactions query, got %d", calls)
+	}
+
+	reply := res.Items[0].Children[0].Reactions
+	if len(reply) != 2 || reply[0].Emoji != "👍" || reply[0].Mine || reply[1].Emoji != "🎉" || !reply[1].Mine {
+		t.Fatalf("expected reactions in configured order with own flag, got %+v", reply)
+	}
+	if len(res.Items[1].Reactions) != 0 {
+		t.Fatalf("expected reactions outside of configured set to be hidden")
+	}
+}
+
+func TestSetReaction_OK(t *testing.T) {
+	repo := &mockRepo{
+		getByIDFn: func(ctx context.Context, id int) (*model.DBComment, error) {
+			return &model.DBComment{ID: id}, nil
+		},
+		setReactionFn: func(ctx context.Context, id int, emoji, reactor string, on bool) error {
+			if emoji != "👍" || reactor != "me" || !on {
+				t.Fatalf("unexpected reaction %q by %q on=%v", emoji, reactor, on)
+			}
+			return nil
+		},
+		getReactionsFn: func(ctx context.Context, ids []int, reactor string) ([]model.DBReaction, error) {
+			return []model.DBReaction{{CommentID: ids[0], Emoji: "👍", Count: 1, Mine: true}}, nil
+		},
+	}
+
+	svc := NewCommentService(repo, Config{})
+
+	res, err := svc.SetReaction(mwclient.WithClient(context.Background(), "me"), 1, "👍", true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(res.Reactions) != 1 || !res.Reactions[0].Mine {
+		t.Fatalf("expected own reaction, got %+v", res.Reactions)
+	}
+}
+
+func TestSetReaction_Invalid(t *testing.T) {
+	svc := NewCommentService(&mockRepo{}, Config{})
+
+	_, err := svc.SetReaction(mwclient.WithClient(context.Background(), "me"), 1, "🤡", true)
+	if !errors.Is(err, ErrBadReaction) {
+		t.Fatalf("expected ErrBadReaction, got %v", err)
+	}
+
+	_, err = svc.SetReaction(context.Background(), 1, "👍", true)
+	if !errors.Is(err, ErrNoClientID) {
+		t.Fatalf("expected ErrNoClientID, got %v", err)
+	}
+}
+
+/*
+	PIN COMMENT
+*/
+
+func TestSetCommentPin_OK(t *testing.T) {
+	now := time.Now()
+	repo := &mockRepo{
+		getByIDFn: func(ctx context.Context, id int) (*model.DBComment, error) {
+			return &model.DBComment{ID: id}, nil
+		},
+		setPinnedFn: func(ctx context.Context, id int, pinned bool) (*model.DBComment, error) {
+			return &model.DBComment{ID: id, PinnedAt: &now}, nil
+		},
+	}
+
+	svc := NewCommentService(repo, Config{})
+
+	res, err := svc.SetCommentPin(asUser(1, model.RoleModerator), 1, true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !res.Pinned {
+		t.Fatalf("expected pinned comment")
+	}
+}
+
+func TestSetCommentPin_NotRoot(t *testing.T) {
+	repo := &mockRepo{
+		getByIDFn: func(ctx context.Context, id int) (*model.DBComment, error) {
+			return &model.DBComment{ID: id, ParentID: ptr(1)}, nil
+		},
+	}
+
+	svc := NewCommentService(repo, Config{})
+
+	_, err := svc.SetCommentPin(asUser(1, model.RoleModerator), 2, true)
+	if !errors.Is(err, ErrPinNotRoot) {
+		t.Fatalf("expected ErrPinNotRoot")
+	}
+}
+
+/*
+	VOTES
+*/
+
+func TestVoteComment_OK(t *testing.T) {
+	repo := &mockRepo{
+		getByIDFn: func(ctx context.Context, id int) (*model.DBComment, error) {
+			return &model.DBComment{ID: id}, nil
+		},
+		voteFn: func(ctx context.Context, id int, voter string, value int) (*model.DBComment, error) {
+			if voter != "user:7" || value != -1 {
+				t.Fatalf("unexpected vote %q=%d", voter, value)
+			}
+			return &model.DBComment{ID: id, Upvotes: 2, Downvotes: 3}, nil
+		},
+	}
+
+	svc := NewCommentService(repo, Config{})
+
+	// X-Client-Id вошедшего заменяется его id - голосовать от чужого имени нельзя
+	res, err := svc.VoteComment(asUser(7, model.RoleUser), 1, &model.CommentVoteData{Value: -1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Score != -1 || res.Upvotes != 2 || res.Downvotes != 3 {
+		t.Fatalf("unexpected score: %+v", res)
+	}
+}
+
+func TestVoteComment_VoterFromClient(t *testing.T) {
+	votes := map[string]int{}
+	repo := &mockRepo{
+		getByIDFn: func(ctx context.Context, id int) (*model.DBComment, error) {
+			return &model.DBComment{ID: id}, nil
+		},
+		voteFn: func(ctx context.Context, id int, voter string, value int) (*model.DBComment, error) {
+			votes[voter] = value // один голос на клиента, повторный заменяет прежний
+			res := &model.DBComment{ID: id}
+			for _, v := range votes {
+				if v > 0 {
+					res.Upvotes++
+				}
+			}
+			return res, nil
+		},
+	}
+
+	svc := NewCommentService(repo, Config{})
+
+	// голосующий из тела запроса не читается: тело с чужим именем голосует от X-Client-Id
+	var data model.CommentVoteData
+	if err := json.Unmarshal([]byte(`{"value": 1, "voter": "alice"}`), &data); err != nil {
+		t.Fatalf("failed to decode body: %v", err)
+	}
+	if _, err := svc.VoteComment(mwclient.WithClient(context.Background(), "a"), 1, &data); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := votes["alice"]; ok || votes["a"] != 1 {
+		t.Fatalf("expected vote counted for client id, got %v", votes)
+	}
+
+	// повторный голос того же клиента не добавляет голосов, другой клиент - добавляет
+	if _, err := svc.VoteComment(mwclient.WithClient(context.Background(), "a"), 1, &model.CommentVoteData{Value: 1}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	res, err := svc.VoteComment(mwclient.WithClient(context.Background(), "b"), 1, &model.CommentVoteData{Value: 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Upvotes != 2 || len(votes) != 2 {
+		t.Fatalf("expected two client ids to count as two votes, got %d (%v)", res.Upvotes, votes)
+	}
+}
+
+func TestVoteComment_Invalid(t *testing.T) {
+	svc := NewCommentService(&mockRepo{}, Config{})
+
+	client := mwclient.WithClient(context.Background(), "a")
+	if _, err := svc.VoteComment(client, 1, &model.CommentVoteData{Value: 2}); !errors.Is(err, ErrInvalidVote) {
+		t.Fatalf("expected ErrInvalidVote, got %v", err)
+	}
+	if _, err := svc.VoteComment(context.Background(), 1, &model.CommentVoteData{Value: 1}); !errors.Is(err, ErrNoClientID) {
+		t.Fatalf("expected ErrNoClientID, got %v", err)
+	}
+}
+
+func TestVoteComment_Deleted(t *testing.T) {
+	now := time.Now()
+	repo := &mockRepo{
+		getByIDFn: func(ctx context.Context, id int) (*model.DBComment, error) {
+			return &model.DBComment{ID: id, DeletedAt: &now}, nil
+		},
+		voteFn: func(ctx context.Context, id int, voter string, value int) (*model.DBComment, error) {
+			return &model.DBComment{ID: id, DeletedAt: &now}, nil
+		},
+	}
+
+	svc := NewCommentService(repo, Config{})
+
+	client := mwclient.WithClient(context.Background(), "a")
+	_, err := svc.VoteComment(client, 1, &model.CommentVoteData{Value: 1})
+	if !errors.Is(err, ErrCommentDeleted) {
+		t.Fatalf("expected ErrCommentDeleted, got %v", err)
+	}
+
+	// отозвать голос у удалённого можно
+	if _, err := svc.VoteComment(client, 1, &model.CommentVoteData{}); err != nil {
+		t.Fatalf("unexpected error on retract: %v", err)
+	}
+}
+
+func TestValidateRequest_VoteSorts(t *testing.T) {
+	tests := map[string]string{
+		"top":           model.SortTop,
+		"controversial": model.SortControversial,
+		" HOT ":         model.SortHot,
+	}
+	for sort, want := range tests {
+		req := &model.RootRequest{Sort: sort}
+		validateRequest(req)
+		if req.Sort != want {
+			t.Fatalf("expected sort %q, got %q", want, req.Sort)
+		}
+	}
+}
+
+/*
+	USERS
+*/
+
+type mockUserRepo struct {
+	createFn    func(ctx context.Context, username, passwordHash, role string) (*model.DBUser, error)
+	getByNameFn func(ctx context.Context, username string) (*model.DBUser, error)
+	setRoleFn   func(ctx context.Context, username, role string) (*model.DBUser, error)
+	getByIDFn   func(ctx context.Context, id int) (*model.DBUser, error)
+}
+
+func (m *mockUserRepo) CreateUser(ctx context.Context, username, passwordHash, role string) (*model.DBUser, error) {
+	return m.createFn(ctx, username, passwordHash, role)
+}
+
+func (m *mockUserRepo) SetUserRole(ctx context.Context, username, role string) (*model.DBUser, error) {
+	return m.setRoleFn(ctx, username, role)
+}
+
+func (m *mockUserRepo) GetUserByName(ctx context.Context, username string) (*model.DBUser, error) {
+	return m.getByNameFn(ctx, username)
+}
+
+func (m *mockUserRepo) GetUserByID(ctx context.Context, id int) (*model.DBUser, error) {
+	return m.getByIDFn(ctx, id)
+}
+
+func TestRegister_OK(t *testing.T) {
+	repo := &mockUserRepo{
+		createFn: func(ctx context.Context, username, passwordHash, role string) (*model.DBUser, error) {
+			if username != "alice" || role != model.RoleUser || !auth.CheckPassword(passwordHash, "password123") {
+				t.Fatalf("expected trimmed username and bcrypt hash")
+			}
+			return &model.DBUser{ID: 1, Username: username}, nil
+		},
+	}
+
+	svc := NewUserService(repo, auth.NewTokens("secret", time.Hour))
+
+	res, err := svc.Register(context.Background(), &model.UserCredentials{Username: " alice ", Password: "password123"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.ID != 1 {
+		t.Fatalf("unexpected user: %+v", res)
+	}
+}
+
+func TestRegister_Invalid(t *testing.T) {
+	svc := NewUserService(&mockUserRepo{}, auth.NewTokens("secret", time.Hour))
+
+	for _, data := range []model.UserCredentials{
+		{Username: "al", Password: "password123"},
+		{Username: "alice bob", Password: "password123"},
+		{Username: "alice", Password: "short"},
+	} {
+		if _, err := svc.Register(context.Background(), &data); !errors.Is(err, ErrInvalidUser) {
+			t.Fatalf("expected ErrInvalidUser for %+v, got %v", data, err)
+		}
+	}
+}
+
+func TestLogin(t *testing.T) {
+	hash, _ := auth.HashPassword("password123")
+	repo := &mockUserRepo{
+		getByNameFn: func(ctx context.Context, username string) (*model.DBUser, error) {
+			if username != "alice" {
+				return nil, repository.ErrUserNotFound
+			}
+			return &model.DBUser{ID: 1, Username: username, PasswordHash: hash}, nil
+		},
+	}
+	tokens := auth.NewTokens("secret", time.Hour)
+	svc := NewUserService(repo, tokens)
+
+	res, err := svc.Login(context.Background(), &model.UserCredentials{Username: "alice", Password: "password123"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if claims, err := tokens.Parse(res.Token); err != nil || claims.UserID != 1 {
+		t.Fatalf("expected valid token for user 1, got %v", err)
+	}
+
+	for _, data := range []model.UserCredentials{
+		{Username: "alice", Password: "wrong-password"},
+		{Username: "bob", Password: "password123"},
+	} {
+		if _, err := svc.Login(context.Background(), &data); !errors.Is(err, ErrBadCredentials) {
+			t.Fatalf("expected ErrBadCredentials for %+v, got %v", data, err)
+		}
+	}
+}
+
+func TestSetUserRole(t *testing.T) {
+	repo := &mockUserRepo{
+		setRoleFn: func(ctx context.Context, username, role string) (*model.DBUser, error) {
+			return &model.DBUser{ID: 2, Username: username, Role: role}, nil
+		},
+	}
+
+	svc := NewUserService(repo, auth.NewTokens("secret", time.Hour))
+
+	if _, err := svc.SetUserRole(asUser(1, model.RoleModerator), "bob", &model.UserRoleData{Role: model.RoleAdmin}); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected ErrForbidden for moderator, got %v", err)
+	}
+	if _, err := svc.SetUserRole(asUser(1, model.RoleAdmin), "bob", &model.UserRoleData{Role: "root"}); !errors.Is(err, ErrInvalidRole) {
+		t.Fatalf("expected ErrInvalidRole, got %v", err)
+	}
+
+	res, err := svc.SetUserRole(asUser(1, model.RoleAdmin), "bob", &model.UserRoleData{Role: model.RoleModerator})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Role != model.RoleModerator {
+		t.Fatalf("unexpected role %q", res.Role)
+	}
+}
+
+func TestCurrentRole(t *testing.T) {
+	repo := &mockUserRepo{
+		getByIDFn: func(ctx context.Context, id int) (*model.DBUser, error) {
+			if id != 1 {
+				return nil, repository.ErrUserNotFound
+			}
+			return &model.DBUser{ID: 1, Username: "alice", Role: model.RoleModerator}, nil
+		},
+	}
+
+	svc := NewUserService(repo, auth.NewTokens("secret", time.Hour))
+
+	if role, ok, err := svc.CurrentRole(context.Background(), 1); err != nil || !ok || role != model.RoleModerator {
+		t.Fatalf("expected moderator role from DB, got %q %v %v", role, ok, err)
+	}
+	if _, ok, err := svc.CurrentRole(context.Background(), 2); err != nil || ok {
+		t.Fatalf("expected deleted user to be rejected, got %v %v", ok, err)
+	}
+}
+
+/*
+	MODERATION
+*/
+
+func TestCreateComment_Premoderation(t *testing.T) {
+	repo := &mockRepo{
+		threadPremodFn: func(ctx context.Context, key string) (bool, error) {
+			return key == "article-1", nil
+		},
+		createFn: func(ctx context.Context, c *model.CommentCreateData) (*model.DBComment, error) {
+			return &model.DBComment{ID: 1, ThreadKey: c.ThreadKey, Status: c.Status}, nil
+		},
+	}
+
+	svc := NewCommentService(repo, Config{})
+
+	tests := []struct {
+		name   string
+		ctx    context.Context
+		thread string
+		want   string
+	}{
+		{"premoderated thread", asUser(7, model.RoleUser), "article-1", "pending"},
+		{"moderator skips queue", asUser(8, model.RoleModerator), "article-1", ""},
+		{"key with moderation scope skips queue", asKey(model.ScopeCommentsWrite, model.ScopeModeration), "article-1", ""},
+		{"regular thread", asUser(7, model.RoleUser), "article-2", ""},
+	}
+
+	for _, tt := range tests {
+		res, err := svc.CreateComment(tt.ctx, &model.CommentCreateData{Text: "hi", ThreadKey: tt.thread})
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+		if res.Status != tt.want {
+			t.Fatalf("%s: expected status %q, got %q", tt.name, tt.want, res.Status)
+		}
+	}
+
+	// премодерация всего развёртывания не требует отметки потока
+	svc = NewCommentService(repo, Config{Premoderation: true})
+	res, err := svc.CreateComment(context.Background(), &model.CommentCreateData{Text: "hi", ThreadKey: "article-2"})
+	if err != nil || res.Status != model.StatusPending {
+		t.Fatalf("expected pending comment, got %+v, %v", res, err)
+	}
+}
+
+func TestGetCommentWithChildren_PendingVisibleToAuthor(t *testing.T) {
+	repo := &mockRepo{
+		getByIDFn: func(ctx context.Context, id int) (*model.DBComment, error) {
+			return &model.DBComment{ID: id, AuthorID: ptr(7), Status: model.StatusPending}, nil
+		},
+		getWithChildrenFn: func(ctx context.Context, id, depth, viewer int) ([]model.DBComment, error) {
+			if viewer != 7 {
+				t.Fatalf("expected author as viewer, got %d", viewer)
+			}
+			return []model.DBComment{{ID: id, AuthorID: ptr(7), Status: model.StatusPending}}, nil
+		},
+	}
+
+	svc := NewCommentService(repo, Config{})
+
+	if _, err := svc.GetCommentWithChildren(asUser(8, model.RoleUser), 1, &model.TreeRequest{}); !errors.Is(err, ErrParentNotFound) {
+		t.Fatalf("expected ErrParentNotFound for other user, got %v", err)
+	}
+
+	res, err := svc.GetCommentWithChildren(asUser(7, model.RoleUser), 1, &model.TreeRequest{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(res) != 1 || res[0].Status != model.StatusPending {
+		t.Fatalf("expected pending comment for its author, got %+v", res)
+	}
+}
+
+func TestCreateComment_ReplyToPendingParent(t *testing.T) {
+	repo := &mockRepo{
+		getByIDFn: func(ctx context.Context, id int) (*model.DBComment, error) {
+			return &model.DBComment{ID: id, AuthorID: ptr(7), Status: model.StatusPending}, nil
+		},
+	}
+
+	svc := NewCommentService(repo, Config{})
+
+	_, err := svc.CreateComment(asUser(8, model.RoleUser), &model.CommentCreateData{ParentID: ptr(1), Text: "hi"})
+	if !errors.Is(err, ErrParentNotFound) {
+		t.Fatalf("expected ErrParentNotFound, got %v", err)
+	}
+}
+
+func TestModerateComment(t *testing.T) {
+	repo := &mockRepo{
+		moderateFn: func(ctx context.Context, id int, status, reason, by string) (*model.DBComment, error) {
+			return &model.DBComment{ID: id, Status: status, ModReason: reason, ModBy: by}, nil
+		},
+	}
+
+	svc := NewCommentService(repo, Config{})
+
+	res, err := svc.ModerateComment(asUser(8, model.RoleModerator), 1, model.StatusRejected, &model.CommentModerationData{Reason: " spam "})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Status != model.StatusRejected || res.ModReason != "spam" {
+		t.Fatalf("unexpected moderated comment: %+v", res)
+	}
+
+	tests := []struct {
+		name   string
+		ctx    context.Context
+		status string
+		reason string
+		want   error
+	}{
+		{"reject without reason", asUser(8, model.RoleModerator), model.StatusRejected, " ", ErrBadReason},
+		{"approve without reason", asUser(8, model.RoleModerator), model.StatusApproved, "", nil},
+		{"regular user", asUser(7, model.RoleUser), model.StatusApproved, "", ErrForbidden},
+		{"anonymous", context.Background(), model.StatusApproved, "", ErrUnauthorized},
+	}
+
+	for _, tt := range tests {
+		_, err := svc.ModerateComment(tt.ctx, 1, tt.status, &model.CommentModerationData{Reason: tt.reason})
+		if !errors.Is(err, tt.want) {
+			t.Fatalf("%s: expected %v, got %v", tt.name, tt.want, err)
+		}
+	}
+}
+
+/*
+	REPORTS
+*/
+
+func TestReportComment_HidesAtThreshold(t *testing.T) {
+	reporters := map[string]bool{}
+	hidden := false
+	repo := &mockRepo{
+		getByIDFn: func(ctx context.Context, id int) (*model.DBComment, error) {
+			return &model.DBComment{ID: id}, nil
+		},
+		addReportFn: func(ctx context.Context, id int, reporter string, data *model.CommentReportData) (int, error) {
+			reporters[reporter] = true // повторная жалоба того же пользователя не добавляет новую
+			return len(reporters), nil
+		},
+		setHiddenFn: func(ctx context.Context, id int, on bool) error {
+			hidden = on
+			return nil
+		},
+	}
+
+	svc := NewCommentService(repo, Config{ReportThreshold: 2})
+	report := func(ctx context.Context) *APPReport {
+		res, err := svc.ReportComment(ctx, 1, &model.CommentReportData{Reason: model.ReportSpam})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return res
+	}
+
+	if res := report(asUser(7, model.RoleUser)); res.Reports != 1 || res.Hidden {
+		t.Fatalf("unexpected first report: %+v", res)
+	}
+	// другой X-Client-Id того же пользователя - всё та же жалоба
+	if res := report(mwclient.WithClient(asUser(7, model.RoleUser), "spoofed")); res.Reports != 1 || hidden {
+		t.Fatalf("expected duplicate report to be ignored: %+v", res)
+	}
+	if res := report(asKey()); res.Reports != 2 || !res.Hidden || !hidden {
+		t.Fatalf("expected comment hidden at threshold: %+v", res)
+	}
+}
+
+func TestReportComment_Invalid(t *testing.T) {
+	repo := &mockRepo{
+		getByIDFn: func(ctx context.Context, id int) (*model.DBComment, error) {
+			return &model.DBComment{ID: id}, nil
+		},
+	}
+
+	svc := NewCommentService(repo, Config{})
+
+	if _, err := svc.ReportComment(asUser(7, model.RoleUser), 1, &model.CommentReportData{Reason: "boring"}); !errors.Is(err, ErrBadReport) {
+		t.Fatalf("expected ErrBadReport, got %v", err)
+	}
+	// анонимный X-Client-Id подменяется, жалобы от него не принимаются
+	anon := mwclient.WithClient(context.Background(), "a")
+	if _, err := svc.ReportComment(anon, 1, &model.CommentReportData{Reason: model.ReportSpam}); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("expected ErrUnauthorized, got %v", err)
+	}
+}
+
+func TestResolveReports(t *testing.T) {
+	var resolution string
+	open := 3
+	repo := &mockRepo{
+		getByIDFn: func(ctx context.Context, id int) (*model.DBComment, error) {
+			return &model.DBComment{ID: id}, nil
+		},
+		resolveFn: func(ctx context.Context, id int, action, by string) (int, error) {
+			if by != "user8" {
+				t.Fatalf("expected moderator name, got %q", by)
+			}
+			resolution = action
+			n := open
+			open = 0
+			return n, nil
+		},
+	}
+
+	svc := NewCommentService(repo, Config{})
+
+	res, err := svc.ResolveReports(asUser(8, model.RoleModerator), 1, &model.ReportResolveData{Action: model.ResolveHide})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Resolved != 3 || resolution != model.ResolveHide {
+		t.Fatalf("expected reports resolved with hide: %+v", res)
+	}
+
+	// после hide жалоб не осталось, но dismiss всё равно доходит до репозитория и снимает скрытие
+	res, err = svc.ResolveReports(asUser(8, model.RoleModerator), 1, &model.ReportResolveData{Action: model.ResolveDismiss})
+	if err != nil || res.Resolved != 0 || resolution != model.ResolveDismiss {
+		t.Fatalf("expected dismiss to reach repository, got %+v, %v", res, err)
+	}
+
+	// удалить навсегда может только админ
+	resolution = ""
+	if _, err := svc.ResolveReports(asUser(8, model.RoleModerator), 1, &model.ReportResolveData{Action: model.ResolveDelete}); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected ErrForbidden, got %v", err)
+	}
+	if resolution != "" {
+		t.Fatalf("expected comment to stay")
+	}
+	if _, err := svc.ResolveReports(asUser(8, model.RoleModerator), 1, &model.ReportResolveData{Action: "ban"}); !errors.Is(err, ErrBadResolution) {
+		t.Fatalf("expected ErrBadResolution, got %v", err)
+	}
+	if _, err := svc.ResolveReports(asUser(7, model.RoleUser), 1, &model.ReportResolveData{Action: model.ResolveDismiss}); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected ErrForbidden for regular user, got %v", err)
+	}
+}
+
+/*
+	CONTENT FILTERS
+*/
+
+func TestContentFilters_Builtin(t *testing.T) {
+	filters, err := NewContentFilters(FilterConfig{
+		Blocklist: []string{"Ёлка", " spam "},
+		MaxLinks:  1,
+		MaxRepeat: 3,
+		MaxLength: 20,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(filters) != 4 {
+		t.Fatalf("expected 4 filters, got %d", len(filters))
+	}
+
+	tests := []struct {
+		filter ContentFilter
+		text   string
+		action string
+		masked string
+	}{
+		{filters[0], "купи елку, не ЁЛКУ", "", ""},
+		{filters[0], "SPAM и е\u200bлка!", FilterMask, "**** и ****!"}, // невидимый пробел внутри слова не спасает
+		{filters[1], "см. https://a.ru", "", ""},
+		{filters[1], "https://a.ru и www.b.ru", FilterModerate, "https://a.ru и [link]"},
+		{filters[2], "ура!!!", "", ""},
+		{filters[2], "урааааа!!!!", FilterMask, "урааа!!!"},
+		{filters[3], "короткий текст", "", ""},
+		{filters[3], strings.Repeat("я", 21), FilterReject, strings.Repeat("я", 20)},
+	}
+
+	for _, tt := range tests {
+		v := tt.filter.Check(tt.text)
+		if v.Action != tt.action {
+			t.Fatalf("%s(%q): expected action %q, got %q", tt.filter.Name(), tt.text, tt.action, v.Action)
+		}
+		if tt.action != "" && (v.Text != tt.masked || v.Detail == "") {
+			t.Fatalf("%s(%q): unexpected verdict %+v", tt.filter.Name(), tt.text, v)
+		}
+	}
+}
+
+func TestNewContentFilters_BadAction(t *testing.T) {
+	if _, err := NewContentFilters(FilterConfig{MaxLinks: 2, LinksAction: "ban"}); err == nil {
+		t.Fatalf("expected error for unknown action")
+	}
+	filters, err := NewContentFilters(FilterConfig{Blocklist: []string{"", " "}})
+	if err != nil || len(filters) != 0 {
+		t.Fatalf("expected empty blocklist to be skipped, got %d filters, err %v", len(filters), err)
+	}
+}
+
+func TestCreateComment_Filtered(t *testing.T) {
+	var saved model.CommentCreateData
+	repo := &mockRepo{
+		createFn: func(ctx context.Context, c *model.CommentCreateData) (*model.DBComment, error) {
+			saved = *c
+			return &model.DBComment{ID: 1, Text: c.Text, Status: c.Status}, nil
+		},
+	}
+
+	filters, err := NewContentFilters(FilterConfig{Blocklist: []string{"дурак"}, MaxLinks: 1, MaxLength: 30})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	svc := NewCommentService(repo, Config{Filters: filters})
+
+	_, err = svc.CreateComment(context.Background(), &model.CommentCreateData{Text: strings.Repeat("a", 31)})
+	var filterErr *FilterError
+	if !errors.Is(err, ErrFiltered) || !errors.As(err, &filterErr) || filterErr.Filter != "length" {
+		t.Fatalf("expected length filter rejection, got %v", err)
+	}
+
+	if _, err := svc.CreateComment(context.Background(), &model.CommentCreateData{Text: "сам Дурак"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if saved.Text != "сам *****" || saved.Status != model.StatusApproved {
+		t.Fatalf("expected masked approved comment, got %+v", saved)
+	}
+
+	links := "http://a.ru http://b.ru"
+	if _, err := svc.CreateComment(asUser(7, model.RoleUser), &model.CommentCreateData{Text: links}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if saved.Status != model.StatusPending || saved.ModReason == "" || saved.Text != links {
+		t.Fatalf("expected comment sent to moderation, got %+v", saved)
+	}
+
+	// модератор в очередь не попадает
+	if _, err := svc.CreateComment(asUser(8, model.RoleModerator), &model.CommentCreateData{Text: links}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if saved.Status != model.StatusApproved || saved.ModReason != "" {
+		t.Fatalf("expected moderator comment approved, got %+v", saved)
+	}
+}
+
+func TestEditComment_FilterModerate(t *testing.T) {
+	var status, reason string
+	repo := &mockRepo{
+		getByIDFn: func(ctx context.Context, id int) (*model.DBComment, error) {
+			return &model.DBComment{ID: id, Text: "old", AuthorID: ptr(7), Status: model.StatusApproved}, nil
+		},
+		updateTextFn: func(ctx context.Context, id int, prevText, text string, diff []byte) (*model.DBComment, error) {
+			return &model.DBComment{ID: id, Text: text, AuthorID: ptr(7), Status: model.StatusApproved}, nil
+		},
+		moderateFn: func(ctx context.Context, id int, s, r, by string) (*model.DBComment, error) {
+			status, reason = s, r
+			return &model.DBComment{ID: id, AuthorID: ptr(7), Status: s, ModReason: r}, nil
+		},
+	}
+
+	filters, err := NewContentFilters(FilterConfig{MaxLinks: 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	svc := NewCommentService(repo, Config{Filters: filters})
+
+	res, err := svc.EditComment(asUser(7, model.RoleUser), 1, &model.CommentEditData{Text: "www.a.ru www.b.ru"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if status != model.StatusPending || reason == "" || res.Status != model.StatusPending {
+		t.Fatalf("expected edited comment back in moderation, got %+v", res)
+	}
+}
+
+/*
+	SPAM CLASSIFIER
+*/
+
+// trainedStats - классификатор, который видел casino только в спаме, а hello - только в обычных комментариях
+func trainedStats(ctx context.Context, tokens []string) (*model.DBSpamStats, error) {
+	return &model.DBSpamStats{
+		SpamDocs: 20,
+		HamDocs:  20,
+		Tokens: map[string]model.SpamTokenCount{
+			"casino":       {Spam: 18, Ham: 0},
+			"~host:win.io": {Spam: 15, Ham: 0},
+			"hello":        {Spam: 0, Ham: 15},
+			"~author:anon": {Spam: 12, Ham: 8},
+		},
+	}, nil
+}
+
+func TestSpamTokens(t *testing.T) {
+	tokens := spamTokens("Casino CASINO https://www.Win.io/bonus a", nil, false, true)
+	for _, want := range []string{"casino", "~host:win.io", "~author:anon", "~links:1", "~reply"} {
+		if !slices.Contains(tokens, want) {
+			t.Fatalf("expected token %q in %v", want, tokens)
+		}
+	}
+	if slices.Contains(tokens, "a") {
+		t.Fatalf("expected single-letter words to be skipped: %v", tokens)
+	}
+
+	tokens = spamTokens("hello", ptr(7), false, false)
+	if !slices.Contains(tokens, "~user:7") || slices.Contains(tokens, "~author:anon") {
+		t.Fatalf("unexpected author features: %v", tokens)
+	}
+
+	// подписанный гость остаётся анонимом, ключ интеграции определяется не по имени
+	if tokens = spamTokens("hello", nil, true, false); !slices.Contains(tokens, "~author:key") {
+		t.Fatalf("expected key author feature: %v", tokens)
+	}
+}
+
+func TestSpamScore(t *testing.T) {
+	stats, _ := trainedStats(context.Background(), nil)
+
+	if s := spamScore(stats, spamTokens("casino at https://win.io", nil, false, false)); s < 0.9 {
+		t.Fatalf("expected high spam score, got %f", s)
+	}
+	if s := spamScore(stats, spamTokens("hello there", ptr(7), false, false)); s > 0.1 {
+		t.Fatalf("expected low spam score, got %f", s)
+	}
+	if s := spamScore(&model.DBSpamStats{}, []string{"anything"}); s != 0.5 {
+		t.Fatalf("expected neutral score for untrained classifier, got %f", s)
+	}
+}
+
+func TestCreateComment_SpamScore(t *testing.T) {
+	var saved model.CommentCreateData
+	repo := &mockRepo{
+		createFn: func(ctx context.Context, c *model.CommentCreateData) (*model.DBComment, error) {
+			saved = *c
+			return &model.DBComment{ID: 1, Text: c.Text, Status: c.Status, SpamScore: c.SpamScore}, nil
+		},
+		spamStatsFn: trainedStats,
+	}
+
+	svc := NewCommentService(repo, Config{Spam: SpamConfig{Threshold: 0.9, MinTraining: 10}})
+
+	if _, err := svc.CreateComment(context.Background(), &model.CommentCreateData{Text: "casino https://win.io"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if saved.Status != model.StatusPending || saved.SpamScore == nil || !strings.Contains(saved.ModReason, "spam score") {
+		t.Fatalf("expected suspicious comment sent to moderation, got %+v", saved)
+	}
+
+	if _, err := svc.CreateComment(context.Background(), &model.CommentCreateData{Text: "hello"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if saved.Status != model.StatusApproved || saved.SpamScore == nil {
+		t.Fatalf("expected scored approved comment, got %+v", saved)
+	}
+
+	// пока классификатор мало обучен, оценка сохраняется, но на статус не влияет
+	svc = NewCommentService(repo, Config{Spam: SpamConfig{Threshold: 0.9, MinTraining: 50}})
+	if _, err := svc.CreateComment(context.Background(), &model.CommentCreateData{Text: "casino https://win.io"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if saved.Status != model.StatusApproved || saved.SpamScore == nil || *saved.SpamScore < 0.9 {
+		t.Fatalf("expected undertrained classifier not to route comment, got %+v", saved)
+	}
+}
+
+func TestModerateComment_TrainsSpam(t *testing.T) {
+	var labels []string
+	repo := &mockRepo{
+		moderateFn: func(ctx context.Context, id int, status, reason, by string) (*model.DBComment, error) {
+			return &model.DBComment{ID: id, Text: "casino", Status: status}, nil
+		},
+		trainSpamFn: func(ctx context.Context, id int, label string, tokens []string) error {
+			if !slices.Contains(tokens, "casino") {
+				t.Fatalf("expected comment text in training tokens: %v", tokens)
+			}
+			labels = append(labels, label)
+			return errors.New("db is down") // сбой обучения не отменяет решение
+		},
+	}
+
+	svc := NewCommentService(repo, Config{Spam: SpamConfig{Threshold: 0.9}})
+	ctx := asUser(8, model.RoleModerator)
+
+	if _, err := svc.ModerateComment(ctx, 1, model.StatusRejected, &model.CommentModerationData{Reason: "spam"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := svc.ModerateComment(ctx, 1, model.StatusApproved, &model.CommentModerationData{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !slices.Equal(labels, []string{model.SpamLabel, model.HamLabel}) {
+		t.Fatalf("unexpected training labels: %v", labels)
+	}
+}
+
+func TestDeleteComment_TrainsSpam(t *testing.T) {
+	trained := 0
+	repo := &mockRepo{
+		getByIDFn: func(ctx context.Context, id int) (*model.DBComment, error) {
+			return &model.DBComment{ID: id, Text: "casino", AuthorID: ptr(7)}, nil
+		},
+		markDeletedFn: func(ctx context.Context, id int) error {
+			return nil
+		},
+		trainSpamFn: func(ctx context.Context, id int, label string, tokens []string) error {
+			if label != model.SpamLabel {
+				t.Fatalf("expected spam label, got %q", label)
+			}
+			trained++
+			return nil
+		},
+	}
+
+	svc := NewCommentService(repo, Config{Spam: SpamConfig{Threshold: 0.9}})
+
+	// автор убирает свой комментарий - это не сигнал спама
+	if err := svc.DeleteCommentByID(asUser(7, model.RoleUser), 1, true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := svc.DeleteCommentByID(asUser(8, model.RoleModerator), 1, true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if trained != 1 {
+		t.Fatalf("expected one training on moderator delete, got %d", trained)
+	}
+}
+
+func TestResolveReportsDelete_TrainsSpam(t *testing.T) {
+	var labels []string
+	repo := &mockRepo{
+		getByIDFn: func(ctx context.Context, id int) (*model.DBComment, error) {
+			return &model.DBComment{ID: id, Text: "casino", AuthorID: ptr(7)}, nil
+		},
+		resolveFn: func(ctx context.Context, id int, action, by string) (int, error) {
+			return 2, nil
+		},
+		trainSpamFn: func(ctx context.Context, id int, label string, tokens []string) error {
+			if !slices.Contains(tokens, "casino") {
+				t.Fatalf("expected comment text in training tokens: %v", tokens)
+			}
+			labels = append(labels, label)
+			return nil
+		},
+	}
+
+	svc := NewCommentService(repo, Config{Spam: SpamConfig{Threshold: 0.9}})
+	ctx := asUser(1, model.RoleAdmin)
+
+	// dismiss классификатор не учит
+	if _, err := svc.ResolveReports(ctx, 1, &model.ReportResolveData{Action: model.ResolveDismiss}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := svc.ResolveReports(ctx, 1, &model.ReportResolveData{Action: model.ResolveDelete}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !slices.Equal(labels, []string{model.SpamLabel}) {
+		t.Fatalf("expected one spam training on delete, got %v", labels)
+	}
+}
+
+/*
+	RATE LIMITS
+*/
+
+// fakeLimits - хранилище лимитов, запоминающее ключи вёдер
+type fakeLimits struct {
+	keys  []string
+	retry time.Duration
+	err   error
+}
+
+func (f *fakeLimits) Take(ctx context.Context, buckets []ratelimit.Bucket) (time.Duration, error) {
+	f.keys = f.keys[:0]
+	for _, b := range buckets {
+		f.keys = append(f.keys, b.Key)
+	}
+	return f.retry, f.err
+}
+
+func TestCreateComment_RateLimited(t *testing.T) {
+	created := 0
+	repo := &mockRepo{
+		createFn: func(ctx context.Context, c *model.CommentCreateData) (*model.DBComment, error) {
+			created++
+			return &model.DBComment{ID: 1, Text: c.Text}, nil
+		},
+	}
+
+	store := &fakeLimits{retry: 12 * time.Second}
+	rule := ratelimit.Rule{Limit: 1, Per: time.Minute}
+	svc := NewCommentService(repo, Config{RateLimit: RateLimitConfig{Store: store, Author: rule, IP: rule, Thread: rule}})
+
+	ctx := mwclient.WithIP(asUser(7, model.RoleUser), "10.0.0.1")
+	_, err := svc.CreateComment(ctx, &model.CommentCreateData{Text: "hi", ThreadKey: "news"})
+	var rateErr *RateLimitError
+	if !errors.Is(err, ErrRateLimited) || !errors.As(err, &rateErr) || rateErr.RetryAfter != 12*time.Second {
+		t.Fatalf("expected rate limit error, got %v", err)
+	}
+	if created != 0 {
+		t.Fatalf("expected comment not to be created")
+	}
+	if !slices.Equal(store.keys, []string{"author:user:7", "ip:10.0.0.1", "thread:news"}) {
+		t.Fatalf("unexpected bucket keys: %v", store.keys)
+	}
+
+	// гость меняет X-Client-Id как хочет - его ограничивает только IP
+	guest := mwclient.WithIP(mwclient.WithClient(context.Background(), "guest-1"), "10.0.0.2")
+	if _, err := svc.CreateComment(guest, &model.CommentCreateData{Text: "hi", ThreadKey: "news"}); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expected rate limit error, got %v", err)
+	}
+	if !slices.Equal(store.keys, []string{"ip:10.0.0.2", "thread:news"}) {
+		t.Fatalf("unexpected guest bucket keys: %v", store.keys)
+	}
+
+	// модератор не ограничивается
+	store.keys = nil
+	if _, err := svc.CreateComment(asUser(8, model.RoleModerator), &model.CommentCreateData{Text: "hi"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if store.keys != nil {
+		t.Fatalf("expected moderator to skip rate limits")
+	}
+
+	// хранилище недоступно - комментарий всё равно создаётся
+	store.retry, store.err = 0, errors.New("db is down")
+	if _, err := svc.CreateComment(ctx, &model.CommentCreateData{Text: "hi"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if created != 2 {
+		t.Fatalf("expected 2 created comments, got %d", created)
+	}
+}
+
+/*
+	API KEYS
+*/
+
+type mockKeyRepo struct {
+	createFn func(ctx context.Context, key *model.DBAPIKey) (*model.DBAPIKey, error)
+	listFn   func(ctx context.Context) ([]model.DBAPIKey, error)
+	revokeFn func(ctx context.Context, id int) (*model.DBAPIKey, error)
+	useFn    func(ctx context.Context, keyHash string) (*model.DBAPIKey, error)
+}
+
+func (m *mockKeyRepo) CreateAPIKey(ctx context.Context, key *model.DBAPIKey) (*model.DBAPIKey, error) {
+	return m.createFn(ctx, key)
+}
+
+func (m *mockKeyRepo) ListAPIKeys(ctx context.Context) ([]model.DBAPIKey, error) {
+	return m.listFn(ctx)
+}
+
+func (m *mockKeyRepo) RevokeAPIKey(ctx context.Context, id int) (*model.DBAPIKey, error) {
+	return m.revokeFn(ctx, id)
+}
+
+func (m *mockKeyRepo) UseAPIKey(ctx context.Context, keyHash string) (*model.DBAPIKey, error) {
+	return m.useFn(ctx, keyHash)
+}
+
+func TestCreateAPIKey_OK(t *testing.T) {
+	var stored model.DBAPIKey
+	repo := &mockKeyRepo{
+		createFn: func(ctx context.Context, key *model.DBAPIKey) (*model.DBAPIKey, error) {
+			stored = *key
+			key.ID = 1
+			return key, nil
+		},
+	}
+
+	svc := NewAPIKeyService(repo)
+
+	res, err := svc.CreateAPIKey(asUser(1, model.RoleAdmin), &model.APIKeyCreateData{
+		Name:   " billing ",
+		Scopes: []string{model.ScopeModeration, model.ScopeCommentsWrite, model.ScopeModeration},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Key == "" || stored.KeyHash != auth.HashSecret(res.Key) || stored.Prefix != res.Key[:len(stored.Prefix)] {
+		t.Fatalf("expected only hash and prefix of returned key to be stored: %+v", stored)
+	}
+	if stored.Name != "billing" || stored.CreatedBy != "user1" {
+		t.Fatalf("unexpected stored key: %+v", stored)
+	}
+	if len(res.Scopes) != 2 || res.Scopes[0] != model.ScopeCommentsWrite || res.Scopes[1] != model.ScopeModeration {
+		t.Fatalf("expected deduplicated scopes in canonical order, got %v", res.Scopes)
+	}
+}
+
+func TestCreateAPIKey_Rejected(t *testing.T) {
+	svc := NewAPIKeyService(&mockKeyRepo{})
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		data model.APIKeyCreateData
+		want error
+	}{
+		{"anonymous", context.Background(), model.APIKeyCreateData{Name: "a", Scopes: []string{model.ScopeModeration}}, ErrUnauthorized},
+		{"moderator", asUser(1, model.RoleModerator), model.APIKeyCreateData{Name: "a", Scopes: []string{model.ScopeModeration}}, ErrForbidden},
+		{"key", asKey(model.ScopeModeration), model.APIKeyCreateData{Name: "a", Scopes: []string{model.ScopeModeration}}, ErrForbidden},
+		{"no scopes", asUser(1, model.RoleAdmin), model.APIKeyCreateData{Name: "a"}, ErrInvalidAPIKey},
+		{"unknown scope", asUser(1, model.RoleAdmin), model.APIKeyCreateData{Name: "a", Scopes: []string{"admin"}}, ErrInvalidAPIKey},
+		{"no name", asUser(1, model.RoleAdmin), model.APIKeyCreateData{Name: " ", Scopes: []string{model.ScopeModeration}}, ErrInvalidAPIKey},
+	}
+
+	for _, tt := range tests {
+		if _, err := svc.CreateAPIKey(tt.ctx, &tt.data); !errors.Is(err, tt.want) {
+			t.Fatalf("%s: expected %v, got %v", tt.name, tt.want, err)
+		}
+	}
+}
+
+func TestAuthenticateAPIKey(t *testing.T) {
+	repo := &mockKeyRepo{
+		useFn: func(ctx context.Context, keyHash string) (*model.DBAPIKey, error) {
+			if keyHash != auth.HashSecret("ctk_good") {
+				return nil, repository.ErrAPIKeyNotFound
+			}
+			return &model.DBAPIKey{ID: 1}, nil
+		},
+	}
+
+	svc := NewAPIKeyService(repo)
+
+	if _, ok, err := svc.AuthenticateAPIKey(context.Background(), "ctk_good"); !ok || err != nil {
+		t.Fatalf("expected valid key, got %v %v", ok, err)
+	}
+	if _, ok, err := svc.AuthenticateAPIKey(context.Background(), "ctk_revoked"); ok || err != nil {
+		t.Fatalf("expected unknown key to be rejected without error, got %v %v", ok, err)
+	}
+	if _, ok, _ := svc.AuthenticateAPIKey(context.Background(), "good"); ok {
+		t.Fatalf("expected key without prefix to be rejected")
+	}
+}
+
+func TestCreateComment_APIKey(t *testing.T) {
+	var saved *model.CommentCreateData
+	repo := &mockRepo{
+		createFn: func(ctx context.Context, c *model.CommentCreateData) (*model.DBComment, error) {
+			saved = c
+			return &model.DBComment{ID: 1, Author: c.Author, AuthorID: c.AuthorID}, nil
+		},
+	}
+
+	svc := NewCommentService(repo, Config{})
+
+	res, err := svc.CreateComment(asKey(model.ScopeCommentsWrite), &model.CommentCreateData{Text: "system", Author: "spoofed"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Author != "billing" || res.AuthorID != nil {
+		t.Fatalf("expected comment signed by key name, got %+v", res)
+	}
+	// секрет правки положен только гостям, комментарием ключа управляют права ключа
+	if res.EditToken != "" || saved.EditHash != "" {
+		t.Fatalf("expected no edit token for key comment, got %q", res.EditToken)
+	}
+
+	if _, err := svc.CreateComment(asKey(model.ScopeModeration), &model.CommentCreateData{Text: "system"}); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected ErrForbidden for key without comments:write, got %v", err)
+	}
+}
+
+func TestSetCommentLock_APIKey(t *testing.T) {
+	repo := &mockRepo{
+		setLockFn: func(ctx context.Context, id int, locked bool) (*model.DBComment, error) {
+			return &model.DBComment{ID: id}, nil
+		},
+	}
+
+	svc := NewCommentService(repo, Config{})
+
+	if _, err := svc.SetCommentLock(asKey(model.ScopeModeration), 1, true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := svc.SetCommentLock(asKey(model.ScopeCommentsWrite), 1, true); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected ErrForbidden for key without moderation scope, got %v", err)
+	}
+}
+
+/*
+	PURGER
+*/
+
+func TestPurger_RunOnce(t *testing.T) {
+	retention := 24 * time.Hour
+	repo := &mockRepo{
+		purgeFn: func(ctx context.Context, cutoff time.Time) (int, int, error) {
+			if age := time.Since(cutoff); age < retention || age > retention+time.Minute {
+				t.Fatalf("expected cutoff about retention ago, got %v", age)
+			}
+			return 3, 1, nil
+		},
+	}
+
+	p := NewPurger(repo, time.Hour, retention)
+	for range 2 {
+		if err := p.RunOnce(context.Background()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	st := p.snapshot()
+	if !st.Enabled || st.LastRunAt == nil || st.LastPurged != 3 || st.LastCollapsed != 1 {
+		t.Fatalf("unexpected last run status: %+v", st)
+	}
+	if st.TotalPurged != 6 || st.TotalCollapsed != 2 {
+		t.Fatalf("expected totals to accumulate, got %+v", st)
+	}
+}
+
+func TestPurger_RunOnceError(t *testing.T) {
+	repo := &mockRepo{
+		purgeFn: func(ctx context.Context, cutoff time.Time) (int, int, error) {
+			return 0, 0, errors.New("db down")
+		},
+	}
+
+	p := NewPurger(repo, time.Hour, time.Hour)
+	if err := p.RunOnce(context.Background()); err == nil {
+		t.Fatalf("expected error")
+	}
+	if st := p.snapshot(); st.LastError != "db down" || st.TotalPurged != 0 {
+		t.Fatalf("expected error in status, got %+v", st)
+	}
+}
+
+func TestPurger_StatusRequiresModerator(t *testing.T) {
+	p := NewPurger(&mockRepo{}, time.Hour, time.Hour)
+
+	if _, err := p.Status(context.Background()); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("expected ErrUnauthorized for anonymous, got %v", err)
+	}
+	if _, err := p.Status(asUser(7, model.RoleUser)); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected ErrForbidden for regular user, got %v", err)
+	}
+	if st, err := p.Status(asUser(8, model.RoleModerator)); err != nil || !st.Enabled {
+		t.Fatalf("expected status for moderator, got %+v, %v", st, err)
+	}
+}
+
+func TestPurger_DisabledStartStop(t *testing.T) {
+	p := NewPurger(&mockRepo{}, 0, time.Hour)
+	p.Start()
+	p.Stop()
+
+	if p.snapshot().Enabled {
+		t.Fatalf("expected purger to be disabled")
+	}
 }
 
 /*
@@ -231,7 +2574,7 @@ func TestDeleteComment_HardDelete(t *testing.T) {
 */
 
 func TestRunCommentSearchQuery_Empty(t *testing.T) {
-	svc := NewCommentService(&mockRepo{})
+	svc := NewCommentService(&mockRepo{}, Config{})
 
 	res, err := svc.RunCommentSearchQuery(context.Background(), "")
 	if err != nil {
@@ -244,14 +2587,14 @@ func TestRunCommentSearchQuery_Empty(t *testing.T) {
 
 func TestRunCommentSearchQuery_OK(t *testing.T) {
 	repo := &mockRepo{
-		runSearchFn: func(ctx context.Context, query string) ([]model.DBComment, error) {
+		runSearchFn: func(ctx context.Context, query string, viewer int) ([]model.DBComment, error) {
 			return []model.DBComment{
 				{ID: 1, Text: "match"},
 			}, nil
 		},
 	}
 
-	svc := NewCommentService(repo)
+	svc := NewCommentService(repo, Config{})
 
 	res, err := svc.RunCommentSearchQuery(context.Background(), "match")
 	if err != nil {
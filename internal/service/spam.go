@@ -0,0 +1,155 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/UnendingLoop/CommentTree/internal/model"
+	"github.com/UnendingLoop/CommentTree/internal/mwlogger"
+)
+
+// Настройки спам-классификатора по умолчанию
+const (
+	DefaultSpamThreshold   = 0.9
+	DefaultSpamMinTraining = 10
+)
+
+const maxSpamTokens = 300 // больше разных слов с одного комментария не берём, чтобы длинный текст не раздувал словарь
+
+// SpamConfig - настройки спам-классификатора; нулевой Threshold выключает и оценку, и обучение
+type SpamConfig struct {
+	Threshold   float64 // с этой оценки комментарий отправляется на модерацию
+	MinTraining int     // сколько решений каждого класса нужно, прежде чем оценка начнёт влиять на статус
+}
+
+// spamTokens разбирает комментарий на признаки: нормализованные слова (как в стоп-листе), домены ссылок
+// и признаки автора - аноним, ключ или конкретный пользователь; служебные признаки начинаются с "~"
+func spamTokens(text string, authorID *int, byKey bool, reply bool) []string {
+	tokens := make([]string, 0, 16)
+	seen := make(map[string]struct{})
+	for _, w := range strings.FieldsFunc(text, func(r rune) bool { return !isWordRune(r) }) {
+		w = normalizeWord(w)
+		if _, ok := seen[w]; ok {
+			continue
+		}
+		if n := utf8.RuneCountInString(w); n < 2 || n > 32 {
+			continue
+		}
+		if len(tokens) == maxSpamTokens {
+			break
+		}
+		seen[w] = struct{}{}
+		tokens = append(tokens, w)
+	}
+
+	links := linkPattern.FindAllString(text, -1)
+	for _, link := range links {
+		host := strings.TrimPrefix(strings.ToLower(link), "http://")
+		host = strings.TrimPrefix(strings.TrimPrefix(host, "https://"), "www.")
+		host, _, _ = strings.Cut(host, "/")
+		tokens = append(tokens, "~host:"+host)
+	}
+
+	switch {
+	case authorID != nil:
+		tokens = append(tokens, "~author:user", "~user:"+strconv.Itoa(*authorID))
+	case byKey:
+		tokens = append(tokens, "~author:key")
+	default:
+		tokens = append(tokens, "~author:anon")
+	}
+
+	switch {
+	case len(links) == 0:
+		tokens = append(tokens, "~links:0")
+	case len(links) == 1:
+		tokens = append(tokens, "~links:1")
+	default:
+		tokens = append(tokens, "~links:many")
+	}
+
+	switch n := utf8.RuneCountInString(text); {
+	case n < 20:
+		tokens = append(tokens, "~len:short")
+	case n < 500:
+		tokens = append(tokens, "~len:medium")
+	default:
+		tokens = append(tokens, "~len:long")
+	}
+
+	if reply {
+		tokens = append(tokens, "~reply")
+	}
+	if strings.ToUpper(text) == text && strings.IndexFunc(text, unicode.IsLetter) >= 0 {
+		tokens = append(tokens, "~caps")
+	}
+
+	// классификатор считает документы, а не вхождения: каждый токен учитывается один раз
+	slices.Sort(tokens)
+	return slices.Compact(tokens)
+}
+
+// spamScore - вероятность спама по наивному Байесу: априорные шансы классов плюс вклад каждого токена
+// со сглаживанием Лапласа; токены, которых классификатор не видел, оценку не меняют
+func spamScore(stats *model.DBSpamStats, tokens []string) float64 {
+	spamDocs, hamDocs := float64(stats.SpamDocs), float64(stats.HamDocs)
+	logOdds := math.Log((spamDocs + 1) / (hamDocs + 1))
+	for _, t := range tokens {
+		cnt, ok := stats.Tokens[t]
+		if !ok {
+			continue
+		}
+		logOdds += math.Log((float64(cnt.Spam)+1)/(spamDocs+2)) - math.Log((float64(cnt.Ham)+1)/(hamDocs+2))
+	}
+	return 1 / (1 + math.Exp(-logOdds))
+}
+
+// checkSpam оценивает текст; возвращает оценку для сохранения и причину отправки на модерацию,
+// если оценка выше порога и классификатор уже достаточно обучен. Сбой оценки не мешает сохранить комментарий
+func (c CService) checkSpam(ctx context.Context, tokens []string) (*float64, string) {
+	if c.spam.Threshold <= 0 {
+		return nil, ""
+	}
+	logger := mwlogger.LoggerFromContext(ctx)
+
+	stats, err := c.repo.GetSpamStats(ctx, tokens)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to fetch spam classifier stats, comment is left unscored")
+		return nil, ""
+	}
+
+	score := spamScore(stats, tokens)
+	if stats.SpamDocs < c.spam.MinTraining || stats.HamDocs < c.spam.MinTraining || score < c.spam.Threshold {
+		return &score, ""
+	}
+	return &score, fmt.Sprintf("spam score %.2f", score)
+}
+
+// trainSpam учит классификатор на решении модерации; сбой обучения не отменяет само решение
+func (c CService) trainSpam(ctx context.Context, comment *model.DBComment, label string) {
+	if c.spam.Threshold <= 0 {
+		return
+	}
+	logger := mwlogger.LoggerFromContext(ctx)
+
+	tokens := spamTokens(comment.Text, comment.AuthorID, isKeyComment(comment), comment.ParentID != nil)
+	if err := c.repo.TrainSpam(ctx, comment.ID, label, tokens); err != nil {
+		logger.Error().Err(err).Msg(fmt.Sprintf("Failed to train spam classifier on comment %d as %s", comment.ID, label))
+	}
+}
+
+// isKeyComment - комментарий интеграции: не от пользователя, и секрета правки, как у гостя, у него нет
+func isKeyComment(comment *model.DBComment) bool {
+	return comment.AuthorID == nil && comment.EditHash == ""
+}
+
+// joinReasons склеивает непустые причины отправки на модерацию
+func joinReasons(reasons ...string) string {
+	return strings.Join(slices.DeleteFunc(reasons, func(r string) bool { return r == "" }), "; ")
+}
@@ -0,0 +1,160 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/UnendingLoop/CommentTree/internal/auth"
+	"github.com/UnendingLoop/CommentTree/internal/model"
+	"github.com/UnendingLoop/CommentTree/internal/mwlogger"
+	"github.com/UnendingLoop/CommentTree/internal/repository"
+)
+
+type UserService interface {
+	Register(ctx context.Context, data *model.UserCredentials) (*APPUser, error)
+	Login(ctx context.Context, data *model.UserCredentials) (*APPToken, error)
+	SetUserRole(ctx context.Context, username string, data *model.UserRoleData) (*APPUser, error)
+	CurrentRole(ctx context.Context, userID int) (role string, ok bool, err error)
+}
+
+type APPUser struct {
+	ID        int        `json:"id"`
+	Username  string     `json:"username"`
+	Role      string     `json:"role,omitempty"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+}
+
+// APPToken - ответ на вход: токен передаётся в заголовке Authorization: Bearer <token>
+type APPToken struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+	User      APPUser   `json:"user"`
+}
+
+type UService struct {
+	repo   repository.UserRepository
+	tokens *auth.Tokens
+}
+
+// NewUserService - все регистрирующиеся получают роль user; первый админ назначается командой set-role
+func NewUserService(userRep repository.UserRepository, tokens *auth.Tokens) UserService {
+	return &UService{repo: userRep, tokens: tokens}
+}
+
+func (u UService) Register(ctx context.Context, data *model.UserCredentials) (*APPUser, error) {
+	logger := mwlogger.LoggerFromContext(ctx)
+	data.Username = strings.TrimSpace(data.Username)
+	if !validUsername(data.Username) || len(data.Password) < 8 || len(data.Password) > 72 { // bcrypt учитывает только 72 байта
+		return nil, ErrInvalidUser
+	}
+
+	hash, err := auth.HashPassword(data.Password)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to hash password of new user")
+		return nil, ErrCommon500
+	}
+
+	res, err := u.repo.CreateUser(ctx, data.Username, hash, model.RoleUser)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrUserExists):
+			return nil, err
+		default:
+			logger.Error().Err(err).Msg("Failed to create new user in DB")
+			return nil, ErrCommon500
+		}
+	}
+
+	logger.Info().Msg(fmt.Sprintf("User %d registered with role %q", res.ID, res.Role))
+	return convertToAPPUser(res), nil
+}
+
+func (u UService) Login(ctx context.Context, data *model.UserCredentials) (*APPToken, error) {
+	logger := mwlogger.LoggerFromContext(ctx)
+	user, err := u.repo.GetUserByName(ctx, strings.TrimSpace(data.Username))
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrUserNotFound): // не раскрываем, что именно не совпало
+			return nil, ErrBadCredentials
+		default:
+			logger.Error().Err(err).Msg("Failed to fetch user from DB before login")
+			return nil, ErrCommon500
+		}
+	}
+	if !auth.CheckPassword(user.PasswordHash, data.Password) {
+		return nil, ErrBadCredentials
+	}
+
+	// роль в токене - подсказка для клиента; права проверяются по роли из базы (см. CurrentRole)
+	token, expiresAt, err := u.tokens.Issue(auth.Claims{UserID: user.ID, Name: user.Username, Role: user.Role})
+	if err != nil {
+		logger.Error().Err(err).Msg(fmt.Sprintf("Failed to issue token for user %d", user.ID))
+		return nil, ErrCommon500
+	}
+
+	return &APPToken{Token: token, ExpiresAt: expiresAt, User: APPUser{ID: user.ID, Username: user.Username, Role: user.Role}}, nil
+}
+
+func (u UService) SetUserRole(ctx context.Context, username string, data *model.UserRoleData) (*APPUser, error) {
+	logger := mwlogger.LoggerFromContext(ctx)
+	admin, err := requireRole(ctx, model.RoleAdmin)
+	if err != nil {
+		return nil, err
+	}
+	switch data.Role {
+	case model.RoleUser, model.RoleModerator, model.RoleAdmin:
+	default:
+		return nil, ErrInvalidRole
+	}
+
+	res, err := u.repo.SetUserRole(ctx, username, data.Role)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrUserNotFound):
+			return nil, err
+		default:
+			logger.Error().Err(err).Msg(fmt.Sprintf("Failed to set role %q for user %q", data.Role, username))
+			return nil, ErrCommon500
+		}
+	}
+
+	logger.Info().Msg(fmt.Sprintf("User %d role set to %q by admin %d", res.ID, res.Role, admin.UserID))
+	return convertToAPPUser(res), nil
+}
+
+// CurrentRole - актуальная роль пользователя из базы: mwauth подменяет ею роль из токена на каждом запросе,
+// поэтому понижение или удаление пользователя действует сразу, а не после истечения токена
+func (u UService) CurrentRole(ctx context.Context, userID int) (string, bool, error) {
+	user, err := u.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrUserNotFound):
+			return "", false, nil
+		default:
+			return "", false, err
+		}
+	}
+	return user.Role, true, nil
+}
+
+func convertToAPPUser(u *model.DBUser) *APPUser {
+	return &APPUser{ID: u.ID, Username: u.Username, Role: u.Role, CreatedAt: &u.CreatedAt}
+}
+
+// validUsername - 3-32 символа: буквы, цифры, точка, дефис и подчёркивание
+func validUsername(name string) bool {
+	if n := utf8.RuneCountInString(name); n < 3 || n > 32 {
+		return false
+	}
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("._-", r) {
+			return false
+		}
+	}
+	return true
+}
@@ -1,7 +1,15 @@
 package main
 
-import "github.com/UnendingLoop/CommentTree/cmd"
+import (
+	"os"
+
+	"github.com/UnendingLoop/CommentTree/cmd"
+)
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "set-role" {
+		cmd.SetRole(os.Args[2:])
+		return
+	}
 	cmd.StartApp()
 }